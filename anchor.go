@@ -0,0 +1,91 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/usbarmory/armory-boot/config"
+	"github.com/usbarmory/armory-boot/disk"
+
+	"github.com/usbarmory/tamago/soc/nxp/imx6ul"
+)
+
+// The trusted public key digest is held in the OCOTP bank 7 general purpose
+// fuses, each word holds four digest bytes in little-endian order so that the
+// digest matches the OCOTP NVMEM layout exposed by Linux
+// (37.5 OCOTP Memory Map/Register Definition, IMX6ULLRM).
+const (
+	keyDigestBank  = 7
+	keyDigestWord  = 0
+	keyDigestWords = sha256.Size / 4
+)
+
+// keyDigest returns the public key digest held in fuses, which is only
+// considered set when any of its bits is blown.
+func keyDigest() (digest [sha256.Size]byte, set bool, err error) {
+	if !imx6ul.Native {
+		return
+	}
+
+	for i := 0; i < keyDigestWords; i++ {
+		w, err := imx6ul.OCOTP.Read(keyDigestBank, keyDigestWord+i)
+
+		if err != nil {
+			return digest, false, err
+		}
+
+		binary.LittleEndian.PutUint32(digest[i*4:], w)
+
+		if w != 0 {
+			set = true
+		}
+	}
+
+	return
+}
+
+// anchored returns whether the public key is anchored by a digest held in
+// fuses.
+func anchored() bool {
+	_, set, err := keyDigest()
+	return set || err != nil
+}
+
+// anchoredKey returns the public key string whose digest matches the one held
+// in fuses, candidates are each compiled public key followed by the boot
+// partition public key file.
+func anchoredKey(part *disk.Partition, digest [sha256.Size]byte) (s string, err error) {
+	match := func(c string) bool {
+		d := config.KeyDigest(c)
+		return subtle.ConstantTimeCompare(d[:], digest[:]) == 1
+	}
+
+	for _, c := range strings.Split(PublicKeyStr, ",") {
+		if len(c) > 0 && match(c) {
+			return strings.TrimSpace(c), nil
+		}
+	}
+
+	buf, err := part.ReadAll(config.DefaultKeyPath)
+
+	if err != nil {
+		return "", fmt.Errorf("no compiled public key matches fused digest, %v", err)
+	}
+
+	if !match(string(buf)) {
+		return "", fmt.Errorf("%s does not match fused digest", config.DefaultKeyPath)
+	}
+
+	log.Printf("armory-boot: using anchored public key %s", config.DefaultKeyPath)
+
+	return strings.TrimSpace(string(buf)), nil
+}
@@ -0,0 +1,248 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// Package bootinfo implements the boot information block format, passed by
+// armory-boot to ELF unikernels, which carries the configuration command line
+// and environment along with boot parameters.
+//
+// The block is written at the fixed Address, within the memory region
+// reserved for the booted image, and its address is also passed in register
+// r2 at the image entry point.
+//
+// The TamaGo runtime does not preserve r2 on initialization, and it uses all
+// RAM between its configured start and end for the stack and heap, which are
+// initialized before any Go code (including init functions) runs. Therefore
+// the block is only guaranteed to be intact if the unikernel memory layout
+// excludes it, by ending RAM at Address (i.e. `runtime/goos.RamSize` set to
+// `Address - RamStart`, with the `linkramsize` build tag):
+//
+//	//go:linkname ramSize runtime/goos.RamSize
+//	var ramSize uint32 = bootinfo.Address - 0x80000000
+//
+// Unikernels which cannot adjust their memory layout must instead save r2,
+// and copy the block, in their entry point assembly before jumping to the Go
+// runtime initialization. In both cases the block is read with Read().
+package bootinfo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
+	"sync/atomic"
+	"unsafe"
+)
+
+const (
+	// Address is the boot information block memory address.
+	Address = 0x8fff0000
+	// Size is the maximum boot information block size.
+	Size = 0x10000
+)
+
+const (
+	magic      = "ABBI"
+	version    = 1
+	headerSize = 16
+)
+
+// Info represents the boot information passed to ELF unikernels.
+type Info struct {
+	// Entry is the image entry point address.
+	Entry uint32
+	// LoadAddress is the lowest address of the loaded image segments.
+	LoadAddress uint32
+	// MemoryStart is the start address of the memory region reserved for
+	// the booted image.
+	MemoryStart uint32
+	// MemorySize is the size of the memory region reserved for the booted
+	// image.
+	MemorySize uint32
+	// BootDevice is the boot media ("eMMC" or "uSD").
+	BootDevice string
+	// ConfigDigest is the SHA256 digest of the verified armory-boot
+	// configuration file.
+	ConfigDigest [32]byte
+	// CmdLine is the configuration command line.
+	CmdLine string
+	// Env is the configuration environment, as key=value strings.
+	Env []string
+}
+
+type header struct {
+	Magic    [4]byte
+	Version  uint32
+	Length   uint32
+	Checksum uint32
+}
+
+type params struct {
+	Entry        uint32
+	LoadAddress  uint32
+	MemoryStart  uint32
+	MemorySize   uint32
+	ConfigDigest [32]byte
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	binary.Write(buf, binary.LittleEndian, uint32(len(s)))
+	buf.WriteString(s)
+}
+
+func readString(r *bytes.Reader) (s string, err error) {
+	var n uint32
+
+	if err = binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return
+	}
+
+	if int64(n) > int64(r.Len()) {
+		return "", errors.New("invalid string length")
+	}
+
+	b := make([]byte, n)
+	_, err = io.ReadFull(r, b)
+
+	return string(b), err
+}
+
+// MarshalBinary implements the [encoding.BinaryMarshaler] interface.
+func (info *Info) MarshalBinary() (data []byte, err error) {
+	buf := new(bytes.Buffer)
+
+	p := &params{
+		Entry:        info.Entry,
+		LoadAddress:  info.LoadAddress,
+		MemoryStart:  info.MemoryStart,
+		MemorySize:   info.MemorySize,
+		ConfigDigest: info.ConfigDigest,
+	}
+
+	binary.Write(buf, binary.LittleEndian, p)
+	writeString(buf, info.BootDevice)
+	writeString(buf, info.CmdLine)
+	binary.Write(buf, binary.LittleEndian, uint32(len(info.Env)))
+
+	for _, env := range info.Env {
+		writeString(buf, env)
+	}
+
+	if headerSize+buf.Len() > Size {
+		return nil, fmt.Errorf("boot information exceeds %d bytes", Size)
+	}
+
+	h := &header{
+		Version:  version,
+		Length:   uint32(buf.Len()),
+		Checksum: crc32.ChecksumIEEE(buf.Bytes()),
+	}
+
+	copy(h.Magic[:], magic)
+
+	out := new(bytes.Buffer)
+	binary.Write(out, binary.LittleEndian, h)
+	out.Write(buf.Bytes())
+
+	return out.Bytes(), nil
+}
+
+// UnmarshalBinary implements the [encoding.BinaryUnmarshaler] interface.
+func (info *Info) UnmarshalBinary(data []byte) (err error) {
+	h := &header{}
+
+	if err = binary.Read(bytes.NewReader(data), binary.LittleEndian, h); err != nil {
+		return
+	}
+
+	if string(h.Magic[:]) != magic {
+		return errors.New("invalid boot information magic")
+	}
+
+	if h.Version != version {
+		return fmt.Errorf("unsupported boot information version %d", h.Version)
+	}
+
+	if h.Length > Size-headerSize || int(h.Length) > len(data)-headerSize {
+		return errors.New("invalid boot information length")
+	}
+
+	data = data[headerSize : headerSize+h.Length]
+
+	if h.Checksum != crc32.ChecksumIEEE(data) {
+		return errors.New("invalid boot information checksum")
+	}
+
+	r := bytes.NewReader(data)
+	p := &params{}
+
+	if err = binary.Read(r, binary.LittleEndian, p); err != nil {
+		return
+	}
+
+	info.Entry = p.Entry
+	info.LoadAddress = p.LoadAddress
+	info.MemoryStart = p.MemoryStart
+	info.MemorySize = p.MemorySize
+	info.ConfigDigest = p.ConfigDigest
+
+	if info.BootDevice, err = readString(r); err != nil {
+		return
+	}
+
+	if info.CmdLine, err = readString(r); err != nil {
+		return
+	}
+
+	var n uint32
+
+	if err = binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return
+	}
+
+	if int64(n) > int64(r.Len()) {
+		return errors.New("invalid environment length")
+	}
+
+	info.Env = make([]string, n)
+
+	for i := range info.Env {
+		if info.Env[i], err = readString(r); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// Getenv returns the value of the argument environment key.
+func (info *Info) Getenv(key string) (val string, ok bool) {
+	for _, env := range info.Env {
+		if k, v, found := strings.Cut(env, "="); found && k == key {
+			return v, true
+		}
+	}
+
+	return
+}
+
+// Read parses the boot information block at Address, it must only be invoked
+// by unikernels booted by armory-boot.
+func Read() (info *Info, err error) {
+	var ptr unsafe.Pointer
+
+	buf := make([]byte, Size)
+
+	for i := 0; i < Size; i += 4 {
+		binary.LittleEndian.PutUint32(buf[i:], atomic.LoadUint32((*uint32)(unsafe.Add(ptr, uintptr(Address)+uintptr(i)))))
+	}
+
+	info = &Info{}
+	err = info.UnmarshalBinary(buf)
+
+	return
+}
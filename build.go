@@ -12,9 +12,34 @@ var (
 	Revision string
 
 	// Boot device
-	Boot  string
-	Start string
+	Boot          string
+	Start         string
+	State         string
+	RollbackState string
+
+	// Provisioning mode
+	Provision string
 
 	// Authentication key
 	PublicKeyStr string
+
+	// Post-quantum authentication key (hybrid mode)
+	PQPublicKeyStr string
+
+	// Minimum number of distinct signing keys
+	Threshold string
+
+	// TUF trusted root metadata
+	TUFRoot string
+
+	// Device class
+	Class string
+
+	// Trusted comment policy options
+	CommentPolicy string
+
+	// Transparency log and witness keys
+	LogKey        string
+	WitnessKeys   string
+	WitnessQuorum string
 )
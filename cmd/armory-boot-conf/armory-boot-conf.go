@@ -0,0 +1,710 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// This tool generates, hashes and signs armory-boot configuration files on
+// the host, it also generates minisign compatible Ed25519 keys and prints the
+// public key string expected by the armory-boot PUBLIC_KEY build variable, as
+// well as ML-DSA keys for the PQ_PUBLIC_KEY one. Public keys can also be
+// anchored by a digest programmed in the device fuses, and kernel images can
+// be encrypted for a device class.
+
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/usbarmory/armory-boot/config"
+)
+
+const usage = `usage: armory-boot-conf <command> [options] [arguments]
+
+commands:
+  genkey   generate a minisign key pair
+  pqgenkey generate a post-quantum (ML-DSA) key pair
+  pubkey   print the PUBLIC_KEY (and PQ_PUBLIC_KEY) build variable for public key files
+  fusekey  print (and optionally program) the fused digest anchoring public key files
+  create   create (and optionally sign) a configuration for a boot directory
+  sign     sign files
+  hash     print file digests
+  encrypt  encrypt kernel images for a device class
+  convert  convert a configuration file to the version 2 format
+`
+
+type Config struct {
+	// genkey, pqgenkey
+	pubKey string
+	noPass bool
+	// pqgenkey
+	pqAlg string
+	// genkey, pqgenkey, create, sign
+	secKey  string
+	comment string
+	// create, sign
+	pqKey string
+	// fusekey
+	nvmem string
+	// create, fusekey
+	output string
+	// create
+	root      string
+	kernel    string
+	dtb       string
+	initrd    string
+	unikernel string
+	cmdline   string
+	detached  bool
+	// create, hash, encrypt
+	alg string
+	// encrypt
+	classKey string
+}
+
+var conf *Config
+
+func init() {
+	log.SetFlags(0)
+	log.SetOutput(os.Stdout)
+
+	conf = &Config{}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var cmd func(args []string) error
+
+	flags := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+
+	switch os.Args[1] {
+	case "genkey":
+		flags.StringVar(&conf.pubKey, "p", "armory-boot.pub", "public key output file")
+		flags.StringVar(&conf.secKey, "s", "armory-boot.key", "secret key output file")
+		flags.BoolVar(&conf.noPass, "W", false, "do not encrypt the secret key with a password")
+		cmd = genKey
+	case "pqgenkey":
+		flags.StringVar(&conf.pqAlg, "a", "ML-DSA-65", "algorithm (ML-DSA-44, ML-DSA-65, ML-DSA-87)")
+		flags.StringVar(&conf.pubKey, "p", "armory-boot.pq.pub", "public key output file")
+		flags.StringVar(&conf.secKey, "s", "armory-boot.pq.key", "secret key output file")
+		flags.BoolVar(&conf.noPass, "W", false, "do not encrypt the secret key with a password")
+		cmd = pqGenKey
+	case "pubkey":
+		cmd = pubKey
+	case "fusekey":
+		flags.StringVar(&conf.output, "o", "", "public key output file (e.g. <root>"+config.DefaultKeyPath+")")
+		flags.StringVar(&conf.nvmem, "w", "", "OCOTP NVMEM device for fuse programming (e.g. /sys/bus/nvmem/devices/imx-ocotp0/nvmem)")
+		cmd = fuseKey
+	case "create":
+		flags.StringVar(&conf.root, "r", ".", "boot partition root directory")
+		flags.StringVar(&conf.output, "o", "", "configuration output file (default <root>"+config.DefaultConfigPath+")")
+		flags.StringVar(&conf.kernel, "k", "", "kernel image path (default: scan <root>/boot for zImage*)")
+		flags.StringVar(&conf.dtb, "t", "", "device tree blob path (default: scan <root>/boot for *.dtb)")
+		flags.StringVar(&conf.initrd, "i", "", "initial ramdisk path (default: scan <root>/boot for initrd*)")
+		flags.StringVar(&conf.unikernel, "u", "", "ELF unikernel path (disables scanning)")
+		flags.StringVar(&conf.cmdline, "c", "", "kernel command line")
+		flags.StringVar(&conf.alg, "a", config.SHA256, "digest algorithm")
+		flags.BoolVar(&conf.detached, "D", false, "use detached image signatures rather than digests")
+		flags.StringVar(&conf.secKey, "s", "", "secret key for configuration (and detached image) signing")
+		flags.StringVar(&conf.comment, "C", "", "additional trusted comment fields (e.g. version:2 class:mk2)")
+		flags.StringVar(&conf.pqKey, "q", "", "post-quantum secret key for hybrid signatures")
+		cmd = create
+	case "sign":
+		flags.StringVar(&conf.secKey, "s", "armory-boot.key", "secret key")
+		flags.StringVar(&conf.comment, "C", "", "additional trusted comment fields (e.g. version:2 class:mk2)")
+		flags.StringVar(&conf.pqKey, "q", "", "post-quantum secret key for hybrid signatures")
+		cmd = sign
+	case "hash":
+		flags.StringVar(&conf.alg, "a", config.SHA256, "digest algorithm")
+		cmd = hash
+	case "encrypt":
+		flags.StringVar(&conf.alg, "a", config.AES256GCM, "encryption algorithm ("+config.AES256GCM+", "+config.AES256CBCHMAC+")")
+		flags.StringVar(&conf.classKey, "K", "", "device class key file")
+		flags.StringVar(&conf.output, "o", "", "encrypted image output file (default <image>.enc)")
+		cmd = encrypt
+	case "convert":
+		cmd = convert
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	flags.Parse(os.Args[2:])
+
+	if err := cmd(flags.Args()); err != nil {
+		log.Fatalf("armory-boot-conf: %s error, %v", os.Args[1], err)
+	}
+}
+
+func readPassword(prompt string) ([]byte, error) {
+	fmt.Fprint(os.Stderr, prompt)
+
+	s, err := bufio.NewReader(os.Stdin).ReadString('\n')
+
+	if err != nil && len(s) == 0 {
+		return nil, err
+	}
+
+	return []byte(strings.TrimRight(s, "\r\n")), nil
+}
+
+func newPassword() (password []byte, err error) {
+	if conf.noPass {
+		return
+	}
+
+	if password, err = readPassword("password: "); err != nil {
+		return
+	}
+
+	if len(password) == 0 {
+		return nil, errors.New("empty password (see -W)")
+	}
+
+	return
+}
+
+func writeKeys(pub []byte, sec []byte) (err error) {
+	if err = os.WriteFile(conf.secKey, sec, 0600); err != nil {
+		return
+	}
+
+	return os.WriteFile(conf.pubKey, pub, 0644)
+}
+
+func genKey(_ []string) (err error) {
+	password, err := newPassword()
+
+	if err != nil {
+		return
+	}
+
+	pub, sec, err := GenerateKey(password)
+
+	if err != nil {
+		return
+	}
+
+	if err = writeKeys(pub, sec); err != nil {
+		return
+	}
+
+	s, err := PublicKeyString(pub)
+
+	if err != nil {
+		return
+	}
+
+	log.Printf("PUBLIC_KEY=%s", s)
+
+	return
+}
+
+func pqGenKey(_ []string) (err error) {
+	alg, err := PQAlgorithm(conf.pqAlg)
+
+	if err != nil {
+		return
+	}
+
+	password, err := newPassword()
+
+	if err != nil {
+		return
+	}
+
+	pub, sec, err := GeneratePQKey(alg, password)
+
+	if err != nil {
+		return
+	}
+
+	if err = writeKeys(pub, sec); err != nil {
+		return
+	}
+
+	s, err := PQPublicKeyString(pub)
+
+	if err != nil {
+		return
+	}
+
+	log.Printf("PQ_PUBLIC_KEY=%s", s)
+
+	return
+}
+
+func pubKey(args []string) (err error) {
+	var keys []string
+	var pqKeys []string
+
+	if len(args) == 0 {
+		return errors.New("missing public key files")
+	}
+
+	for _, p := range args {
+		buf, err := os.ReadFile(p)
+
+		if err != nil {
+			return err
+		}
+
+		if s, err := PQPublicKeyString(buf); err == nil {
+			pqKeys = append(pqKeys, s)
+			continue
+		}
+
+		s, err := PublicKeyString(buf)
+
+		if err != nil {
+			return fmt.Errorf("invalid public key %s, %v", p, err)
+		}
+
+		keys = append(keys, s)
+	}
+
+	s := strings.Join(keys, ",")
+	pq := strings.Join(pqKeys, ",")
+
+	// ensure the result is accepted by armory-boot
+	if _, err = config.NewKeys(strings.Join(append(keys, pqKeys...), ",")); err != nil {
+		return
+	}
+
+	log.Printf("PUBLIC_KEY=%s", s)
+
+	if len(pq) > 0 {
+		log.Printf("PQ_PUBLIC_KEY=%s", pq)
+	}
+
+	return
+}
+
+func fuseKey(args []string) (err error) {
+	var keys []string
+
+	if len(args) == 0 {
+		return errors.New("missing public key files")
+	}
+
+	for _, p := range args {
+		buf, err := os.ReadFile(p)
+
+		if err != nil {
+			return err
+		}
+
+		if _, err := PQPublicKeyString(buf); err == nil {
+			return fmt.Errorf("post-quantum public key %s cannot be anchored", p)
+		}
+
+		s, err := PublicKeyString(buf)
+
+		if err != nil {
+			// OpenSSH, OpenPGP and X.509 keys in PUBLIC_KEY format
+			s = strings.TrimSpace(string(buf))
+		}
+
+		keys = append(keys, s)
+	}
+
+	s := strings.Join(keys, ",")
+
+	// ensure the result is accepted by armory-boot
+	if _, err = config.NewKeys(s); err != nil {
+		return
+	}
+
+	d := config.KeyDigest(s)
+
+	log.Printf("PUBLIC_KEY=%s", s)
+	log.Printf("digest: %x", d)
+
+	for i := 0; i < len(d); i += nvmemWordSize {
+		log.Printf("OCOTP bank %d word %d: %#.8x", keyDigestBank, keyDigestWord+i/nvmemWordSize, binary.LittleEndian.Uint32(d[i:]))
+	}
+
+	if len(conf.output) > 0 {
+		if err = os.WriteFile(conf.output, []byte(s+"\n"), 0644); err != nil {
+			return
+		}
+	}
+
+	if len(conf.nvmem) == 0 {
+		return
+	}
+
+	if err = ProgramKeyDigest(conf.nvmem, d); err != nil {
+		return
+	}
+
+	log.Printf("programmed %s", conf.nvmem)
+
+	return
+}
+
+func loadSecretKey() (sk *SecretKey, err error) {
+	buf, err := os.ReadFile(conf.secKey)
+
+	if err != nil {
+		return
+	}
+
+	sk, err = ParseSecretKey(buf, func() ([]byte, error) {
+		return readPassword("password: ")
+	})
+
+	if err != nil || len(conf.pqKey) == 0 {
+		return
+	}
+
+	if buf, err = os.ReadFile(conf.pqKey); err != nil {
+		return nil, err
+	}
+
+	sk.PQ, err = ParsePQSecretKey(buf, func() ([]byte, error) {
+		return readPassword("post-quantum key password: ")
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid post-quantum key, %v", err)
+	}
+
+	return
+}
+
+func trustedComment(p string) string {
+	c := fmt.Sprintf("%s:%d\t%s:%s", config.MetadataTimestamp, time.Now().Unix(), config.MetadataFile, filepath.Base(p))
+
+	if len(conf.comment) > 0 {
+		c += "\t" + strings.Join(strings.Fields(conf.comment), "\t")
+	}
+
+	return c
+}
+
+func signFile(sk *SecretKey, p string) (err error) {
+	buf, err := os.ReadFile(p)
+
+	if err != nil {
+		return
+	}
+
+	sig, err := sk.Sign(buf, trustedComment(p))
+
+	if err != nil {
+		return
+	}
+
+	if err = os.WriteFile(p+config.SignatureSuffix, sig, 0644); err != nil {
+		return
+	}
+
+	log.Printf("signed %s", p)
+
+	return
+}
+
+func sign(args []string) (err error) {
+	if len(args) == 0 {
+		return errors.New("missing files")
+	}
+
+	sk, err := loadSecretKey()
+
+	if err != nil {
+		return
+	}
+
+	for _, p := range args {
+		if err = signFile(sk, p); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func digest(buf []byte, alg string) (string, error) {
+	sum, err := config.Sum(alg, buf)
+
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s:%x", alg, sum), nil
+}
+
+func hash(args []string) (err error) {
+	if len(args) == 0 {
+		return errors.New("missing files")
+	}
+
+	for _, p := range args {
+		buf, err := os.ReadFile(p)
+
+		if err != nil {
+			return err
+		}
+
+		d, err := digest(buf, conf.alg)
+
+		if err != nil {
+			return err
+		}
+
+		log.Printf("%s  %s", d, p)
+	}
+
+	return
+}
+
+func encrypt(args []string) (err error) {
+	if len(args) != 1 {
+		return errors.New("usage: encrypt -K <class key> [-a <algorithm>] [-o <output>] <image>")
+	}
+
+	key, err := os.ReadFile(conf.classKey)
+
+	if err != nil {
+		return
+	}
+
+	buf, err := os.ReadFile(args[0])
+
+	if err != nil {
+		return
+	}
+
+	d := make([]byte, 32)
+
+	if _, err = rand.Read(d); err != nil {
+		return
+	}
+
+	e := &config.Encryption{
+		Algorithm:   conf.alg,
+		Diversifier: hex.EncodeToString(d),
+	}
+
+	out, err := e.Encrypt(key, buf)
+
+	if err != nil {
+		return
+	}
+
+	output := conf.output
+
+	if len(output) == 0 {
+		output = args[0] + ".enc"
+	}
+
+	if err = os.WriteFile(output, out, 0644); err != nil {
+		return
+	}
+
+	sum, err := digest(out, config.SHA256)
+
+	if err != nil {
+		return
+	}
+
+	j, err := json.MarshalIndent(&config.Image{
+		Path:       path.Join(path.Dir(config.DefaultConfigPath), filepath.Base(output)),
+		Digest:     sum,
+		Encryption: e,
+	}, "", "  ")
+
+	if err != nil {
+		return
+	}
+
+	log.Printf("%s", j)
+
+	return
+}
+
+func convert(args []string) (err error) {
+	if len(args) != 2 {
+		return errors.New("usage: convert <input> <output>")
+	}
+
+	buf, err := os.ReadFile(args[0])
+
+	if err != nil {
+		return
+	}
+
+	out, err := config.Convert(buf)
+
+	if err != nil {
+		return
+	}
+
+	return os.WriteFile(args[1], append(out, '\n'), 0644)
+}
+
+// scan returns the only file within the boot directory matching the argument
+// pattern, an empty string is returned if none is found.
+func scan(pattern string, required bool) (p string, err error) {
+	matches, err := filepath.Glob(filepath.Join(conf.root, path.Dir(config.DefaultConfigPath), pattern))
+
+	if err != nil {
+		return
+	}
+
+	switch {
+	case len(matches) == 0 && required:
+		return "", fmt.Errorf("no file matching %s found, please specify its path", pattern)
+	case len(matches) == 0:
+		return
+	case len(matches) > 1:
+		return "", fmt.Errorf("multiple files matching %s found (%s), please specify its path", pattern, strings.Join(matches, ", "))
+	}
+
+	return matches[0], nil
+}
+
+// param represents a kernel image configuration parameter, along with its
+// host path and scanning pattern.
+type param struct {
+	path     *string
+	image    **config.Image
+	pattern  string
+	required bool
+}
+
+// image returns the configuration parameter for the argument host file,
+// whose path is converted to an absolute one within the boot partition.
+func image(p string) (i *config.Image, err error) {
+	rel, err := filepath.Rel(conf.root, p)
+
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return nil, fmt.Errorf("%s is outside of %s", p, conf.root)
+	}
+
+	buf, err := os.ReadFile(p)
+
+	if err != nil {
+		return
+	}
+
+	i = &config.Image{
+		Path: "/" + filepath.ToSlash(rel),
+		Size: int64(len(buf)),
+	}
+
+	if conf.detached {
+		return
+	}
+
+	i.Digest, err = digest(buf, conf.alg)
+
+	return
+}
+
+func create(_ []string) (err error) {
+	var images []string
+
+	c := &config.Config{
+		Version:  config.Version2,
+		CmdLine:  conf.cmdline,
+		Detached: conf.detached,
+	}
+
+	params := []param{
+		{&conf.kernel, &c.KernelImage, "zImage*", true},
+		{&conf.dtb, &c.DeviceTreeBlobImage, "*.dtb", true},
+		{&conf.initrd, &c.InitialRamDiskImage, "initrd*", false},
+	}
+
+	if len(conf.unikernel) > 0 {
+		params = []param{
+			{&conf.unikernel, &c.UnikernelImage, "", true},
+		}
+	}
+
+	for _, p := range params {
+		if len(*p.path) == 0 {
+			if *p.path, err = scan(p.pattern, p.required); err != nil {
+				return
+			}
+		}
+
+		if len(*p.path) == 0 {
+			continue
+		}
+
+		if *p.image, err = image(*p.path); err != nil {
+			return
+		}
+
+		images = append(images, *p.path)
+	}
+
+	if err = c.Validate(); err != nil {
+		return
+	}
+
+	buf, err := json.MarshalIndent(c, "", "  ")
+
+	if err != nil {
+		return
+	}
+
+	output := conf.output
+
+	if len(output) == 0 {
+		output = filepath.Join(conf.root, config.DefaultConfigPath)
+	}
+
+	if err = os.WriteFile(output, append(buf, '\n'), 0644); err != nil {
+		return
+	}
+
+	log.Printf("created %s", output)
+
+	if len(conf.secKey) == 0 {
+		if conf.detached {
+			log.Printf("warning: images must be signed (see sign)")
+		}
+
+		return
+	}
+
+	sk, err := loadSecretKey()
+
+	if err != nil {
+		return
+	}
+
+	if conf.detached && sk.signify {
+		return errors.New("detached signatures require trusted comments, not supported by signify keys")
+	}
+
+	if conf.detached {
+		for _, p := range images {
+			if err = signFile(sk, p); err != nil {
+				return
+			}
+		}
+	}
+
+	return signFile(sk, output)
+}
@@ -0,0 +1,77 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// The trusted public key digest location, which must match the armory-boot
+// one (OCOTP bank 7 general purpose fuses).
+const (
+	keyDigestBank = 7
+	keyDigestWord = 0
+)
+
+// OCOTP NVMEM layout, as exposed by the Linux imx-ocotp driver.
+const (
+	nvmemWordSize  = 4
+	nvmemBankWords = 8
+)
+
+// KeyDigestOffset returns the NVMEM offset of the trusted public key digest.
+func KeyDigestOffset() int64 {
+	return (keyDigestBank*nvmemBankWords + keyDigestWord) * nvmemWordSize
+}
+
+// ProgramKeyDigest blows the argument digest in the trusted public key digest
+// fuses through a Linux OCOTP NVMEM device (e.g.
+// `/sys/bus/nvmem/devices/imx-ocotp0/nvmem`), fuses which already hold a
+// different value are never modified.
+func ProgramKeyDigest(nvmem string, digest [sha256.Size]byte) (err error) {
+	f, err := os.OpenFile(nvmem, os.O_RDWR, 0)
+
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	off := KeyDigestOffset()
+	cur := make([]byte, sha256.Size)
+
+	if _, err = f.ReadAt(cur, off); err != nil {
+		return fmt.Errorf("could not read fuses, %v", err)
+	}
+
+	if bytes.Equal(cur, digest[:]) {
+		return
+	}
+
+	if !bytes.Equal(cur, make([]byte, sha256.Size)) {
+		return errors.New("fuses already programmed with a different digest")
+	}
+
+	// the driver only accepts single word writes
+	for i := 0; i < sha256.Size; i += nvmemWordSize {
+		if _, err = f.WriteAt(digest[i:i+nvmemWordSize], off+int64(i)); err != nil {
+			return fmt.Errorf("could not blow fuses, %v", err)
+		}
+	}
+
+	if _, err = f.ReadAt(cur, off); err != nil {
+		return fmt.Errorf("could not read fuses, %v", err)
+	}
+
+	if !bytes.Equal(cur, digest[:]) {
+		return errors.New("fuse verification failure")
+	}
+
+	return
+}
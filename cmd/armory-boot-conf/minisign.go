@@ -0,0 +1,328 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/scrypt"
+
+	"github.com/usbarmory/armory-boot/config"
+)
+
+// minisign key parameters
+// (https://jedisct1.github.io/minisign/#secret-key-format)
+const (
+	opsLimit = 33554432
+	memLimit = 1073741824
+
+	skSize = 8 + 64 + 32
+)
+
+var (
+	sigAlgorithm      = []byte("Ed")
+	prehashAlgorithm  = []byte("ED")
+	kdfAlgorithm      = []byte("Sc")
+	checksumAlgorithm = []byte("B2")
+)
+
+// SecretKey represents a minisign or signify Ed25519 secret key.
+type SecretKey struct {
+	KeyId [8]byte
+	Key   ed25519.PrivateKey
+	// signify keys do not support prehashing and trusted comments
+	signify bool
+	// optional post-quantum key for hybrid signatures
+	PQ *PQSecretKey
+}
+
+// scryptParams implements the libsodium crypto_pwhash_scryptsalsa208sha256
+// parameter selection used by minisign.
+func scryptParams(ops uint64, mem uint64) (n int, r int, p int) {
+	r = 8
+
+	if ops < 32768 {
+		ops = 32768
+	}
+
+	var maxN uint64
+	var nLog2 uint
+
+	if ops < mem/32 {
+		p = 1
+		maxN = ops / uint64(r*4)
+	} else {
+		maxN = mem / uint64(r*128)
+	}
+
+	for nLog2 = 1; nLog2 < 63; nLog2++ {
+		if uint64(1)<<nLog2 > maxN/2 {
+			break
+		}
+	}
+
+	if ops >= mem/32 {
+		maxRP := (ops / 4) / (uint64(1) << nLog2)
+
+		if maxRP > 0x3fffffff {
+			maxRP = 0x3fffffff
+		}
+
+		p = int(maxRP) / r
+	}
+
+	return 1 << nLog2, r, p
+}
+
+func kdf(password []byte, salt []byte, ops uint64, mem uint64) ([]byte, error) {
+	n, r, p := scryptParams(ops, mem)
+	return scrypt.Key(password, salt, n, r, p, skSize)
+}
+
+func xor(dst []byte, key []byte) {
+	for i := range dst {
+		dst[i] ^= key[i]
+	}
+}
+
+func encode(comment string, buf []byte) []byte {
+	return []byte(fmt.Sprintf("untrusted comment: %s\n%s\n", comment, base64.StdEncoding.EncodeToString(buf)))
+}
+
+func decode(buf []byte) (comment string, data []byte, err error) {
+	lines := strings.Split(strings.TrimSpace(string(buf)), "\n")
+
+	if len(lines) < 2 || !strings.HasPrefix(lines[0], "untrusted comment: ") {
+		return "", nil, errors.New("invalid key format")
+	}
+
+	data, err = base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+
+	return lines[0], data, err
+}
+
+// GenerateKey generates a minisign key pair, the secret key is encrypted
+// unless the password is empty.
+func GenerateKey(password []byte) (pub []byte, sec []byte, err error) {
+	pk, sk, err := ed25519.GenerateKey(rand.Reader)
+
+	if err != nil {
+		return
+	}
+
+	var id [8]byte
+
+	if _, err = rand.Read(id[:]); err != nil {
+		return
+	}
+
+	keyID := config.KeyID(id)
+
+	p := new(bytes.Buffer)
+	p.Write(sigAlgorithm)
+	p.Write(id[:])
+	p.Write(pk)
+
+	keynum := new(bytes.Buffer)
+	keynum.Write(id[:])
+	keynum.Write(sk)
+
+	h, _ := blake2b.New256(nil)
+	h.Write(sigAlgorithm)
+	h.Write(keynum.Bytes())
+	keynum.Write(h.Sum(nil))
+
+	salt := make([]byte, 32)
+	alg := []byte{0, 0}
+	ops, mem := uint64(0), uint64(0)
+
+	if len(password) > 0 {
+		if _, err = rand.Read(salt); err != nil {
+			return
+		}
+
+		alg, ops, mem = kdfAlgorithm, opsLimit, memLimit
+
+		stream, err := kdf(password, salt, ops, mem)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		xor(keynum.Bytes(), stream)
+	}
+
+	s := new(bytes.Buffer)
+	s.Write(sigAlgorithm)
+	s.Write(alg)
+	s.Write(checksumAlgorithm)
+	s.Write(salt)
+	binary.Write(s, binary.LittleEndian, ops)
+	binary.Write(s, binary.LittleEndian, mem)
+	s.Write(keynum.Bytes())
+
+	pub = encode("minisign public key "+keyID, p.Bytes())
+	sec = encode("minisign secret key "+keyID, s.Bytes())
+
+	return
+}
+
+// PublicKeyString returns the encoded public key, as expected by
+// config.NewKeys(), from a minisign or signify public key file.
+func PublicKeyString(buf []byte) (s string, err error) {
+	_, data, err := decode(buf)
+
+	if err != nil {
+		return
+	}
+
+	s = base64.StdEncoding.EncodeToString(data)
+
+	if _, err = config.NewPublicKey(s); err != nil {
+		return "", err
+	}
+
+	return
+}
+
+// ParseSecretKey parses a minisign secret key, decrypting it with the
+// argument password if necessary, or an unencrypted signify secret key.
+func ParseSecretKey(buf []byte, password func() ([]byte, error)) (sk *SecretKey, err error) {
+	comment, data, err := decode(buf)
+
+	if err != nil {
+		return
+	}
+
+	if strings.Contains(comment, "signify") {
+		return parseSignifyKey(data)
+	}
+
+	if len(data) != 2+2+2+32+8+8+skSize || !bytes.Equal(data[0:2], sigAlgorithm) {
+		return nil, errors.New("invalid minisign secret key")
+	}
+
+	salt := data[6:38]
+	ops := binary.LittleEndian.Uint64(data[38:])
+	mem := binary.LittleEndian.Uint64(data[46:])
+	keynum := data[54:]
+
+	switch {
+	case bytes.Equal(data[2:4], kdfAlgorithm):
+		pw, err := password()
+
+		if err != nil {
+			return nil, err
+		}
+
+		stream, err := kdf(pw, salt, ops, mem)
+
+		if err != nil {
+			return nil, err
+		}
+
+		xor(keynum, stream)
+	case bytes.Equal(data[2:4], []byte{0, 0}):
+	default:
+		return nil, errors.New("unsupported key derivation algorithm")
+	}
+
+	h, _ := blake2b.New256(nil)
+	h.Write(sigAlgorithm)
+	h.Write(keynum[0:72])
+
+	if !bytes.Equal(h.Sum(nil), keynum[72:104]) {
+		return nil, errors.New("invalid secret key checksum (wrong password?)")
+	}
+
+	sk = &SecretKey{
+		Key: ed25519.PrivateKey(bytes.Clone(keynum[8:72])),
+	}
+
+	copy(sk.KeyId[:], keynum[0:8])
+
+	return
+}
+
+func parseSignifyKey(data []byte) (sk *SecretKey, err error) {
+	if len(data) != 2+2+4+16+8+8+64 || !bytes.Equal(data[0:2], sigAlgorithm) {
+		return nil, errors.New("invalid signify secret key")
+	}
+
+	if binary.BigEndian.Uint32(data[4:8]) != 0 {
+		return nil, errors.New("encrypted signify keys are not supported (see signify -n)")
+	}
+
+	key := data[40:104]
+
+	if h := sha512.Sum512(key); !bytes.Equal(h[0:8], data[24:32]) {
+		return nil, errors.New("invalid secret key checksum")
+	}
+
+	sk = &SecretKey{
+		Key:     ed25519.PrivateKey(bytes.Clone(key)),
+		signify: true,
+	}
+
+	copy(sk.KeyId[:], data[32:40])
+
+	return
+}
+
+// Sign generates a minisign signature, with the argument trusted comment, or a
+// signify signature for signify keys, followed by a post-quantum signature
+// when a post-quantum key is set.
+func (sk *SecretKey) Sign(buf []byte, trustedComment string) (sig []byte, err error) {
+	alg := prehashAlgorithm
+	msg := buf
+
+	if sk.signify {
+		alg = sigAlgorithm
+	} else {
+		h := blake2b.Sum512(buf)
+		msg = h[:]
+	}
+
+	s := new(bytes.Buffer)
+	s.Write(alg)
+	s.Write(sk.KeyId[:])
+	s.Write(ed25519.Sign(sk.Key, msg))
+
+	if sk.signify {
+		trustedComment = ""
+		sig = encode("verify with armory-boot public key", s.Bytes())
+	} else {
+		if strings.Contains(trustedComment, "\n") {
+			return nil, errors.New("invalid trusted comment")
+		}
+
+		global := ed25519.Sign(sk.Key, append(bytes.Clone(s.Bytes()[10:74]), trustedComment...))
+
+		sig = encode("signature from armory-boot-conf secret key", s.Bytes())
+		sig = append(sig, fmt.Sprintf("trusted comment: %s\n%s\n", trustedComment, base64.StdEncoding.EncodeToString(global))...)
+	}
+
+	if sk.PQ == nil {
+		return
+	}
+
+	pq, err := sk.PQ.Sign(buf, trustedComment)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return append(sig, pq...), nil
+}
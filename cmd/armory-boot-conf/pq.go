@@ -0,0 +1,217 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"filippo.io/mldsa"
+	"golang.org/x/crypto/blake2b"
+
+	"github.com/usbarmory/armory-boot/config"
+)
+
+// post-quantum secret key parameters, the key format mirrors the minisign one
+// with the ML-DSA private key seed in place of the Ed25519 secret key.
+const pqKeynumSize = 8 + mldsa.PrivateKeySize + 32
+
+// PQSecretKey represents an ML-DSA secret key.
+type PQSecretKey struct {
+	SignatureAlgorithm [2]byte
+	KeyId              [8]byte
+	Key                *mldsa.PrivateKey
+}
+
+// PQAlgorithm returns the signature algorithm identifier for the argument
+// ML-DSA parameter set name (e.g. `ML-DSA-65`).
+func PQAlgorithm(name string) (alg [2]byte, err error) {
+	for _, alg = range [][2]byte{config.MLDSA44, config.MLDSA65, config.MLDSA87} {
+		if params, _ := config.PQParameters(alg); strings.EqualFold(params.String(), name) {
+			return
+		}
+	}
+
+	return alg, fmt.Errorf("unsupported post-quantum algorithm %q", name)
+}
+
+func pqChecksum(alg []byte, keynum []byte) []byte {
+	h, _ := blake2b.New256(nil)
+	h.Write(alg)
+	h.Write(keynum[0 : 8+mldsa.PrivateKeySize])
+	return h.Sum(nil)
+}
+
+// GeneratePQKey generates an ML-DSA key pair, the secret key is encrypted
+// unless the password is empty.
+func GeneratePQKey(alg [2]byte, password []byte) (pub []byte, sec []byte, err error) {
+	params, err := config.PQParameters(alg)
+
+	if err != nil {
+		return
+	}
+
+	sk, err := mldsa.GenerateKey(params)
+
+	if err != nil {
+		return
+	}
+
+	var id [8]byte
+
+	if _, err = rand.Read(id[:]); err != nil {
+		return
+	}
+
+	keyID := config.KeyID(id)
+
+	p := new(bytes.Buffer)
+	p.Write(alg[:])
+	p.Write(id[:])
+	p.Write(sk.PublicKey().Bytes())
+
+	keynum := new(bytes.Buffer)
+	keynum.Write(id[:])
+	keynum.Write(sk.Bytes())
+	keynum.Write(pqChecksum(alg[:], keynum.Bytes()))
+
+	salt := make([]byte, 32)
+	kdfAlg := []byte{0, 0}
+	ops, mem := uint64(0), uint64(0)
+
+	if len(password) > 0 {
+		if _, err = rand.Read(salt); err != nil {
+			return
+		}
+
+		kdfAlg, ops, mem = kdfAlgorithm, opsLimit, memLimit
+
+		stream, err := kdf(password, salt, ops, mem)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		xor(keynum.Bytes(), stream)
+	}
+
+	s := new(bytes.Buffer)
+	s.Write(alg[:])
+	s.Write(kdfAlg)
+	s.Write(checksumAlgorithm)
+	s.Write(salt)
+	binary.Write(s, binary.LittleEndian, ops)
+	binary.Write(s, binary.LittleEndian, mem)
+	s.Write(keynum.Bytes())
+
+	pub = encode(fmt.Sprintf("armory-boot %s public key %s", params, keyID), p.Bytes())
+	sec = encode(fmt.Sprintf("armory-boot %s secret key %s", params, keyID), s.Bytes())
+
+	return
+}
+
+// PQPublicKeyString returns the encoded public key, as expected by
+// config.NewKeys(), from a post-quantum public key file.
+func PQPublicKeyString(buf []byte) (s string, err error) {
+	_, data, err := decode(buf)
+
+	if err != nil {
+		return
+	}
+
+	s = base64.StdEncoding.EncodeToString(data)
+
+	if _, err = config.NewPQPublicKey(s); err != nil {
+		return "", err
+	}
+
+	return
+}
+
+// ParsePQSecretKey parses a post-quantum secret key, decrypting it with the
+// argument password if necessary.
+func ParsePQSecretKey(buf []byte, password func() ([]byte, error)) (sk *PQSecretKey, err error) {
+	_, data, err := decode(buf)
+
+	if err != nil {
+		return
+	}
+
+	if len(data) != 2+2+2+32+8+8+pqKeynumSize {
+		return nil, errors.New("invalid post-quantum secret key")
+	}
+
+	sk = &PQSecretKey{}
+	copy(sk.SignatureAlgorithm[:], data[0:2])
+
+	params, err := config.PQParameters(sk.SignatureAlgorithm)
+
+	if err != nil {
+		return nil, err
+	}
+
+	salt := data[6:38]
+	ops := binary.LittleEndian.Uint64(data[38:])
+	mem := binary.LittleEndian.Uint64(data[46:])
+	keynum := data[54:]
+
+	switch {
+	case bytes.Equal(data[2:4], kdfAlgorithm):
+		pw, err := password()
+
+		if err != nil {
+			return nil, err
+		}
+
+		stream, err := kdf(pw, salt, ops, mem)
+
+		if err != nil {
+			return nil, err
+		}
+
+		xor(keynum, stream)
+	case bytes.Equal(data[2:4], []byte{0, 0}):
+	default:
+		return nil, errors.New("unsupported key derivation algorithm")
+	}
+
+	if !bytes.Equal(pqChecksum(data[0:2], keynum), keynum[8+mldsa.PrivateKeySize:]) {
+		return nil, errors.New("invalid secret key checksum (wrong password?)")
+	}
+
+	copy(sk.KeyId[:], keynum[0:8])
+
+	if sk.Key, err = mldsa.NewPrivateKey(params, keynum[8:8+mldsa.PrivateKeySize]); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// Sign generates a post-quantum signature line, to be appended to the
+// signify/minisign signature with the argument trusted comment (see
+// config.PQMessage()).
+func (sk *PQSecretKey) Sign(buf []byte, trustedComment string) (line []byte, err error) {
+	msg := config.PQMessage(buf, trustedComment)
+
+	sig, err := sk.Key.Sign(rand.Reader, msg, &mldsa.Options{Context: config.PQContext})
+
+	if err != nil {
+		return
+	}
+
+	s := new(bytes.Buffer)
+	s.Write(sk.SignatureAlgorithm[:])
+	s.Write(sk.KeyId[:])
+	s.Write(sig)
+
+	return []byte(base64.StdEncoding.EncodeToString(s.Bytes()) + "\n"), nil
+}
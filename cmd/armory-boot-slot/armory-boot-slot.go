@@ -0,0 +1,87 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// This tool manages the armory-boot A/B boot state from a running Linux
+// system, allowing to mark the current boot as successful or to switch the
+// active slot.
+
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/usbarmory/armory-boot/slot"
+)
+
+type Config struct {
+	device     string
+	offset     int64
+	slot       string
+	tries      int
+	successful bool
+	init       bool
+}
+
+var conf *Config
+
+func init() {
+	log.SetFlags(0)
+	log.SetOutput(os.Stdout)
+
+	conf = &Config{}
+
+	flag.StringVar(&conf.device, "d", "/dev/mmcblk0", "boot media block device")
+	flag.Int64Var(&conf.offset, "o", slot.DefaultOffset, "boot state offset")
+	flag.StringVar(&conf.slot, "s", "", "switch to slot (a, b)")
+	flag.IntVar(&conf.tries, "t", slot.DefaultTries, "boot attempts for switched slot")
+	flag.BoolVar(&conf.successful, "m", false, "mark active slot as successfully booted")
+	flag.BoolVar(&conf.init, "i", false, "initialize boot state if invalid")
+}
+
+func main() {
+	flag.Parse()
+
+	f, err := os.OpenFile(conf.device, os.O_RDWR|os.O_SYNC, 0)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	st, err := slot.Load(f, conf.offset)
+
+	if err != nil {
+		if !conf.init {
+			log.Fatalf("could not load boot state, %v", err)
+		}
+
+		log.Printf("initializing boot state")
+		st = slot.Default()
+	}
+
+	save := conf.init
+
+	switch {
+	case len(conf.slot) > 0:
+		if err = st.Switch(conf.slot, conf.tries); err != nil {
+			log.Fatal(err)
+		}
+
+		save = true
+	case conf.successful:
+		st.MarkSuccessful()
+		save = true
+	}
+
+	if save {
+		if err = st.Save(f, conf.offset); err != nil {
+			log.Fatalf("could not save boot state, %v", err)
+		}
+	}
+
+	log.Printf("active:%s tries:%d successful:%v exhausted:%v", st.Active, st.Tries, st.Successful, st.Exhausted)
+}
@@ -0,0 +1,271 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// This tool verifies, on the host, the armory-boot configuration and kernel
+// images of a raw disk image, using the same configuration loading and
+// authentication logic of armory-boot, to detect images which would fail to
+// boot before they are flashed.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/usbarmory/armory-boot/config"
+	"github.com/usbarmory/armory-boot/disk"
+	"github.com/usbarmory/armory-boot/tuf"
+)
+
+type Config struct {
+	image     string
+	offset    int64
+	pubKey    string
+	tufRoot   string
+	threshold int
+	sequence  uint64
+	slot      string
+	serial    string
+	class     string
+	policy    string
+	classKey  string
+	verbose   bool
+}
+
+var conf *Config
+
+// targets holds the verified TUF metadata, when a trusted root is set.
+var targets config.Targets
+
+// keys holds the trusted keys, when set.
+var keys *config.Keys
+
+func init() {
+	log.SetFlags(0)
+	log.SetOutput(os.Stdout)
+
+	conf = &Config{}
+
+	flag.StringVar(&conf.image, "i", "", "raw disk image")
+	flag.Int64Var(&conf.offset, "o", disk.DefaultOffset, "ext4 partition start offset")
+	flag.StringVar(&conf.pubKey, "k", "", "public key(s), as passed in PUBLIC_KEY and PQ_PUBLIC_KEY (skips authentication if empty)")
+	flag.StringVar(&conf.tufRoot, "T", "", "TUF trusted root metadata file, in raw JSON form (TUF_ROOT holds its base64 encoding) (overrides -k)")
+	flag.IntVar(&conf.threshold, "t", 0, "minimum number of distinct signing keys, as set in THRESHOLD")
+	flag.Uint64Var(&conf.sequence, "r", 0, "minimum key revocation list sequence number, as held in the device rollback state")
+	flag.StringVar(&conf.slot, "s", "", "boot slot or restricted entry (default: all, if defined)")
+	flag.StringVar(&conf.serial, "S", "", "device serial number, enforced on the configuration signature")
+	flag.StringVar(&conf.class, "c", "", "device class, enforced on the configuration signature (as set in CLASS)")
+	flag.StringVar(&conf.policy, "p", "", "trusted comment policy options, as set in COMMENT_POLICY")
+	flag.StringVar(&conf.classKey, "K", "", "device class key file, verifies encrypted image decryption when set")
+	flag.BoolVar(&conf.verbose, "v", false, "show armory-boot log messages")
+}
+
+// authenticated returns whether the configuration is authenticated, either by
+// signature or through TUF targets.
+func authenticated() bool {
+	return targets != nil || keys != nil
+}
+
+func report(prefix string, results []config.Result) (pass bool) {
+	pass = true
+
+	for _, r := range results {
+		switch {
+		case r.Err != nil:
+			fmt.Printf("%sFAIL %-12s %s (%v)\n", prefix, r.Name, r.Path, r.Err)
+			pass = false
+		case r.Name == "config" && !authenticated():
+			fmt.Printf("%sSKIP %-12s %s (not authenticated)\n", prefix, r.Name, r.Path)
+		default:
+			fmt.Printf("%sPASS %-12s %s\n", prefix, r.Name, r.Path)
+		}
+	}
+
+	return
+}
+
+// hostTime returns the current host time, used for signature expiry and
+// certificate validity verification.
+func hostTime(time.Time) (time.Time, bool) {
+	return time.Now(), true
+}
+
+// decrypt decrypts the encrypted kernel images with the device class key.
+func decrypt(c *config.Config) (err error) {
+	key, err := os.ReadFile(conf.classKey)
+
+	if err != nil {
+		return
+	}
+
+	return c.Decrypt(key)
+}
+
+// loadTargets verifies the TUF repository metadata, the current host time is
+// used for metadata expiry verification.
+func loadTargets(part *disk.Partition) (repo *tuf.Repository, err error) {
+	root, err := os.ReadFile(conf.tufRoot)
+
+	if err != nil {
+		return
+	}
+
+	return tuf.Load(part, tuf.DefaultPath, root, time.Now())
+}
+
+// loadKeys parses the trusted keys, setting the signature threshold when
+// passed, the current host time is used for key expiry verification.
+func loadKeys() (err error) {
+	if keys, err = config.NewKeys(conf.pubKey); err != nil {
+		return
+	}
+
+	keys.SetRevocationPolicy(conf.sequence, time.Now())
+
+	if conf.threshold > 0 {
+		err = keys.SetThreshold(conf.threshold)
+	}
+
+	return
+}
+
+// verify loads the configuration for the argument slot, reporting the outcome
+// of each loaded file, the names of defined slots (and of the restricted entry)
+// are returned when no slot is selected.
+func verify(part *disk.Partition, slot string) (slots []string, pass bool) {
+	var prefix string
+	var c *config.Config
+	var err error
+
+	if targets != nil {
+		c, err = config.LoadTargets(part, config.DefaultConfigPath, targets, slot)
+	} else {
+		c, err = config.LoadKeys(part, config.DefaultConfigPath, config.DefaultSignaturePath, keys, slot)
+	}
+
+	if c != nil && c.Restricted != nil && len(slot) == 0 {
+		slots = append(slots, config.RestrictedEntry)
+	}
+
+	if c != nil && len(c.Slots) > 0 && len(slot) == 0 {
+		for name := range c.Slots {
+			slots = append(slots, name)
+		}
+
+		sort.Strings(slots)
+
+		return slots, true
+	}
+
+	if len(slot) > 0 {
+		prefix = "[" + slot + "] "
+	}
+
+	results := []config.Result{{Name: "config", Path: config.DefaultConfigPath}}
+
+	if c != nil && len(c.Results) > 0 {
+		results = c.Results
+	}
+
+	if err != nil {
+		// errors not bound to a specific file are reported on the
+		// configuration file
+		failed := false
+
+		for _, r := range results {
+			failed = failed || r.Err != nil
+		}
+
+		if !failed {
+			results[0].Err = err
+		}
+	}
+
+	if err == nil {
+		if err = c.VerifyPolicy(conf.serial, conf.class, conf.policy, hostTime); err != nil {
+			results = append(results, config.Result{Name: "policy", Path: config.DefaultSignaturePath, Err: err})
+		}
+	}
+
+	if err == nil && len(conf.classKey) > 0 && c.Encrypted() {
+		results = append(results, config.Result{Name: "decryption", Path: conf.classKey, Err: decrypt(c)})
+	}
+
+	return slots, report(prefix, results)
+}
+
+// run verifies the disk image, returning the process exit code.
+func run() int {
+	img, err := disk.OpenImage(conf.image)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not open image, %v\n", err)
+		return 1
+	}
+	defer img.Close()
+
+	part := &disk.Partition{
+		Card:   img,
+		Offset: conf.offset,
+	}
+
+	if len(conf.tufRoot) > 0 {
+		repo, err := loadTargets(part)
+
+		if err != nil {
+			fmt.Printf("FAIL %-12s %s (%v)\n", "tuf", tuf.DefaultPath, err)
+			fmt.Printf("verification failed\n")
+			return 1
+		}
+
+		fmt.Printf("PASS %-12s %s (root v%d, targets v%d)\n", "tuf", tuf.DefaultPath, repo.Root.Version, repo.Targets.Version)
+		targets = repo
+	} else if len(conf.pubKey) > 0 {
+		if err = loadKeys(); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid public key, %v\n", err)
+			return 1
+		}
+	}
+
+	slots, pass := verify(part, conf.slot)
+
+	for _, name := range slots {
+		if _, ok := verify(part, name); !ok {
+			pass = false
+		}
+	}
+
+	switch {
+	case !pass:
+		fmt.Printf("verification failed\n")
+		return 1
+	case !authenticated():
+		fmt.Printf("verification successful, authentication SKIPPED (no public key)\n")
+	default:
+		fmt.Printf("verification successful\n")
+	}
+
+	return 0
+}
+
+func main() {
+	flag.Parse()
+
+	if len(conf.image) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if !conf.verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	os.Exit(run())
+}
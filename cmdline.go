@@ -0,0 +1,44 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/usbarmory/armory-boot/config"
+	"github.com/usbarmory/armory-boot/disk"
+
+	"github.com/usbarmory/tamago/soc/nxp/imx6ul"
+	"github.com/usbarmory/tamago/soc/nxp/usdhc"
+)
+
+// expandCmdLine expands the kernel command line template variables from
+// runtime device information.
+func expandCmdLine(conf *config.Config, card *usdhc.USDHC, part *disk.Partition) (err error) {
+	vars := map[string]string{
+		// Linux enumerates uSDHC1 (uSD) as mmc0 and uSDHC2 (eMMC) as mmc1
+		config.VarBootDevice: fmt.Sprintf("/dev/mmcblk%d", card.Index-1),
+		config.VarSerial:     fmt.Sprintf("%x", imx6ul.UniqueID()),
+		config.VarSlot:       conf.Slot,
+		config.VarSoCModel:   imx6ul.Model(),
+	}
+
+	if strings.Contains(conf.CmdLine, "${"+config.VarRootPartUUID+"}") {
+		if vars[config.VarRootPartUUID], err = part.PartUUID(); err != nil {
+			return fmt.Errorf("invalid %s, %v", config.VarRootPartUUID, err)
+		}
+	}
+
+	if conf.CmdLine, err = config.ExpandCmdLine(conf.CmdLine, vars); err != nil {
+		return
+	}
+
+	log.Printf("armory-boot: cmdline %s", conf.CmdLine)
+
+	return
+}
@@ -0,0 +1,83 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Kernel command line template variables
+const (
+	// VarBootDevice is the Linux block device of the boot media (e.g.
+	// `/dev/mmcblk0`).
+	VarBootDevice = "boot_device"
+	// VarRootPartUUID is the UUID of the partition holding the
+	// configuration file.
+	VarRootPartUUID = "root_partuuid"
+	// VarSerial is the SoC unique identifier.
+	VarSerial = "serial"
+	// VarSlot is the selected A/B boot slot.
+	VarSlot = "slot"
+	// VarSoCModel is the SoC model (e.g. `i.MX6ULZ`).
+	VarSoCModel = "soc_model"
+)
+
+// cmdLineVars represents the variables supported in configuration files.
+var cmdLineVars = map[string]string{
+	VarBootDevice:   "",
+	VarRootPartUUID: "",
+	VarSerial:       "",
+	VarSlot:         "",
+	VarSoCModel:     "",
+}
+
+// ExpandCmdLine replaces `${name}` placeholders in the argument kernel command
+// line with the matching variable values, unknown or malformed placeholders
+// are reported as errors. A literal `$` can be escaped as `$$`.
+func ExpandCmdLine(s string, vars map[string]string) (string, error) {
+	var buf strings.Builder
+
+	for {
+		i := strings.IndexByte(s, '$')
+
+		if i < 0 {
+			buf.WriteString(s)
+			break
+		}
+
+		buf.WriteString(s[:i])
+		s = s[i+1:]
+
+		if strings.HasPrefix(s, "$") {
+			buf.WriteByte('$')
+			s = s[1:]
+			continue
+		}
+
+		if !strings.HasPrefix(s, "{") {
+			return "", errors.New("invalid placeholder, missing {")
+		}
+
+		name, rest, ok := strings.Cut(s[1:], "}")
+
+		if !ok {
+			return "", errors.New("invalid placeholder, missing }")
+		}
+
+		val, ok := vars[name]
+
+		if !ok {
+			return "", fmt.Errorf("unknown variable %q", name)
+		}
+
+		buf.WriteString(val)
+		s = rest
+	}
+
+	return buf.String(), nil
+}
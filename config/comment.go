@@ -0,0 +1,263 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package config
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const trustedCommentPrefix = "trusted comment: "
+
+// Trusted comment metadata keys
+const (
+	MetadataTimestamp = "timestamp"
+	MetadataFile      = "file"
+	MetadataVersion   = "version"
+	MetadataExpiry    = "expiry"
+	MetadataSerial    = "serial"
+	MetadataClass     = "class"
+)
+
+// Metadata represents the structured contents of a minisign trusted comment,
+// consisting of whitespace separated `key:value` (or `key=value`) fields
+// (e.g. minisign default `timestamp:1556193335	file:armory-boot.conf`), any
+// other word is ignored, so that free-form comments carry no metadata.
+type Metadata struct {
+	// Timestamp is the signature creation time.
+	Timestamp time.Time
+	// File is the signed file name.
+	File string
+	// Version is the signed file version.
+	Version string
+	// Expiry is the signature expiration time.
+	Expiry time.Time
+	// Serial is the target device serial number.
+	Serial string
+	// Class is the target device class.
+	Class string
+
+	// Fields holds all trusted comment `key:value` fields.
+	Fields map[string]string
+
+	// Certificates holds the verified certificate chain of X.509
+	// signatures, starting with the signer certificate.
+	Certificates []*x509.Certificate
+}
+
+func parseTime(s string) (t time.Time, err error) {
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+
+	return time.Parse(time.RFC3339, s)
+}
+
+func isMetadataKey(k string) bool {
+	switch k {
+	case MetadataTimestamp, MetadataFile, MetadataVersion, MetadataExpiry, MetadataSerial, MetadataClass:
+		return true
+	}
+
+	return false
+}
+
+// ParseTrustedComment parses a minisign trusted comment, with or without its
+// "trusted comment: " prefix, into structured metadata.
+func ParseTrustedComment(s string) (m *Metadata, err error) {
+	m = &Metadata{
+		Fields: make(map[string]string),
+	}
+
+	for _, f := range strings.Fields(strings.TrimPrefix(s, trustedCommentPrefix)) {
+		i := strings.IndexAny(f, ":=")
+
+		if i <= 0 {
+			continue
+		}
+
+		k, v := f[:i], f[i+1:]
+
+		if _, ok := m.Fields[k]; ok {
+			if isMetadataKey(k) {
+				return nil, fmt.Errorf("duplicate trusted comment field %s", k)
+			}
+
+			continue
+		}
+
+		m.Fields[k] = v
+
+		switch k {
+		case MetadataTimestamp:
+			m.Timestamp, err = parseTime(v)
+		case MetadataFile:
+			m.File = v
+		case MetadataVersion:
+			m.Version = v
+		case MetadataExpiry:
+			m.Expiry, err = parseTime(v)
+		case MetadataSerial:
+			m.Serial = v
+		case MetadataClass:
+			m.Class = v
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted comment field %s, %v", k, err)
+		}
+	}
+
+	return
+}
+
+// Policy represents a trusted comment policy, enforced on authenticated
+// signature metadata.
+type Policy struct {
+	// Required rejects signatures without a trusted comment (e.g.
+	// signify).
+	Required bool
+	// File rejects signatures whose trusted comment does not carry the
+	// signed file name, which is otherwise only matched against the
+	// authenticated file name when present.
+	File bool
+	// Time is the current time, used to reject expired signatures and
+	// X.509 certificates outside their validity period, a zero value
+	// disables expiry checks.
+	Time time.Time
+	// Serial is the device serial number, signatures targeted at a
+	// different one are rejected.
+	Serial string
+	// Class is the device class, signatures targeted at a different one are
+	// rejected.
+	Class string
+}
+
+// Policy options (see Policy.SetOptions())
+const (
+	PolicyRequired = "required"
+	PolicyFile     = "file"
+)
+
+// SetOptions parses a comma separated list of policy options, PolicyRequired
+// sets Required and PolicyFile sets File.
+func (p *Policy) SetOptions(s string) (err error) {
+	for _, opt := range strings.Split(s, ",") {
+		switch strings.TrimSpace(opt) {
+		case "":
+		case PolicyRequired:
+			p.Required = true
+		case PolicyFile:
+			p.File = true
+		default:
+			return fmt.Errorf("invalid policy option %q", opt)
+		}
+	}
+
+	return
+}
+
+// Check enforces the policy on the trusted comment metadata of the signature
+// for the argument file path, a nil metadata argument indicates a signature
+// without trusted comment.
+func (p *Policy) Check(m *Metadata, filePath string) (err error) {
+	if m != nil && !p.Time.IsZero() {
+		for _, cert := range m.Certificates {
+			if p.Time.Before(cert.NotBefore) || p.Time.After(cert.NotAfter) {
+				return fmt.Errorf("certificate %s not valid at %s", cert.Subject, p.Time.UTC().Format(time.RFC3339))
+			}
+		}
+	}
+
+	if m != nil && !m.Expiry.IsZero() && !p.Time.IsZero() && p.Time.After(m.Expiry) {
+		return fmt.Errorf("signature expired on %s", m.Expiry.UTC().Format(time.RFC3339))
+	}
+
+	if m == nil || len(m.Fields) == 0 {
+		if p.Required || p.File {
+			return errors.New("missing trusted comment")
+		}
+
+		return
+	}
+
+	if _, ok := m.Fields[MetadataFile]; ok {
+		if m.File != path.Base(filePath) {
+			return fmt.Errorf("signed file name mismatch (%s)", m.File)
+		}
+	} else if p.File {
+		return errors.New("missing signed file name")
+	}
+
+	if len(m.Serial) > 0 && !strings.EqualFold(m.Serial, p.Serial) {
+		return fmt.Errorf("signature targeted at device %s", m.Serial)
+	}
+
+	if len(m.Class) > 0 && m.Class != p.Class {
+		return fmt.Errorf("signature targeted at device class %s", m.Class)
+	}
+
+	return
+}
+
+// checkClock enforces the policy like Check(), with the current time returned
+// by the argument clock function (see Config.VerifyPolicy()).
+func (p Policy) checkClock(m *Metadata, filePath string, clock func(notBefore time.Time) (t time.Time, valid bool)) (err error) {
+	if m != nil && (!m.Expiry.IsZero() || len(m.Certificates) > 0) {
+		if t, valid := clock(m.Timestamp); valid {
+			p.Time = t
+		} else {
+			log.Printf("armory-boot: invalid clock, skipping signature expiry and certificate validity verification")
+		}
+	}
+
+	return p.Check(m, filePath)
+}
+
+// VerifyPolicy enforces a trusted comment policy, with the argument comma
+// separated options (see Policy.SetOptions()), on the signature metadata of a
+// configuration file authenticated by LoadKeys() and of its kernel images,
+// when detached signatures are used, which must always carry the signed file
+// name. Configurations loaded without keys, or authenticated through TUF
+// targets, are not affected.
+//
+// The clock function returns the current time, given the signature timestamp
+// as its lower bound, and whether it is valid, signature expiry and X.509
+// certificate validity are not verified when it is not.
+func (c *Config) VerifyPolicy(serial string, class string, options string, clock func(notBefore time.Time) (t time.Time, valid bool)) (err error) {
+	if len(c.path) == 0 {
+		return
+	}
+
+	policy := Policy{
+		Serial: serial,
+		Class:  class,
+	}
+
+	if err = policy.SetOptions(options); err != nil {
+		return
+	}
+
+	if err = policy.checkClock(c.Metadata, c.path, clock); err != nil {
+		return
+	}
+
+	policy.File = true
+
+	for _, s := range c.signed {
+		if err = policy.checkClock(s.metadata, s.path, clock); err != nil {
+			return fmt.Errorf("invalid %s, %v", s.name, err)
+		}
+	}
+
+	return
+}
@@ -8,12 +8,8 @@
 package config
 
 import (
-	"encoding/json"
-	"errors"
 	"fmt"
 	"log"
-
-	"github.com/usbarmory/armory-boot/disk"
 )
 
 // DefaultConfigPath is the default armory-boot configuration file path.
@@ -23,101 +19,265 @@ const DefaultConfigPath = "/boot/armory-boot.conf"
 // path.
 const DefaultSignaturePath = "/boot/armory-boot.conf.sig"
 
+// SignatureSuffix is the file name suffix of detached kernel image signatures
+// (see Config.Detached).
+const SignatureSuffix = ".sig"
+
+// Partition represents the file system holding the armory-boot configuration
+// and kernel images (e.g. *disk.Partition).
+type Partition interface {
+	// ReadAll returns the contents of the file at the argument absolute
+	// path, fs.ErrNotExist is returned for missing files.
+	ReadAll(path string) ([]byte, error)
+}
+
+// Targets represents a set of trusted files (e.g. TUF targets metadata, see
+// package tuf), which authenticate the configuration file and, when detached
+// signatures are used, kernel images in place of signatures.
+type Targets interface {
+	// Verify authenticates the contents of the file at the argument
+	// absolute path.
+	Verify(path string, buf []byte) error
+}
+
+// Result represents the loading outcome of a file referenced by the
+// configuration, its authentication is included when a public key is set.
+type Result struct {
+	// Name is the configuration parameter name (e.g. "kernel",
+	// "overlays.0").
+	Name string
+	// Path is the file path.
+	Path string
+	// Err is the loading error, nil on success.
+	Err error
+}
+
 // Config represents the armory-boot configuration.
 type Config struct {
-	// KernelPath is the path to a Linux kernel image.
-	KernelPath []string `json:"kernel"`
+	// Version is the configuration format version (see Version2), it is
+	// assumed to be Version1 when omitted.
+	Version int `json:"version,omitempty"`
 
-	// DeviceTreeBlobPath is the path to a Linux DTB file.
-	DeviceTreeBlobPath []string `json:"dtb"`
+	// KernelImage is the Linux kernel image.
+	KernelImage *Image `json:"kernel,omitempty"`
 
-	// InitialRamDiskPath is the path to a Linux initrd file.
-	InitialRamDiskPath []string `json:"initrd"`
+	// DeviceTreeBlobImage is the Linux DTB file.
+	DeviceTreeBlobImage *Image `json:"dtb,omitempty"`
 
-	// CmdLine is the Linux kernel command-line parameters.
-	CmdLine string `json:"cmdline"`
+	// InitialRamDiskImage is the Linux initrd file.
+	InitialRamDiskImage *Image `json:"initrd,omitempty"`
+
+	// DeviceTreeOverlayImages are the device tree overlay (dtbo) files,
+	// applied in order to the Linux DTB.
+	DeviceTreeOverlayImages []*Image `json:"overlays,omitempty"`
 
-	// Unikernel is the path to an ELF unikernel image (e.g. TamaGo).
-	UnikernelPath []string `json:"unikernel"`
+	// CmdLine is the Linux kernel command-line parameters.
+	CmdLine string `json:"cmdline,omitempty"`
+
+	// UnikernelImage is the ELF unikernel image (e.g. TamaGo).
+	UnikernelImage *Image `json:"unikernel,omitempty"`
+
+	// Env is the ELF unikernel environment, as key=value strings, passed
+	// along with CmdLine in its boot information (see package bootinfo).
+	Env []string `json:"env,omitempty"`
+
+	// SecurityVersion is the configuration security version, it must not
+	// be lower than the minimum one enforced by the bootloader for
+	// anti-rollback protection.
+	SecurityVersion uint32 `json:"security_version,omitempty"`
+
+	// MinSecurityVersion, when greater than the minimum security version
+	// enforced by the bootloader, raises it to prevent any future boot of
+	// configurations with a lower security version.
+	MinSecurityVersion uint32 `json:"min_security_version,omitempty"`
+
+	// Detached indicates that kernel images are authenticated through
+	// individual detached signatures (see SignatureSuffix), or targets when
+	// loaded with LoadTargets(), rather than digests, in which case their
+	// parameters consist of only their path.
+	Detached bool `json:"detached,omitempty"`
+
+	// Includes are the configuration fragments merged with the kernel
+	// parameters of this configuration, or of each of its slots.
+	//
+	// Parameters defined in the configuration take precedence over the ones
+	// of fragments, later fragments take precedence over earlier ones.
+	// Overlays are instead concatenated in order.
+	Includes []*Image `json:"include,omitempty"`
+
+	// Slots holds A/B boot slot configurations, each defining its own
+	// kernel parameters, as an alternative to top-level ones.
+	Slots map[string]*Config `json:"slots,omitempty"`
+
+	// Tamper is the boot policy applied when a tamper or security violation
+	// is detected, either TamperRefuse (default) or TamperRestricted.
+	Tamper string `json:"tamper,omitempty"`
+
+	// Restricted holds the kernel parameters booted, in place of top-level
+	// or slot ones, when a violation is detected under the
+	// TamperRestricted policy (see RestrictedEntry).
+	Restricted *Config `json:"restricted,omitempty"`
+
+	// Monitors lists the SNVS tamper monitors (e.g. MonitorClock) which
+	// must be enabled before boot.
+	Monitors []string `json:"monitors,omitempty"`
 
 	// ELF indicates whether the loaded kernel is a unikernel or not.
-	ELF bool
+	ELF bool `json:"-"`
+
+	// Slot is the boot slot selected by LoadSlot(), it is empty when the
+	// configuration does not define any and RestrictedEntry when the
+	// restricted kernel parameters are selected.
+	Slot string `json:"-"`
 
 	// JSON holds the configuration file contents
-	JSON []byte
+	JSON []byte `json:"-"`
+
+	// Metadata holds the configuration file signature trusted comment
+	// metadata, it is nil for unsigned configurations or signatures without
+	// a trusted comment.
+	Metadata *Metadata `json:"-"`
+
+	// Results holds the loading outcome of the configuration file,
+	// fragments and selected kernel images, in loading order.
+	Results []Result `json:"-"`
+
+	targets Targets
+
+	// path is the configuration file path, set when authenticated by
+	// signature
+	path string
+	// signed holds the detached signature metadata of each kernel image or
+	// fragment, enforced by VerifyPolicy()
+	signed []signed
+
+	kernel   []byte
+	dtb      []byte
+	initrd   []byte
+	overlays [][]byte
+}
 
-	kernel []byte
-	dtb    []byte
-	initrd []byte
+func (c *Config) selectSlot(name string) (err error) {
+	s, ok := c.Slots[name]
 
-	kernelHash string
-	dtbHash    string
-	initrdHash string
-}
+	if !ok {
+		return &FieldError{Field: "slots." + name, Err: ErrMissing}
+	}
+
+	c.KernelImage = s.KernelImage
+	c.DeviceTreeBlobImage = s.DeviceTreeBlobImage
+	c.InitialRamDiskImage = s.InitialRamDiskImage
+	c.DeviceTreeOverlayImages = s.DeviceTreeOverlayImages
+	c.CmdLine = s.CmdLine
+	c.UnikernelImage = s.UnikernelImage
+	c.Env = s.Env
+	c.Slot = name
 
-func (c *Config) init(part *disk.Partition) (err error) {
-	var kernelPath string
+	return
+}
 
-	if err = json.Unmarshal(c.JSON, &c); err != nil {
+func (c *Config) init(part Partition, keys *Keys, slot string) (err error) {
+	if err = c.decode(c.JSON); err != nil {
 		return
 	}
 
-	ul, kl := len(c.UnikernelPath), len(c.KernelPath)
-	isUnikernel, isKernel := ul > 0, kl > 0
+	if len(c.Includes) > 0 {
+		if err = c.loadIncludes(part, keys, c.version()); err != nil {
+			return
+		}
+	}
 
-	if isUnikernel == isKernel {
-		return errors.New("must specify either unikernel or kernel")
+	if err = c.Validate(); err != nil {
+		return
 	}
 
 	switch {
-	case isKernel:
-		if kl != 2 {
-			return errors.New("invalid kernel parameter size")
+	case slot == RestrictedEntry:
+		if err = c.selectRestricted(); err != nil {
+			return
 		}
-
-		if len(c.DeviceTreeBlobPath) != 2 {
-			return errors.New("invalid dtb parameter size")
+	case len(c.Slots) > 0:
+		if err = c.selectSlot(slot); err != nil {
+			return
 		}
+	}
 
-		if len(c.InitialRamDiskPath) > 0 {
-			if len(c.InitialRamDiskPath) != 2 {
-				return errors.New("invalid initrd parameter size")
-			}
+	c.ELF = c.UnikernelImage != nil
+	c.overlays = make([][]byte, len(c.DeviceTreeOverlayImages))
 
-			if c.initrd, err = part.ReadAll(c.InitialRamDiskPath[0]); err != nil {
-				return
-			}
+	for _, e := range c.entries() {
+		r := Result{
+			Name: e.name,
+			Path: e.image.Path,
+			Err:  c.load(part, keys, e),
+		}
 
-			c.initrdHash = c.InitialRamDiskPath[1]
+		if r.Err != nil && err == nil {
+			err = r.Err
 		}
 
-		kernelPath = c.KernelPath[0]
-		c.kernelHash = c.KernelPath[1]
+		c.Results = append(c.Results, r)
+	}
 
-		if c.dtb, err = part.ReadAll(c.DeviceTreeBlobPath[0]); err != nil {
-			return
-		}
+	return
+}
+
+// load reads a selected kernel image and, when keys or targets are set,
+// authenticates it either through its digest, detached signature or target.
+func (c *Config) load(part Partition, keys *Keys, e entry) (err error) {
+	if *e.buf, err = part.ReadAll(e.image.Path); err != nil {
+		return fmt.Errorf("invalid path %s, %v", e.image.Path, err)
+	}
+
+	if e.image.Size > 0 && int64(len(*e.buf)) != e.image.Size {
+		return &FieldError{Field: e.name, Err: ErrSizeMismatch, Detail: fmt.Sprintf("%d != %d", len(*e.buf), e.image.Size)}
+	}
+
+	return c.authenticate(part, keys, e.name, e.image, *e.buf)
+}
 
-		c.dtbHash = c.DeviceTreeBlobPath[1]
-	case isUnikernel:
-		if ul != 2 {
-			return errors.New("invalid unikernel parameter size")
+// authenticate verifies a kernel image or configuration fragment, when keys or
+// targets are set, either through its digest, detached signature or target.
+func (c *Config) authenticate(part Partition, keys *Keys, name string, image *Image, buf []byte) (err error) {
+	switch {
+	case keys == nil && c.targets == nil:
+	case c.Detached && c.targets != nil:
+		if err = c.targets.Verify(image.Path, buf); err != nil {
+			return fmt.Errorf("invalid %s, %v", name, err)
 		}
+	case c.Detached:
+		return c.verifyDetached(part, keys, name, image.Path, buf)
+	case !CompareHash(buf, image.Digest):
+		return &FieldError{Field: name, Err: ErrInvalidHash}
+	}
+
+	return
+}
 
-		kernelPath = c.UnikernelPath[0]
-		c.kernelHash = c.UnikernelPath[1]
+// entry represents a selected kernel image and its loaded contents.
+type entry struct {
+	name  string
+	image *Image
+	buf   *[]byte
+}
+
+// entries returns the kernel images selected for loading.
+func (c *Config) entries() (e []entry) {
+	if c.ELF {
+		return []entry{{"unikernel", c.UnikernelImage, &c.kernel}}
 	}
 
-	if c.kernel, err = part.ReadAll(kernelPath); err != nil {
-		return fmt.Errorf("invalid path %s, %v", kernelPath, err)
+	e = []entry{
+		{"kernel", c.KernelImage, &c.kernel},
+		{"dtb", c.DeviceTreeBlobImage, &c.dtb},
 	}
 
-	if err != nil {
-		return fmt.Errorf("invalid path %s, %v", c.DeviceTreeBlobPath[0], err)
+	if c.InitialRamDiskImage != nil {
+		e = append(e, entry{"initrd", c.InitialRamDiskImage, &c.initrd})
 	}
 
-	if isUnikernel {
-		c.ELF = true
+	for i, image := range c.DeviceTreeOverlayImages {
+		e = append(e, entry{fmt.Sprintf("overlays.%d", i), image, &c.overlays[i]})
 	}
 
 	return
@@ -126,7 +286,41 @@ func (c *Config) init(part *disk.Partition) (err error) {
 // Load reads an armory-boot configuration file, and optionally its signature,
 // from a disk partition. The public key argument is used for signature
 // authentication, a valid signature path must be present if a key is set.
-func Load(part *disk.Partition, configPath string, sigPath string, pubKey string) (c *Config, err error) {
+//
+// Multiple trusted public keys can be passed (see NewKeys()), in which case
+// the signature key identifier selects the key used for verification. Keys
+// listed in a signed key revocation list, when present at
+// DefaultRevocationPath, are no longer trusted. X.509 root certificates can
+// also be passed, along with certificate revocation lists at DefaultCRLPath.
+func Load(part Partition, configPath string, sigPath string, pubKey string) (c *Config, err error) {
+	return LoadSlot(part, configPath, sigPath, pubKey, "")
+}
+
+// LoadSlot reads an armory-boot configuration file like Load(), selecting the
+// argument boot slot when the configuration defines A/B slots (see Slots).
+//
+// On errors following successful authentication the parsed configuration is
+// returned, to allow callers to fall back to a different slot. All selected
+// kernel images are loaded, and their outcome recorded (see Results), even
+// after a failure, the first error is returned.
+func LoadSlot(part Partition, configPath string, sigPath string, pubKey string, slot string) (c *Config, err error) {
+	var keys *Keys
+
+	if len(pubKey) > 0 {
+		if keys, err = NewKeys(pubKey); err != nil {
+			return nil, fmt.Errorf("invalid public key, %v", err)
+		}
+	}
+
+	return LoadKeys(part, configPath, sigPath, keys, slot)
+}
+
+// LoadKeys reads an armory-boot configuration file like LoadSlot(),
+// authenticating it against the argument trusted keys, which allows a
+// signature threshold (see Keys.SetThreshold()) and a minimum key revocation
+// list sequence number (see Keys.SetRevocationPolicy()) to be set. A nil keys
+// argument disables authentication.
+func LoadKeys(part Partition, configPath string, sigPath string, keys *Keys, slot string) (c *Config, err error) {
 	log.Printf("armory-boot: loading configuration at %s\n", configPath)
 
 	c = &Config{}
@@ -135,49 +329,98 @@ func Load(part *disk.Partition, configPath string, sigPath string, pubKey string
 		return
 	}
 
-	if len(pubKey) > 0 {
-		sig, err := part.ReadAll(sigPath)
+	if keys != nil {
+		if err = loadRevocations(part, keys); err != nil {
+			return nil, err
+		}
+
+		sigs, err := keys.readSignatures(part, sigPath)
 
 		if err != nil {
 			return nil, fmt.Errorf("invalid signature path, %v", err)
 		}
 
-		if err = Verify(c.JSON, sig, pubKey); err != nil {
+		if c.Metadata, err = keys.verifySignatures(c.JSON, sigs); err != nil {
 			return nil, err
 		}
+
+		c.path = configPath
+	}
+
+	err = c.initSlot(part, keys, configPath, slot)
+
+	return
+}
+
+// LoadTargets reads an armory-boot configuration file like LoadSlot(),
+// authenticating it, and its kernel images when detached signatures are used,
+// through the argument trusted targets rather than signatures.
+func LoadTargets(part Partition, configPath string, targets Targets, slot string) (c *Config, err error) {
+	log.Printf("armory-boot: loading configuration at %s\n", configPath)
+
+	c = &Config{
+		targets: targets,
+	}
+
+	if c.JSON, err = part.ReadAll(configPath); err != nil {
+		return
 	}
 
+	if err = targets.Verify(configPath, c.JSON); err != nil {
+		return nil, fmt.Errorf("invalid configuration, %v", err)
+	}
+
+	err = c.initSlot(part, nil, configPath, slot)
+
+	return
+}
+
+func (c *Config) initSlot(part Partition, keys *Keys, configPath string, slot string) (err error) {
 	defer func() {
 		if err != nil {
 			c.kernel = nil
 			c.dtb = nil
 			c.initrd = nil
+			c.overlays = nil
 		}
 	}()
 
-	if err = c.init(part); err != nil {
-		return
-	}
+	c.Results = append(c.Results, Result{Name: "config", Path: configPath})
 
-	if len(pubKey) == 0 {
-		return
-	}
+	return c.init(part, keys, slot)
+}
 
-	if !CompareHash(c.kernel, c.kernelHash) {
-		err = errors.New("invalid kernel hash")
-		return
+// signed represents the detached signature metadata of a file.
+type signed struct {
+	name     string
+	path     string
+	metadata *Metadata
+}
+
+// verifyDetached authenticates a file through its detached signature, which
+// must carry the signed file name, the remaining trusted comment policy is
+// enforced by VerifyPolicy().
+func (c *Config) verifyDetached(part Partition, keys *Keys, name string, path string, buf []byte) (err error) {
+	sigs, err := keys.readSignatures(part, path+SignatureSuffix)
+
+	if err != nil {
+		return fmt.Errorf("invalid %s signature path, %v", name, err)
 	}
 
-	if len(c.dtb) > 0 && !CompareHash(c.dtb, c.dtbHash) {
-		err = errors.New("invalid dtb hash")
-		return
+	m, err := keys.verifySignatures(buf, sigs)
+
+	if err != nil {
+		return fmt.Errorf("invalid %s, %v", name, err)
 	}
 
-	if len(c.initrd) > 0 && !CompareHash(c.initrd, c.initrdHash) {
-		err = errors.New("invalid initrd hash")
-		return
+	policy := &Policy{File: true}
+
+	if err = policy.Check(m, path); err != nil {
+		return fmt.Errorf("invalid %s, %v", name, err)
 	}
 
+	c.signed = append(c.signed, signed{name, path, m})
+
 	return
 }
 
@@ -198,3 +441,9 @@ func (c *Config) DeviceTreeBlob() []byte {
 func (c *Config) InitialRamDisk() []byte {
 	return c.initrd
 }
+
+// DeviceTreeOverlays returns the contents of the dtbo files previously loaded
+// by a successful Load().
+func (c *Config) DeviceTreeOverlays() [][]byte {
+	return c.overlays
+}
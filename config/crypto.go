@@ -7,82 +7,117 @@ package config
 
 import (
 	"bytes"
-	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/hex"
-	"errors"
 	"fmt"
+	"strings"
 
-	"github.com/usbarmory/tamago/soc/nxp/imx6ul"
+	"golang.org/x/crypto/blake2b"
 )
 
-func init() {
-	if imx6ul.DCP != nil {
-		imx6ul.DCP.Init()
-	}
-}
-
 // Verify authenticates an input against a signify/minisign generated
 // signature, pubKey must be the last line of a signify/minisign public key
-// (i.e. without comments).
+// (i.e. without comments), multiple keys can be passed separated by commas or
+// whitespace (see NewKeys()), X.509 signatures are verified against base64
+// encoded DER root certificates.
 func Verify(buf []byte, sig []byte, pubKey string) (err error) {
-	s, err := DecodeSignature(string(sig))
+	keys, err := NewKeys(pubKey)
 
 	if err != nil {
-		return fmt.Errorf("invalid signature, %v", err)
+		return fmt.Errorf("invalid public key, %v", err)
 	}
 
-	pub, err := NewPublicKey(pubKey)
+	return keys.Verify(buf, sig)
+}
 
-	if err != nil {
-		return fmt.Errorf("invalid public key, %v", err)
+// Digest algorithms
+const (
+	SHA256     = "sha256"
+	SHA384     = "sha384"
+	SHA512     = "sha512"
+	BLAKE2b256 = "blake2b-256"
+)
+
+// ParseDigest parses a digest string, consisting of its algorithm name and
+// hexadecimal value separated by a colon (e.g. `sha384:<hex>`), bare
+// hexadecimal strings are parsed as SHA256 digests.
+func ParseDigest(s string) (alg string, hash []byte, err error) {
+	alg, h, found := strings.Cut(s, ":")
+
+	if !found {
+		alg, h = SHA256, s
 	}
 
-	valid, err := pub.Verify(buf, s)
+	if hash, err = hex.DecodeString(h); err != nil {
+		return "", nil, fmt.Errorf("invalid encoding, %v", err)
+	}
 
-	if err != nil {
-		return fmt.Errorf("invalid signature, %v", err)
+	var size int
+
+	switch alg {
+	case SHA256, BLAKE2b256:
+		size = 32
+	case SHA384:
+		size = 48
+	case SHA512:
+		size = 64
+	default:
+		return "", nil, fmt.Errorf("unsupported digest algorithm %q", alg)
 	}
 
-	if !valid {
-		return errors.New("invalid signature")
+	if len(hash) != size {
+		return "", nil, fmt.Errorf("invalid %s digest size", alg)
 	}
 
 	return
 }
 
-// CompareHash computes a SHA256 checksum of the input data, using hardware
-// acceleration (NXP DCP), and compares the computed hash with the one passed
-// as a string with only hexadecimal characters and even length.
+// Sum computes the digest of the input data with the argument algorithm, on
+// `GOOS=tamago` SHA256 digests are computed using hardware acceleration (NXP
+// CAAM or DCP) when available. SHA384, SHA512 and BLAKE2b256 digests are
+// always computed in software, as neither accelerator supports them on the
+// i.MX6UL family.
 //
 // As this function is meant for pre-boot use, the entire input buffer is
-// copied in a DMA region for DCP consumption in a single pass, rather than
-// buffering over multiple passes, to reduce DCP command overhead. When used in
-// other contexts callers must ensure that enough DMA space is available.
-//
-// This function is only meant to be used with `GOOS=tamago GOARCH=arm` on
-// i.MX6 targets.
-func CompareHash(buf []byte, s string) (valid bool) {
-	var sum [32]byte
-	var err error
-
-	switch {
-	case imx6ul.CAAM != nil:
-		sum, err = imx6ul.CAAM.Sum256(buf)
-	case imx6ul.DCP != nil:
-		sum, err = imx6ul.DCP.Sum256(buf)
+// copied in a DMA region for hardware acceleration in a single pass, rather
+// than buffering over multiple passes, to reduce command overhead. When used
+// in other contexts callers must ensure that enough DMA space is available.
+func Sum(alg string, buf []byte) (sum []byte, err error) {
+	switch alg {
+	case SHA256:
+		var s [32]byte
+		s, err = sum256(buf)
+		sum = s[:]
+	case SHA384:
+		s := sha512.Sum384(buf)
+		sum = s[:]
+	case SHA512:
+		s := sha512.Sum512(buf)
+		sum = s[:]
+	case BLAKE2b256:
+		s := blake2b.Sum256(buf)
+		sum = s[:]
 	default:
-		sum = sha256.Sum256(buf)
+		err = fmt.Errorf("unsupported digest algorithm %q", alg)
 	}
 
+	return
+}
+
+// CompareHash computes a checksum of the input data (see Sum()), and compares
+// it with the one passed as a digest string (see ParseDigest()).
+func CompareHash(buf []byte, s string) (valid bool) {
+	alg, hash, err := ParseDigest(s)
+
 	if err != nil {
 		return false
 	}
 
-	hash, err := hex.DecodeString(s)
+	sum, err := Sum(alg, buf)
 
 	if err != nil {
 		return false
 	}
 
-	return bytes.Equal(sum[:], hash)
+	return bytes.Equal(sum, hash)
 }
@@ -0,0 +1,26 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+//go:build !tamago
+
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"errors"
+)
+
+func sum256(buf []byte) ([32]byte, error) {
+	return sha256.Sum256(buf), nil
+}
+
+func verifyECDSA(pub *ecdsa.PublicKey, hash []byte, sig []byte) bool {
+	return ecdsa.VerifyASN1(pub, hash, sig)
+}
+
+func deriveKey(_ []byte) ([]byte, error) {
+	return nil, errors.New("hardware key derivation unavailable")
+}
@@ -0,0 +1,159 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+//go:build tamago
+
+package config
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/usbarmory/tamago/dma"
+	"github.com/usbarmory/tamago/soc/nxp/caam"
+	"github.com/usbarmory/tamago/soc/nxp/imx6ul"
+)
+
+// p443, Table 8-101, IMX7DSSRM
+const (
+	ecdselP256 = 0x02
+	ecdselP384 = 0x03
+)
+
+// p451, Table 8-113, IMX7DSSRM
+const protidECDSAVerify = 0x16
+
+// errUnsupported is returned when the CAAM does not support the key curve.
+var errUnsupported = errors.New("unsupported by CAAM")
+
+func init() {
+	if imx6ul.DCP != nil {
+		imx6ul.DCP.Init()
+	}
+}
+
+func sum256(buf []byte) ([32]byte, error) {
+	switch {
+	case imx6ul.CAAM != nil:
+		return imx6ul.CAAM.Sum256(buf)
+	case imx6ul.DCP != nil:
+		return imx6ul.DCP.Sum256(buf)
+	default:
+		return sha256.Sum256(buf), nil
+	}
+}
+
+// verifyCAAM verifies an ECDSA signature with the CAAM Public Key Hardware
+// Accelerator (PKHA), through the DSA verify protocol.
+func verifyCAAM(pub *ecdsa.PublicKey, hash []byte, sig []byte) (err error) {
+	var ecdsel uint32
+	var rs struct{ R, S *big.Int }
+
+	switch pub.Curve.Params().Name {
+	case "P-256":
+		ecdsel = ecdselP256
+	case "P-384":
+		ecdsel = ecdselP384
+	default:
+		return fmt.Errorf("%w curve %s", errUnsupported, pub.Curve.Params().Name)
+	}
+
+	if rest, err := asn1.Unmarshal(sig, &rs); err != nil || len(rest) > 0 || rs.R.Sign() <= 0 || rs.S.Sign() <= 0 {
+		return errors.New("invalid signature encoding")
+	}
+
+	n := (pub.Curve.Params().BitSize + 7) / 8
+	key, err := pub.Bytes()
+
+	if err != nil {
+		return
+	}
+
+	if len(hash) > n {
+		hash = hash[:n]
+	}
+
+	// public key (w), hash (f), signature (c, d) and temporary buffer
+	buf := make([]byte, 2*n+n+2*n+2*n)
+	copy(buf[0:2*n], key[1:])
+	copy(buf[3*n-len(hash):3*n], hash)
+	rs.R.FillBytes(buf[3*n : 4*n])
+	rs.S.FillBytes(buf[4*n : 5*n])
+
+	addr := dma.Alloc(buf, 4)
+	defer dma.Free(addr)
+
+	// p451, Table 8-113, IMX7DSSRM
+	pdb := []uint32{
+		1<<caam.DSA_SIG_PDB_PD | ecdsel<<caam.DSA_SIG_PDB_ECDSEL,
+		uint32(addr),
+		uint32(addr) + uint32(2*n),
+		uint32(addr) + uint32(3*n),
+		uint32(addr) + uint32(4*n),
+		uint32(addr) + uint32(5*n),
+	}
+
+	op := caam.Operation{}
+	op.SetDefaults()
+	op.OpType(caam.OPTYPE_PROT_UNI)
+	op.Word0 |= protidECDSAVerify<<caam.OPERATION_PROTID | 1<<caam.PROTINFO_ECC
+
+	jd := new(bytes.Buffer)
+	binary.Write(jd, binary.LittleEndian, pdb)
+
+	hdr := &caam.Header{}
+	hdr.SetDefaults()
+	hdr.StartIndex(1 + len(pdb))
+
+	jd.Write(op.Bytes())
+	hdr.Length(1 + jd.Len()/4)
+
+	return imx6ul.CAAM.Job(hdr, jd.Bytes())
+}
+
+// verifyECDSA verifies an ECDSA signature with the CAAM, when present, or in
+// software otherwise. The CAAM result is final, software verification is only
+// used for curves it does not support.
+func verifyECDSA(pub *ecdsa.PublicKey, hash []byte, sig []byte) bool {
+	if imx6ul.CAAM == nil {
+		return ecdsa.VerifyASN1(pub, hash, sig)
+	}
+
+	err := verifyCAAM(pub, hash, sig)
+
+	if errors.Is(err, errUnsupported) {
+		return ecdsa.VerifyASN1(pub, hash, sig)
+	}
+
+	return err == nil
+}
+
+// deriveKey derives a hardware unique key, from the internal OTPMK, through
+// the CAAM or DCP, it is only available when the SNVS is in trusted or secure
+// state as otherwise a non-unique test key would be used.
+func deriveKey(diversifier []byte) (key []byte, err error) {
+	if !imx6ul.Native || !imx6ul.SNVS.Available() {
+		return nil, errors.New("SNVS unavailable, hardware key derivation is unsafe")
+	}
+
+	switch {
+	case imx6ul.CAAM != nil:
+		key = make([]byte, sha256.Size)
+		err = imx6ul.CAAM.DeriveKey(diversifier, key)
+	case imx6ul.DCP != nil:
+		key, err = imx6ul.DCP.DeriveKey(diversifier, make([]byte, aes.BlockSize), -1)
+	default:
+		err = errors.New("hardware key derivation unavailable")
+	}
+
+	return
+}
@@ -0,0 +1,345 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// DefaultClassKeyPath is the default device class key path, the key is stored
+// wrapped with a hardware unique key (see WrapClassKey()).
+const DefaultClassKeyPath = "/boot/armory-boot.ck"
+
+// ClassKeySize is the size of device class keys.
+const ClassKeySize = 32
+
+// Encryption algorithms
+const (
+	// AES256GCM images consist of a 12 bytes nonce followed by the
+	// AES-256-GCM ciphertext and tag.
+	AES256GCM = "aes-256-gcm"
+	// AES256CBCHMAC images consist of a 16 bytes IV followed by the
+	// AES-256-CBC ciphertext, with PKCS#7 padding, and its HMAC-SHA256.
+	AES256CBCHMAC = "aes-256-cbc-hmac-sha256"
+)
+
+// Diversifier sizes
+const (
+	minDiversifierSize = 16
+	maxDiversifierSize = 32
+)
+
+// ErrDecryption is returned when an encrypted image cannot be authenticated
+// or decrypted.
+var ErrDecryption = errors.New("decryption failure")
+
+// Encryption represents the encryption parameters of a kernel image, whose
+// keys are derived from the device class key and a per-image diversifier.
+type Encryption struct {
+	// Algorithm is the encryption algorithm (e.g. AES256GCM).
+	Algorithm string `json:"algorithm"`
+
+	// Diversifier is the hex encoded per-image key diversifier.
+	Diversifier string `json:"diversifier"`
+}
+
+// ParseDiversifier parses a hex encoded per-image key diversifier.
+func ParseDiversifier(s string) (d []byte, err error) {
+	if d, err = hex.DecodeString(s); err != nil {
+		return nil, fmt.Errorf("invalid encoding, %v", err)
+	}
+
+	if len(d) < minDiversifierSize || len(d) > maxDiversifierSize {
+		return nil, errors.New("invalid diversifier size")
+	}
+
+	return
+}
+
+func (e *Encryption) validate() (err error) {
+	switch e.Algorithm {
+	case AES256GCM, AES256CBCHMAC:
+	default:
+		return fmt.Errorf("unsupported encryption algorithm %q", e.Algorithm)
+	}
+
+	_, err = ParseDiversifier(e.Diversifier)
+
+	return
+}
+
+// keys derives the image encryption (and authentication) keys from the device
+// class key.
+//
+// The diversifier is bound through the HKDF info, following the fixed
+// algorithm label, rather than the salt as HMAC zero pads short keys, which
+// would derive the same keys for diversifiers differing only by trailing
+// zeroes.
+func (e *Encryption) keys(classKey []byte) (encKey []byte, macKey []byte, err error) {
+	if len(classKey) != ClassKeySize {
+		return nil, nil, errors.New("invalid class key size")
+	}
+
+	d, err := ParseDiversifier(e.Diversifier)
+
+	if err != nil {
+		return
+	}
+
+	size := 32
+
+	if e.Algorithm == AES256CBCHMAC {
+		size += sha256.Size
+	}
+
+	k, err := hkdf.Key(sha256.New, classKey, nil, "armory-boot "+e.Algorithm+" "+string(d), size)
+
+	if err != nil {
+		return
+	}
+
+	return k[0:32], k[32:], nil
+}
+
+// Encrypt encrypts an image with keys derived from the argument device class
+// key.
+func (e *Encryption) Encrypt(classKey []byte, buf []byte) (out []byte, err error) {
+	encKey, macKey, err := e.keys(classKey)
+
+	if err != nil {
+		return
+	}
+
+	block, err := aes.NewCipher(encKey)
+
+	if err != nil {
+		return
+	}
+
+	switch e.Algorithm {
+	case AES256GCM:
+		gcm, err := cipher.NewGCM(block)
+
+		if err != nil {
+			return nil, err
+		}
+
+		nonce := make([]byte, gcm.NonceSize())
+
+		if _, err = rand.Read(nonce); err != nil {
+			return nil, err
+		}
+
+		return gcm.Seal(nonce, nonce, buf, nil), nil
+	case AES256CBCHMAC:
+		n := aes.BlockSize - len(buf)%aes.BlockSize
+		out = make([]byte, aes.BlockSize+len(buf)+n)
+
+		if _, err = rand.Read(out[:aes.BlockSize]); err != nil {
+			return
+		}
+
+		copy(out[aes.BlockSize:], buf)
+
+		for i := len(out) - n; i < len(out); i++ {
+			out[i] = byte(n)
+		}
+
+		cipher.NewCBCEncrypter(block, out[:aes.BlockSize]).CryptBlocks(out[aes.BlockSize:], out[aes.BlockSize:])
+
+		mac := hmac.New(sha256.New, macKey)
+		mac.Write(out)
+
+		return mac.Sum(out), nil
+	}
+
+	return nil, fmt.Errorf("unsupported encryption algorithm %q", e.Algorithm)
+}
+
+// Decrypt authenticates and decrypts an image with keys derived from the
+// argument device class key.
+func (e *Encryption) Decrypt(classKey []byte, buf []byte) (out []byte, err error) {
+	encKey, macKey, err := e.keys(classKey)
+
+	if err != nil {
+		return
+	}
+
+	block, err := aes.NewCipher(encKey)
+
+	if err != nil {
+		return
+	}
+
+	switch e.Algorithm {
+	case AES256GCM:
+		gcm, err := cipher.NewGCM(block)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(buf) < gcm.NonceSize()+gcm.Overhead() {
+			return nil, ErrDecryption
+		}
+
+		if out, err = gcm.Open(nil, buf[:gcm.NonceSize()], buf[gcm.NonceSize():], nil); err != nil {
+			return nil, ErrDecryption
+		}
+
+		return out, nil
+	case AES256CBCHMAC:
+		n := len(buf) - sha256.Size
+
+		if n < 2*aes.BlockSize || n%aes.BlockSize != 0 {
+			return nil, ErrDecryption
+		}
+
+		mac := hmac.New(sha256.New, macKey)
+		mac.Write(buf[:n])
+
+		if !hmac.Equal(mac.Sum(nil), buf[n:]) {
+			return nil, ErrDecryption
+		}
+
+		out = make([]byte, n-aes.BlockSize)
+		cipher.NewCBCDecrypter(block, buf[:aes.BlockSize]).CryptBlocks(out, buf[aes.BlockSize:n])
+
+		pad := int(out[len(out)-1])
+
+		// the padding is authenticated, therefore it is not an oracle
+		if pad == 0 || pad > aes.BlockSize {
+			return nil, ErrDecryption
+		}
+
+		return out[:len(out)-pad], nil
+	}
+
+	return nil, fmt.Errorf("unsupported encryption algorithm %q", e.Algorithm)
+}
+
+// classKeyDiversifier returns the hardware unique key diversifier for the
+// argument device class.
+func classKeyDiversifier(class string) []byte {
+	d := sha256.Sum256([]byte("armory-boot class key " + class))
+	return d[:]
+}
+
+// WrapClassKey encrypts a device class key, with AES-256-GCM, using a key
+// derived from the hardware unique key (OTPMK) and the device class, the
+// resulting blob can only be unwrapped on the same device.
+//
+// On `GOOS=tamago` the key is derived through the NXP CAAM or DCP, which
+// requires the SNVS to be available (see snvs.Available()), while on other
+// platforms wrapping is not supported. Therefore the blob must be generated
+// on each device (e.g. by armory-boot in provisioning mode) and stored at
+// DefaultClassKeyPath.
+func WrapClassKey(key []byte, class string) (blob []byte, err error) {
+	if len(key) != ClassKeySize {
+		return nil, errors.New("invalid class key size")
+	}
+
+	kek, err := deriveKey(classKeyDiversifier(class))
+
+	if err != nil {
+		return
+	}
+	defer clear(kek)
+
+	block, err := aes.NewCipher(kek)
+
+	if err != nil {
+		return
+	}
+
+	gcm, err := cipher.NewGCM(block)
+
+	if err != nil {
+		return
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+
+	if _, err = rand.Read(nonce); err != nil {
+		return
+	}
+
+	return gcm.Seal(nonce, nonce, key, []byte(class)), nil
+}
+
+// UnwrapClassKey decrypts a device class key previously wrapped on the same
+// device with WrapClassKey().
+func UnwrapClassKey(blob []byte, class string) (key []byte, err error) {
+	kek, err := deriveKey(classKeyDiversifier(class))
+
+	if err != nil {
+		return
+	}
+	defer clear(kek)
+
+	block, err := aes.NewCipher(kek)
+
+	if err != nil {
+		return
+	}
+
+	gcm, err := cipher.NewGCM(block)
+
+	if err != nil {
+		return
+	}
+
+	if len(blob) != gcm.NonceSize()+ClassKeySize+gcm.Overhead() {
+		return nil, errors.New("invalid class key blob size")
+	}
+
+	if key, err = gcm.Open(nil, blob[:gcm.NonceSize()], blob[gcm.NonceSize():], []byte(class)); err != nil {
+		return nil, ErrDecryption
+	}
+
+	return
+}
+
+// Encrypted returns whether any of the kernel images selected for loading is
+// encrypted (see Decrypt()).
+func (c *Config) Encrypted() bool {
+	for _, e := range c.entries() {
+		if e.image.Encryption != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Decrypt decrypts, with the argument device class key, the encrypted kernel
+// images previously loaded, and authenticated as ciphertext, by a successful
+// Load().
+func (c *Config) Decrypt(classKey []byte) (err error) {
+	for _, e := range c.entries() {
+		if e.image.Encryption == nil {
+			continue
+		}
+
+		buf, err := e.image.Encryption.Decrypt(classKey, *e.buf)
+
+		if err != nil {
+			return &FieldError{Field: e.name, Err: err}
+		}
+
+		*e.buf = buf
+	}
+
+	return
+}
@@ -0,0 +1,174 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package config
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+const testDiversifier = "000102030405060708090a0b0c0d0e0f"
+
+// badPadding returns an AES-256-CBC-HMAC-SHA256 image, with a valid MAC, whose
+// plaintext ends with an invalid padding byte.
+func badPadding(t *testing.T, classKey []byte, pad byte) []byte {
+	e := &Encryption{Algorithm: AES256CBCHMAC, Diversifier: testDiversifier}
+	encKey, macKey, err := e.keys(classKey)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := aes.NewCipher(encKey)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := make([]byte, 2*aes.BlockSize)
+	out[len(out)-1] = pad
+
+	cipher.NewCBCEncrypter(block, out[:aes.BlockSize]).CryptBlocks(out[aes.BlockSize:], out[aes.BlockSize:])
+
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(out)
+
+	return mac.Sum(out)
+}
+
+func TestEncryption(t *testing.T) {
+	classKey := make([]byte, ClassKeySize)
+	rand.Read(classKey)
+
+	otherKey := make([]byte, ClassKeySize)
+	rand.Read(otherKey)
+
+	for _, alg := range []string{AES256GCM, AES256CBCHMAC} {
+		e := &Encryption{Algorithm: alg, Diversifier: testDiversifier}
+
+		for _, size := range []int{0, 1, aes.BlockSize - 1, aes.BlockSize, 1000} {
+			buf := make([]byte, size)
+			rand.Read(buf)
+
+			out, err := e.Encrypt(classKey, buf)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if dec, err := e.Decrypt(classKey, out); err != nil || !bytes.Equal(dec, buf) {
+				t.Fatalf("%s: round trip failure (size:%d), %v", alg, size, err)
+			}
+		}
+
+		buf := []byte("kernel image")
+		out, err := e.Encrypt(classKey, buf)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, tt := range []struct {
+			name     string
+			classKey []byte
+			e        *Encryption
+			tamper   func(buf []byte) []byte
+		}{
+			{
+				name:   "tampered nonce",
+				tamper: func(buf []byte) []byte { buf[0] ^= 1; return buf },
+			},
+			{
+				name:   "tampered ciphertext",
+				tamper: func(buf []byte) []byte { buf[len(buf)/2] ^= 1; return buf },
+			},
+			{
+				name:   "tampered tag",
+				tamper: func(buf []byte) []byte { buf[len(buf)-1] ^= 1; return buf },
+			},
+			{
+				name:   "truncated",
+				tamper: func(buf []byte) []byte { return buf[:len(buf)-1] },
+			},
+			{
+				name:   "extended",
+				tamper: func(buf []byte) []byte { return append(buf, 0) },
+			},
+			{
+				name:   "empty",
+				tamper: func(buf []byte) []byte { return nil },
+			},
+			{
+				name:     "wrong class key",
+				classKey: otherKey,
+			},
+			{
+				name: "wrong diversifier",
+				e:    &Encryption{Algorithm: alg, Diversifier: testDiversifier + "00"},
+			},
+		} {
+			t.Run(alg+" "+tt.name, func(t *testing.T) {
+				data := bytes.Clone(out)
+
+				if tt.tamper != nil {
+					data = tt.tamper(data)
+				}
+
+				if tt.classKey == nil {
+					tt.classKey = classKey
+				}
+
+				if tt.e == nil {
+					tt.e = e
+				}
+
+				if _, err := tt.e.Decrypt(tt.classKey, data); !errors.Is(err, ErrDecryption) {
+					t.Fatalf("unexpected error, %v", err)
+				}
+			})
+		}
+	}
+
+	e := &Encryption{Algorithm: AES256CBCHMAC, Diversifier: testDiversifier}
+
+	for _, pad := range []byte{0, aes.BlockSize + 1} {
+		if _, err := e.Decrypt(classKey, badPadding(t, classKey, pad)); !errors.Is(err, ErrDecryption) {
+			t.Fatalf("unexpected error for padding %d, %v", pad, err)
+		}
+	}
+
+	gcm, err := (&Encryption{Algorithm: AES256GCM, Diversifier: testDiversifier}).Encrypt(classKey, make([]byte, 64))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := e.Decrypt(classKey, gcm); !errors.Is(err, ErrDecryption) {
+		t.Fatalf("unexpected error for algorithm mismatch, %v", err)
+	}
+
+	if _, err := e.Decrypt(classKey[1:], gcm); err == nil || errors.Is(err, ErrDecryption) {
+		t.Fatalf("unexpected error for invalid class key, %v", err)
+	}
+}
+
+func TestParseDiversifier(t *testing.T) {
+	for _, s := range []string{"", "zz", "00", testDiversifier[2:], testDiversifier + testDiversifier + "00"} {
+		if _, err := ParseDiversifier(s); err == nil {
+			t.Fatalf("expected error for %q", s)
+		}
+	}
+
+	if d, err := ParseDiversifier(testDiversifier + testDiversifier); err != nil || len(d) != maxDiversifierSize {
+		t.Fatalf("unexpected diversifier %x, %v", d, err)
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// Configuration format versions
+const (
+	// Version1 is the original configuration format, where kernel images
+	// are encoded as positional `["path", "digest"]` arrays.
+	Version1 = 1
+	// Version2 is the configuration format where kernel images are
+	// encoded as objects (see Image).
+	Version2 = 2
+)
+
+// Address represents a memory address, it can be encoded either as a JSON
+// number or as a string in any integer literal format (e.g. "0x80800000").
+type Address uint32
+
+// UnmarshalJSON implements the [json.Unmarshaler] interface.
+func (a *Address) UnmarshalJSON(buf []byte) (err error) {
+	var s string
+	var n uint32
+
+	if err = json.Unmarshal(buf, &s); err != nil {
+		if err = json.Unmarshal(buf, &n); err != nil {
+			return
+		}
+
+		*a = Address(n)
+		return
+	}
+
+	v, err := strconv.ParseUint(s, 0, 32)
+
+	if err != nil {
+		return fmt.Errorf("invalid address %q", s)
+	}
+
+	*a = Address(v)
+
+	return
+}
+
+// MarshalJSON implements the [json.Marshaler] interface.
+func (a Address) MarshalJSON() ([]byte, error) {
+	return json.Marshal(fmt.Sprintf("%#.8x", uint32(a)))
+}
+
+// Image represents a kernel image configuration parameter.
+//
+// In version 1 configurations it is encoded as a positional array, holding
+// the image path followed by its digest, while in version 2 configurations it
+// is encoded as an object.
+type Image struct {
+	// Path is the image file path.
+	Path string `json:"path"`
+
+	// Digest is the image digest (see ParseDigest()), it must be omitted
+	// when using detached signatures.
+	Digest string `json:"digest,omitempty"`
+
+	// Size, when not zero, is the expected image size in bytes.
+	Size int64 `json:"size,omitempty"`
+
+	// LoadAddress, when not zero, overrides the default memory address at
+	// which the image is loaded, it is ignored for ELF unikernels.
+	LoadAddress Address `json:"load_addr,omitempty"`
+
+	// Encryption, when set, indicates that the image is encrypted, its
+	// digest, signature or target authenticate the ciphertext.
+	Encryption *Encryption `json:"encryption,omitempty"`
+
+	// positional parameters, set on version 1 decoding
+	param []string
+}
+
+type image Image
+
+// imageFields represents the fields allowed within version 2 image objects.
+var imageFields = []string{"path", "digest", "size", "load_addr", "encryption"}
+
+// UnmarshalJSON implements the [json.Unmarshaler] interface, both version 1
+// (positional array) and version 2 (object) encodings are accepted.
+func (i *Image) UnmarshalJSON(buf []byte) (err error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(buf), []byte("[")) {
+		dec := json.NewDecoder(bytes.NewReader(buf))
+		dec.DisallowUnknownFields()
+
+		return dec.Decode((*image)(i))
+	}
+
+	if err = json.Unmarshal(buf, &i.param); err != nil {
+		return
+	}
+
+	if len(i.param) > 0 {
+		i.Path = i.param[0]
+	}
+
+	if len(i.param) > 1 {
+		i.Digest = i.param[1]
+	}
+
+	return
+}
+
+// MarshalJSON implements the [json.Marshaler] interface, images are always
+// encoded in the version 2 (object) format.
+func (i *Image) MarshalJSON() ([]byte, error) {
+	return json.Marshal((*image)(i))
+}
+
+// Positional returns whether the image has been decoded from a version 1
+// positional array.
+func (i *Image) Positional() bool {
+	return i.param != nil
+}
+
+func (c *Config) images() (images []**Image) {
+	images = []**Image{
+		&c.KernelImage,
+		&c.DeviceTreeBlobImage,
+		&c.InitialRamDiskImage,
+		&c.UnikernelImage,
+	}
+
+	for i := range c.DeviceTreeOverlayImages {
+		images = append(images, &c.DeviceTreeOverlayImages[i])
+	}
+
+	for i := range c.Includes {
+		images = append(images, &c.Includes[i])
+	}
+
+	return
+}
+
+// normalize discards empty version 1 image parameters, which have always been
+// equivalent to omitted ones.
+func (c *Config) normalize() {
+	for _, i := range c.images() {
+		if *i != nil && (*i).Positional() && len((*i).param) == 0 {
+			*i = nil
+		}
+	}
+
+	for _, s := range c.Slots {
+		if s != nil {
+			s.normalize()
+		}
+	}
+
+	if c.Restricted != nil {
+		c.Restricted.normalize()
+	}
+}
+
+// Convert converts an armory-boot configuration file to the version 2
+// format, the input configuration must be valid and must not include
+// fragments, as these must be converted along with their digests.
+//
+// As the converted file contents differ from the original ones, any existing
+// configuration signature is invalidated.
+func Convert(buf []byte) (out []byte, err error) {
+	c := &Config{}
+
+	if err = c.decode(buf); err != nil {
+		return
+	}
+
+	if len(c.Includes) > 0 {
+		return nil, &FieldError{Field: "include", Err: ErrConflict, Detail: "fragments are not supported"}
+	}
+
+	if err = c.Validate(); err != nil {
+		return
+	}
+
+	c.Version = Version2
+
+	c.convert()
+
+	return json.MarshalIndent(c, "", "  ")
+}
+
+func (c *Config) convert() {
+	for _, i := range c.images() {
+		if *i != nil {
+			(*i).param = nil
+		}
+	}
+
+	for _, s := range c.Slots {
+		s.convert()
+	}
+
+	if c.Restricted != nil {
+		c.Restricted.convert()
+	}
+}
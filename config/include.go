@@ -0,0 +1,172 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// decodeFragment strictly decodes a configuration fragment, which can only
+// hold kernel parameters.
+func decodeFragment(buf []byte, prefix string) (f *Config, err error) {
+	m, err := checkFields(buf, prefix, slotFields)
+
+	if err != nil {
+		return
+	}
+
+	if err = checkImageFields(m, prefix); err != nil {
+		return
+	}
+
+	f = &Config{}
+
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.DisallowUnknownFields()
+
+	if err = dec.Decode(f); err != nil {
+		var typeErr *json.UnmarshalTypeError
+
+		if errors.As(err, &typeErr) {
+			return nil, &FieldError{Field: prefix + typeErr.Field, Err: ErrInvalidType}
+		}
+
+		return nil, &FieldError{Field: strings.TrimSuffix(prefix, "."), Err: ErrSyntax, Detail: err.Error()}
+	}
+
+	if _, err = dec.Token(); err != io.EOF {
+		return nil, &FieldError{Field: strings.TrimSuffix(prefix, "."), Err: ErrSyntax, Detail: "trailing data"}
+	}
+
+	f.normalize()
+
+	return f, nil
+}
+
+// merge fills the kernel parameters left unset with the ones of a fragment
+// with lower precedence, environment variables are merged by key.
+func (c *Config) merge(f *Config) {
+	if c.KernelImage == nil {
+		c.KernelImage = f.KernelImage
+	}
+
+	if c.DeviceTreeBlobImage == nil {
+		c.DeviceTreeBlobImage = f.DeviceTreeBlobImage
+	}
+
+	if c.InitialRamDiskImage == nil {
+		c.InitialRamDiskImage = f.InitialRamDiskImage
+	}
+
+	if c.UnikernelImage == nil {
+		c.UnikernelImage = f.UnikernelImage
+	}
+
+	if len(c.CmdLine) == 0 {
+		c.CmdLine = f.CmdLine
+	}
+
+	keys := make(map[string]bool)
+
+	for _, env := range c.Env {
+		k, _, _ := strings.Cut(env, "=")
+		keys[k] = true
+	}
+
+	for _, env := range f.Env {
+		if k, _, _ := strings.Cut(env, "="); !keys[k] {
+			c.Env = append(c.Env, env)
+		}
+	}
+}
+
+// loadInclude reads, authenticates and decodes a configuration fragment.
+func (c *Config) loadInclude(part Partition, keys *Keys, name string, include *Image) (f *Config, err error) {
+	buf, err := part.ReadAll(include.Path)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid path %s, %v", include.Path, err)
+	}
+
+	if include.Size > 0 && int64(len(buf)) != include.Size {
+		return nil, &FieldError{Field: name, Err: ErrSizeMismatch, Detail: fmt.Sprintf("%d != %d", len(buf), include.Size)}
+	}
+
+	if err = c.authenticate(part, keys, name, include, buf); err != nil {
+		return
+	}
+
+	return decodeFragment(buf, name+".")
+}
+
+// loadIncludes reads the configuration fragments and merges them with the
+// configuration kernel parameters, or with the ones of each slot if any is
+// defined, as well as with the restricted ones.
+//
+// Fragments are authenticated, when keys or targets are set, either through
+// their digest, detached signature or target.
+func (c *Config) loadIncludes(part Partition, keys *Keys, version int) (err error) {
+	var fragments []*Config
+
+	for i, include := range c.Includes {
+		name := fmt.Sprintf("include.%d", i)
+
+		if err = validateImage(name, include, version, c.Detached, true); err != nil {
+			return
+		}
+
+		f, err := c.loadInclude(part, keys, name, include)
+
+		c.Results = append(c.Results, Result{
+			Name: name,
+			Path: include.Path,
+			Err:  err,
+		})
+
+		if err != nil {
+			return err
+		}
+
+		fragments = append(fragments, f)
+	}
+
+	targets := []*Config{c}
+
+	if len(c.Slots) > 0 {
+		targets = nil
+
+		for _, s := range c.Slots {
+			if s != nil {
+				targets = append(targets, s)
+			}
+		}
+	}
+
+	if c.Restricted != nil {
+		targets = append(targets, c.Restricted)
+	}
+
+	for _, t := range targets {
+		overlays := t.DeviceTreeOverlayImages
+
+		for i := len(fragments) - 1; i >= 0; i-- {
+			t.merge(fragments[i])
+		}
+
+		for _, f := range fragments {
+			overlays = append(overlays, f.DeviceTreeOverlayImages...)
+		}
+
+		t.DeviceTreeOverlayImages = overlays
+	}
+
+	return
+}
@@ -0,0 +1,261 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package config
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultKeyPath is the default armory-boot public key path, such key is only
+// trusted when anchored by a digest held in the device (see KeyDigest()).
+const DefaultKeyPath = "/boot/armory-boot.pub"
+
+// minisignKeySize is the size of base64 encoded signify/minisign public keys.
+const minisignKeySize = 56
+
+// Keys represents a set of trusted signify/minisign public keys, OpenSSH
+// public keys, OpenPGP public keys, X.509 root certificates and post-quantum
+// public keys.
+type Keys struct {
+	// signify/minisign public keys, indexed by key identifier
+	minisign map[[8]byte]*PublicKey
+	// post-quantum public keys, indexed by key identifier
+	pq map[[8]byte]*PQPublicKey
+	// hybrid mode, set when any post-quantum key is trusted
+	hybrid bool
+	// minimum number of distinct signing keys
+	threshold int
+	// OpenSSH public keys, indexed by wire format encoding
+	ssh map[string]crypto.PublicKey
+	// OpenPGP public keys and subkeys, indexed by key identifier
+	pgp map[[8]byte]*pgpKey
+	// X.509 trusted root certificates
+	roots []*x509.Certificate
+	// X.509 certificate revocation lists
+	crls []*x509.RevocationList
+	// X.509 certificates retired by the key revocation list, indexed
+	// by SHA-256 fingerprint
+	retired map[[32]byte]bool
+	// minimum key revocation list sequence number
+	minSequence uint64
+	// applied key revocation list sequence number
+	sequence uint64
+	// time against which key expiry is enforced
+	now time.Time
+}
+
+// KeyDigest returns the SHA-256 digest of a public key string, in the format
+// expected by NewKeys() and with surrounding whitespace removed.
+func KeyDigest(s string) [32]byte {
+	return sha256.Sum256([]byte(strings.TrimSpace(s)))
+}
+
+// NewKeys parses a comma or whitespace separated list of trusted keys, each
+// must be either the last line of a signify/minisign public key (i.e. without
+// comments), a base64 encoded DER X.509 root certificate, a base64 encoded
+// binary OpenPGP public key or keyring (e.g. `gpg --export | base64 -w0`) or a
+// post-quantum public key (see NewPQPublicKey()).
+//
+// OpenSSH public keys can also be passed in `authorized_keys` format (e.g.
+// `ssh-ed25519 AAAA... user@host`), optionally preceded by options, as
+// whitespace separates their fields each must be separated from other keys by
+// commas or newlines, and any text following the key is its comment.
+//
+// When post-quantum keys are passed, hybrid mode is enabled and only
+// signify/minisign signatures which are accompanied by a valid post-quantum
+// signature are accepted.
+func NewKeys(s string) (keys *Keys, err error) {
+	keys = &Keys{
+		minisign: make(map[[8]byte]*PublicKey),
+		pq:       make(map[[8]byte]*PQPublicKey),
+		ssh:      make(map[string]crypto.PublicKey),
+		pgp:      make(map[[8]byte]*pgpKey),
+		retired:  make(map[[32]byte]bool),
+	}
+
+	for _, line := range splitKeys(s) {
+		if isSSHKey(line) {
+			if err = keys.addSSH(line); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		for _, k := range strings.Fields(line) {
+			if len(k) > minisignKeySize && isPQKey(k) {
+				pub, err := NewPQPublicKey(k)
+
+				if err != nil {
+					return nil, err
+				}
+
+				keys.pq[pub.KeyId] = pub
+				keys.hybrid = true
+
+				continue
+			}
+
+			if len(k) > minisignKeySize && isPGPKey(k) {
+				if err = keys.addPGP(k); err != nil {
+					return nil, err
+				}
+
+				continue
+			}
+
+			if len(k) > minisignKeySize {
+				if err = keys.addRoot(k); err != nil {
+					return nil, err
+				}
+
+				continue
+			}
+
+			pub, err := NewPublicKey(k)
+
+			if err != nil {
+				return nil, err
+			}
+
+			keys.minisign[pub.KeyId] = &pub
+		}
+	}
+
+	if keys.hybrid && len(keys.minisign) == 0 {
+		return nil, errors.New("post-quantum keys require signify/minisign keys")
+	}
+
+	if len(keys.minisign) == 0 && len(keys.ssh) == 0 && len(keys.pgp) == 0 && len(keys.roots) == 0 {
+		return nil, errors.New("no public keys")
+	}
+
+	return
+}
+
+// KeyID returns the hexadecimal representation of a key identifier, as
+// displayed by minisign.
+func KeyID(id [8]byte) string {
+	return fmt.Sprintf("%016X", binary.LittleEndian.Uint64(id[:]))
+}
+
+// ParseKeyID parses the hexadecimal representation of a key identifier, as
+// displayed by minisign.
+func ParseKeyID(s string) (id [8]byte, err error) {
+	buf, err := hex.DecodeString(s)
+
+	if err != nil || len(buf) != 8 {
+		return id, fmt.Errorf("invalid key identifier %q", s)
+	}
+
+	for i := range buf {
+		id[i] = buf[len(buf)-1-i]
+	}
+
+	return
+}
+
+// Verify authenticates an input against a signify/minisign generated
+// signature, using the trusted key matching the signature key identifier, an
+// OpenSSH signature (see SSHNamespace) or OpenPGP detached signature made with
+// a trusted key, or a CMS or simple X.509 signature chaining up to a trusted
+// root certificate.
+func (keys *Keys) Verify(buf []byte, sig []byte) (err error) {
+	_, err = keys.VerifyMetadata(buf, sig)
+	return
+}
+
+// VerifyMetadata authenticates an input like Verify() and returns the signature
+// trusted comment metadata, which is nil for signatures without a trusted
+// comment (e.g. signify, OpenSSH). X.509 signatures metadata holds the signing
+// time, when present, and the verified certificate chain, OpenPGP signatures
+// metadata holds their creation and expiration time.
+//
+// In hybrid mode (see NewKeys()) only signify/minisign signatures are
+// accepted, and additionally verified against the post-quantum signatures
+// appended to them.
+//
+// When a signature threshold is set (see SetThreshold()) the argument
+// signature can be a bundle of signatures, which must be made by enough
+// distinct trusted keys, their metadata is merged (see mergeMetadata()).
+func (keys *Keys) VerifyMetadata(buf []byte, sig []byte) (m *Metadata, err error) {
+	return keys.verifySignatures(buf, [][]byte{sig})
+}
+
+// verify authenticates an input against a single signature, returning its
+// metadata and the identity of the key that made it.
+func (keys *Keys) verify(buf []byte, sig []byte) (m *Metadata, id string, err error) {
+	if keys.hybrid && (isSSHSignature(sig) || isPGPSignature(sig) || isX509Signature(sig)) {
+		return nil, "", errors.New("invalid signature, post-quantum signature required")
+	}
+
+	if isSSHSignature(sig) {
+		id, err = keys.verifySSH(buf, sig)
+		return
+	}
+
+	if isPGPSignature(sig) {
+		return keys.verifyPGP(buf, sig)
+	}
+
+	if isX509Signature(sig) {
+		if m, err = keys.verifyX509(buf, sig); err != nil {
+			return
+		}
+
+		// X.509 signatures are identified by their trusted root
+		return m, "x509:" + string(m.Certificates[len(m.Certificates)-1].Raw), nil
+	}
+
+	str, pq := splitPQSignature(sig)
+	s, err := DecodeSignature(str)
+
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid signature, %v", err)
+	}
+
+	pub, ok := keys.minisign[s.KeyId]
+
+	if !ok {
+		return nil, "", fmt.Errorf("invalid signature, untrusted key %s", KeyID(s.KeyId))
+	}
+
+	valid, err := pub.Verify(buf, s)
+
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid signature, %v", err)
+	}
+
+	if !valid {
+		return nil, "", errors.New("invalid signature")
+	}
+
+	if keys.hybrid {
+		if err = keys.verifyPQ(buf, s, pq); err != nil {
+			return nil, "", err
+		}
+	}
+
+	id = "minisign:" + string(s.KeyId[:])
+
+	if len(s.TrustedComment) == 0 {
+		return
+	}
+
+	if m, err = ParseTrustedComment(s.TrustedComment); err != nil {
+		return nil, "", fmt.Errorf("invalid signature, %v", err)
+	}
+
+	return
+}
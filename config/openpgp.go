@@ -0,0 +1,619 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package config
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// OpenPGP packet tags
+const (
+	pgpTagSignature    = 2
+	pgpTagPublicKey    = 6
+	pgpTagPublicSubkey = 14
+)
+
+// OpenPGP public key algorithms
+const (
+	pgpRSA        = 1
+	pgpRSASign    = 3
+	pgpEdDSA      = 22
+	pgpEd25519    = 27
+	pgpMinRSABits = 2048
+)
+
+// OpenPGP hash algorithms
+const (
+	pgpSHA256 = 8
+	pgpSHA512 = 10
+)
+
+// OpenPGP signature subpackets
+const (
+	pgpSubpacketCreationTime      = 2
+	pgpSubpacketExpirationTime    = 3
+	pgpSubpacketIssuer            = 16
+	pgpSubpacketIssuerFingerprint = 33
+)
+
+const (
+	pgpArmorBegin = "-----BEGIN PGP SIGNATURE-----"
+	pgpArmorEnd   = "-----END PGP SIGNATURE-----"
+)
+
+// pgpEd25519OID is the Ed25519 curve OID of legacy EdDSA keys.
+var pgpEd25519OID = []byte{0x2b, 0x06, 0x01, 0x04, 0x01, 0xda, 0x47, 0x0f, 0x01}
+
+// pgpKey represents an OpenPGP public key or subkey.
+type pgpKey struct {
+	// public key
+	pub crypto.PublicKey
+	// primary key fingerprint
+	primary string
+}
+
+// pgpSignature represents a parsed OpenPGP v4 signature packet.
+type pgpSignature struct {
+	// signature type
+	sigType byte
+	// public key algorithm
+	pubKeyAlgorithm byte
+	// hash algorithm
+	hashAlgorithm byte
+	// hashed portion of the signature packet
+	hashed []byte
+	// left 16 bits of the signed hash
+	hashTag []byte
+	// issuer key identifier
+	issuer []byte
+	// signature creation time
+	time time.Time
+	// signature expiration time
+	expiry time.Time
+	// signature value (RSA) or concatenated R and S values (EdDSA)
+	sig []byte
+}
+
+// readPacket parses an OpenPGP packet header, partial body lengths are not
+// supported.
+func readPacket(buf []byte) (tag byte, body []byte, rest []byte, err error) {
+	var n, off int
+
+	if len(buf) < 2 || buf[0]&0x80 == 0 {
+		return 0, nil, nil, errors.New("invalid packet")
+	}
+
+	if buf[0]&0x40 == 0 {
+		// old format
+		tag = (buf[0] >> 2) & 0x0f
+
+		switch buf[0] & 0x03 {
+		case 0:
+			n, off = int(buf[1]), 2
+		case 1:
+			if len(buf) < 3 {
+				return 0, nil, nil, errors.New("invalid packet")
+			}
+
+			n, off = int(binary.BigEndian.Uint16(buf[1:])), 3
+		case 2:
+			if len(buf) < 5 {
+				return 0, nil, nil, errors.New("invalid packet")
+			}
+
+			n, off = int(binary.BigEndian.Uint32(buf[1:])), 5
+		default:
+			return 0, nil, nil, errors.New("unsupported packet length")
+		}
+	} else {
+		// new format
+		tag = buf[0] & 0x3f
+
+		switch {
+		case buf[1] < 192:
+			n, off = int(buf[1]), 2
+		case buf[1] < 224:
+			if len(buf) < 3 {
+				return 0, nil, nil, errors.New("invalid packet")
+			}
+
+			n, off = (int(buf[1])-192)<<8+int(buf[2])+192, 3
+		case buf[1] == 255:
+			if len(buf) < 6 {
+				return 0, nil, nil, errors.New("invalid packet")
+			}
+
+			n, off = int(binary.BigEndian.Uint32(buf[2:])), 6
+		default:
+			return 0, nil, nil, errors.New("unsupported packet length")
+		}
+	}
+
+	if n < 0 || len(buf)-off < n {
+		return 0, nil, nil, errors.New("invalid packet length")
+	}
+
+	return tag, buf[off : off+n], buf[off+n:], nil
+}
+
+// readMPI parses an OpenPGP multiprecision integer.
+func readMPI(buf []byte) (mpi []byte, rest []byte, err error) {
+	if len(buf) < 2 {
+		return nil, nil, errors.New("invalid MPI")
+	}
+
+	n := (int(binary.BigEndian.Uint16(buf)) + 7) / 8
+
+	if len(buf)-2 < n {
+		return nil, nil, errors.New("invalid MPI")
+	}
+
+	return buf[2 : 2+n], buf[2+n:], nil
+}
+
+// leftPad returns the argument value, left padded with zeroes to the argument
+// size.
+func leftPad(buf []byte, size int) []byte {
+	if len(buf) >= size {
+		return buf
+	}
+
+	return append(make([]byte, size-len(buf)), buf...)
+}
+
+// isPGPSignature returns whether the argument signature is a binary or ASCII
+// armored OpenPGP signature, binary signatures are identified by their packet
+// tag alone so that malformed ones are not parsed as other formats.
+func isPGPSignature(sig []byte) bool {
+	if bytes.HasPrefix(bytes.TrimSpace(sig), []byte(pgpArmorBegin)) {
+		return true
+	}
+
+	if len(sig) == 0 || sig[0]&0x80 == 0 {
+		return false
+	}
+
+	if sig[0]&0x40 == 0 {
+		// old format
+		return (sig[0]>>2)&0x0f == pgpTagSignature
+	}
+
+	return sig[0]&0x3f == pgpTagSignature
+}
+
+// isPGPKey returns whether the argument string is a base64 encoded binary
+// OpenPGP public key (or keyring).
+func isPGPKey(s string) bool {
+	buf, err := base64.StdEncoding.DecodeString(s)
+
+	if err != nil {
+		return false
+	}
+
+	tag, _, _, err := readPacket(buf)
+
+	return err == nil && tag == pgpTagPublicKey
+}
+
+// parsePGPPublicKey parses an OpenPGP v4 public key packet body, returning
+// the public key and its fingerprint.
+func parsePGPPublicKey(body []byte) (pub crypto.PublicKey, fingerprint []byte, err error) {
+	if len(body) < 6 || body[0] != 4 {
+		return nil, nil, errors.New("unsupported key version")
+	}
+
+	h := sha1.New()
+	h.Write([]byte{0x99, byte(len(body) >> 8), byte(len(body))})
+	h.Write(body)
+	fingerprint = h.Sum(nil)
+
+	algorithm := body[5]
+	key := body[6:]
+
+	switch algorithm {
+	case pgpRSA, pgpRSASign:
+		n, rest, err := readMPI(key)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		e, _, err := readMPI(rest)
+
+		if err != nil || len(e) > 4 {
+			return nil, nil, errors.New("invalid RSA key")
+		}
+
+		pub := &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+
+		if pub.N.BitLen() < pgpMinRSABits {
+			return nil, nil, fmt.Errorf("RSA key size below %d bits", pgpMinRSABits)
+		}
+
+		return pub, fingerprint, nil
+	case pgpEdDSA:
+		if len(key) < 1 || len(key) < 1+int(key[0]) || !bytes.Equal(key[1:1+key[0]], pgpEd25519OID) {
+			return nil, nil, errors.New("unsupported EdDSA curve")
+		}
+
+		point, _, err := readMPI(key[1+key[0]:])
+
+		if err != nil || len(point) != 1+ed25519.PublicKeySize || point[0] != 0x40 {
+			return nil, nil, errors.New("invalid EdDSA key")
+		}
+
+		return ed25519.PublicKey(point[1:]), fingerprint, nil
+	case pgpEd25519:
+		if len(key) != ed25519.PublicKeySize {
+			return nil, nil, errors.New("invalid Ed25519 key")
+		}
+
+		return ed25519.PublicKey(key), fingerprint, nil
+	}
+
+	return nil, nil, fmt.Errorf("unsupported public key algorithm %d", algorithm)
+}
+
+// addPGP parses a base64 encoded binary OpenPGP public key (or keyring), all
+// primary keys and subkeys with supported algorithms are trusted.
+//
+// As the keyring is trusted as a whole, subkey binding signatures are not
+// verified and key revocation and expiration are not enforced.
+func (keys *Keys) addPGP(s string) (err error) {
+	var primary string
+	var n int
+
+	buf, err := base64.StdEncoding.DecodeString(s)
+
+	if err != nil {
+		return errors.New("invalid encoded OpenPGP public key")
+	}
+
+	for len(buf) > 0 {
+		var tag byte
+		var body []byte
+
+		if tag, body, buf, err = readPacket(buf); err != nil {
+			return fmt.Errorf("invalid OpenPGP public key, %v", err)
+		}
+
+		if tag != pgpTagPublicKey && tag != pgpTagPublicSubkey {
+			continue
+		}
+
+		pub, fingerprint, err := parsePGPPublicKey(body)
+
+		if tag == pgpTagPublicKey {
+			primary = string(fingerprint)
+		}
+
+		if err != nil {
+			continue
+		}
+
+		var id [8]byte
+		copy(id[:], fingerprint[len(fingerprint)-8:])
+
+		keys.pgp[id] = &pgpKey{
+			pub:     pub,
+			primary: primary,
+		}
+
+		n++
+	}
+
+	if n == 0 {
+		return errors.New("invalid OpenPGP public key, no supported keys")
+	}
+
+	return
+}
+
+// crc24 computes the OpenPGP armor checksum.
+func crc24(buf []byte) (crc uint32) {
+	crc = 0xb704ce
+
+	for _, b := range buf {
+		crc ^= uint32(b) << 16
+
+		for range 8 {
+			crc <<= 1
+
+			if crc&0x1000000 != 0 {
+				crc ^= 0x1864cfb
+			}
+		}
+	}
+
+	return crc & 0xffffff
+}
+
+// decodePGPArmor decodes an ASCII armored OpenPGP signature.
+func decodePGPArmor(in []byte) (buf []byte, err error) {
+	var body []string
+	var checksum string
+
+	lines := strings.Split(strings.TrimSpace(string(in)), "\n")
+
+	if len(lines) < 3 || strings.TrimSpace(lines[0]) != pgpArmorBegin || strings.TrimSpace(lines[len(lines)-1]) != pgpArmorEnd {
+		return nil, errors.New("invalid armor")
+	}
+
+	lines = lines[1 : len(lines)-1]
+
+	// skip armor headers
+	for i, line := range lines {
+		if len(strings.TrimSpace(line)) == 0 {
+			lines = lines[i+1:]
+			break
+		}
+	}
+
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+
+		if strings.HasPrefix(line, "=") {
+			checksum = line[1:]
+			break
+		}
+
+		body = append(body, line)
+	}
+
+	if buf, err = base64.StdEncoding.DecodeString(strings.Join(body, "")); err != nil {
+		return nil, errors.New("invalid armor encoding")
+	}
+
+	if len(checksum) > 0 {
+		crc, err := base64.StdEncoding.DecodeString(checksum)
+
+		if err != nil || len(crc) != 3 || uint32(crc[0])<<16|uint32(crc[1])<<8|uint32(crc[2]) != crc24(buf) {
+			return nil, errors.New("invalid armor checksum")
+		}
+	}
+
+	return
+}
+
+// parseSubpackets parses the signature subpackets relevant for verification,
+// rejecting any other subpacket flagged as critical (RFC 4880 5.2.3.1).
+func (s *pgpSignature) parseSubpackets(buf []byte, hashed bool) (err error) {
+	for len(buf) > 0 {
+		var n, off int
+
+		switch {
+		case buf[0] < 192:
+			n, off = int(buf[0]), 1
+		case buf[0] < 255:
+			if len(buf) < 2 {
+				return errors.New("invalid subpacket")
+			}
+
+			n, off = (int(buf[0])-192)<<8+int(buf[1])+192, 2
+		default:
+			if len(buf) < 5 {
+				return errors.New("invalid subpacket")
+			}
+
+			n, off = int(binary.BigEndian.Uint32(buf[1:])), 5
+		}
+
+		if n < 1 || len(buf)-off < n {
+			return errors.New("invalid subpacket length")
+		}
+
+		t, critical, data := buf[off]&0x7f, buf[off]&0x80 != 0, buf[off+1:off+n]
+		buf = buf[off+n:]
+
+		switch {
+		case t == pgpSubpacketCreationTime && hashed && len(data) == 4:
+			s.time = time.Unix(int64(binary.BigEndian.Uint32(data)), 0)
+		case t == pgpSubpacketExpirationTime && hashed && len(data) == 4:
+			if d := binary.BigEndian.Uint32(data); d > 0 {
+				s.expiry = s.time.Add(time.Duration(d) * time.Second)
+			}
+		case t == pgpSubpacketIssuer && len(data) == 8 && s.issuer == nil:
+			s.issuer = data
+		case t == pgpSubpacketIssuerFingerprint && len(data) == 21 && data[0] == 4:
+			s.issuer = data[len(data)-8:]
+		case critical:
+			return fmt.Errorf("unsupported critical subpacket %d", t)
+		}
+	}
+
+	return
+}
+
+// decodePGPSignature parses a binary or ASCII armored OpenPGP v4 signature.
+func decodePGPSignature(in []byte) (s *pgpSignature, err error) {
+	if bytes.HasPrefix(bytes.TrimSpace(in), []byte(pgpArmorBegin)) {
+		if in, err = decodePGPArmor(in); err != nil {
+			return
+		}
+	}
+
+	tag, body, _, err := readPacket(in)
+
+	if err != nil {
+		return
+	}
+
+	if tag != pgpTagSignature {
+		return nil, errors.New("invalid signature packet")
+	}
+
+	if len(body) < 6 || body[0] != 4 {
+		return nil, errors.New("unsupported signature version")
+	}
+
+	s = &pgpSignature{
+		sigType:         body[1],
+		pubKeyAlgorithm: body[2],
+		hashAlgorithm:   body[3],
+	}
+
+	n := int(binary.BigEndian.Uint16(body[4:]))
+
+	if len(body) < 6+n+2 {
+		return nil, errors.New("invalid signature packet")
+	}
+
+	s.hashed = body[0 : 6+n]
+
+	if err = s.parseSubpackets(body[6:6+n], true); err != nil {
+		return nil, err
+	}
+
+	rest := body[6+n:]
+	n = int(binary.BigEndian.Uint16(rest))
+
+	if len(rest) < 2+n+2 {
+		return nil, errors.New("invalid signature packet")
+	}
+
+	if err = s.parseSubpackets(rest[2:2+n], false); err != nil {
+		return nil, err
+	}
+
+	s.hashTag = rest[2+n : 2+n+2]
+	rest = rest[2+n+2:]
+
+	switch s.pubKeyAlgorithm {
+	case pgpRSA, pgpRSASign:
+		if s.sig, _, err = readMPI(rest); err != nil {
+			return nil, err
+		}
+	case pgpEdDSA:
+		r, rest, err := readMPI(rest)
+
+		if err != nil {
+			return nil, err
+		}
+
+		sv, _, err := readMPI(rest)
+
+		if err != nil || len(r) > 32 || len(sv) > 32 {
+			return nil, errors.New("invalid EdDSA signature")
+		}
+
+		s.sig = append(leftPad(r, 32), leftPad(sv, 32)...)
+	case pgpEd25519:
+		if len(rest) != ed25519.SignatureSize {
+			return nil, errors.New("invalid Ed25519 signature")
+		}
+
+		s.sig = rest
+	default:
+		return nil, fmt.Errorf("unsupported public key algorithm %d", s.pubKeyAlgorithm)
+	}
+
+	return
+}
+
+// digest computes the signed hash of the argument input.
+func (s *pgpSignature) digest(buf []byte) (h crypto.Hash, sum []byte, err error) {
+	var d hash.Hash
+
+	switch s.hashAlgorithm {
+	case pgpSHA256:
+		h, d = crypto.SHA256, sha256.New()
+	case pgpSHA512:
+		h, d = crypto.SHA512, sha512.New()
+	default:
+		return 0, nil, fmt.Errorf("unsupported hash algorithm %d", s.hashAlgorithm)
+	}
+
+	d.Write(buf)
+	d.Write(s.hashed)
+	d.Write([]byte{4, 0xff})
+	binary.Write(d, binary.BigEndian, uint32(len(s.hashed)))
+
+	return h, d.Sum(nil), nil
+}
+
+// verifyPGP authenticates an input against a binary or ASCII armored OpenPGP
+// detached signature (e.g. `gpg --detach-sign`), made with any of the trusted
+// OpenPGP keys, the signing primary key fingerprint is returned.
+func (keys *Keys) verifyPGP(buf []byte, sig []byte) (m *Metadata, id string, err error) {
+	s, err := decodePGPSignature(sig)
+
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid signature, %v", err)
+	}
+
+	if s.sigType != 0x00 {
+		return nil, "", fmt.Errorf("invalid signature, unsupported signature type %#x", s.sigType)
+	}
+
+	var issuer [8]byte
+	copy(issuer[:], s.issuer)
+
+	key, ok := keys.pgp[issuer]
+
+	if !ok {
+		return nil, "", fmt.Errorf("invalid signature, untrusted key %X", issuer)
+	}
+
+	h, sum, err := s.digest(buf)
+
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid signature, %v", err)
+	}
+
+	if !bytes.Equal(sum[0:2], s.hashTag) {
+		return nil, "", errors.New("invalid signature")
+	}
+
+	valid := false
+
+	switch pub := key.pub.(type) {
+	case *rsa.PublicKey:
+		valid = (s.pubKeyAlgorithm == pgpRSA || s.pubKeyAlgorithm == pgpRSASign) &&
+			rsa.VerifyPKCS1v15(pub, h, sum, leftPad(s.sig, pub.Size())) == nil
+	case ed25519.PublicKey:
+		valid = (s.pubKeyAlgorithm == pgpEdDSA || s.pubKeyAlgorithm == pgpEd25519) &&
+			ed25519.Verify(pub, sum, s.sig)
+	}
+
+	if !valid {
+		return nil, "", errors.New("invalid signature")
+	}
+
+	m = &Metadata{
+		Timestamp: s.time,
+		Expiry:    s.expiry,
+	}
+
+	return m, "pgp:" + key.primary, nil
+}
+
+// pgpPrimaryKeys returns the number of distinct trusted OpenPGP primary keys.
+func (keys *Keys) pgpPrimaryKeys() int {
+	primary := make(map[string]bool)
+
+	for _, key := range keys.pgp {
+		primary[key.primary] = true
+	}
+
+	return len(primary)
+}
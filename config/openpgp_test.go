@@ -0,0 +1,150 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package config
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The testdata OpenPGP keys and signatures are generated with GnuPG:
+//
+//	gpg --quick-gen-key "armory-boot ed25519 <ed25519@example.com>" ed25519 sign never
+//	gpg --quick-gen-key "armory-boot rsa <rsa@example.com>" rsa3072 sign never
+//	gpg --export $KEY | base64 -w0 > ed25519.pub
+//	gpg -u $KEY [--armor|--textmode|--sig-notation '!...'] --detach-sign armory-boot.conf
+
+func readTestData(t *testing.T, name string) []byte {
+	buf, err := os.ReadFile(filepath.Join("testdata", name))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buf
+}
+
+// hashTagOffset returns the offset of the left 16 bits of the signed hash
+// within a binary OpenPGP signature.
+func hashTagOffset(t *testing.T, sig []byte) int {
+	_, body, rest, err := readPacket(sig)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	off := len(sig) - len(rest) - len(body) + 6
+	off += int(binary.BigEndian.Uint16(body[4:]))
+	off += 2 + int(binary.BigEndian.Uint16(sig[off:]))
+
+	return off
+}
+
+func TestVerifyPGP(t *testing.T) {
+	buf := readTestData(t, "armory-boot.conf")
+	ed25519 := readTestData(t, "armory-boot.conf.ed25519.sig")
+	rsa := readTestData(t, "armory-boot.conf.rsa.sig")
+
+	tag := bytes.Clone(ed25519)
+	tag[hashTagOffset(t, tag)] ^= 0xff
+
+	armor := readTestData(t, "armory-boot.conf.rsa.asc")
+	lines := strings.Split(string(armor), "\n")
+	truncated := strings.Join(append(lines[:3], lines[4:]...), "\n")
+
+	for _, tt := range []struct {
+		name string
+		buf  []byte
+		sig  []byte
+		err  string
+	}{
+		{
+			name: "valid Ed25519",
+			sig:  ed25519,
+		},
+		{
+			name: "valid Ed25519 armored",
+			sig:  readTestData(t, "armory-boot.conf.ed25519.asc"),
+		},
+		{
+			name: "valid RSA",
+			sig:  rsa,
+		},
+		{
+			name: "valid RSA armored",
+			sig:  armor,
+		},
+		{
+			name: "content mismatch",
+			buf:  []byte("tampered"),
+			sig:  rsa,
+			err:  "invalid signature",
+		},
+		{
+			name: "hash tag mismatch",
+			sig:  tag,
+			err:  "invalid signature",
+		},
+		{
+			name: "truncated packet",
+			sig:  ed25519[:len(ed25519)-8],
+			err:  "invalid packet length",
+		},
+		{
+			// valid packet length, body truncated after the hash tag
+			name: "truncated signature",
+			sig:  append([]byte{ed25519[0], byte(hashTagOffset(t, ed25519))}, ed25519[2:hashTagOffset(t, ed25519)+2]...),
+			err:  "invalid MPI",
+		},
+		{
+			name: "truncated armor",
+			sig:  []byte(truncated),
+			err:  "invalid armor checksum",
+		},
+		{
+			name: "unknown issuer",
+			sig:  readTestData(t, "armory-boot.conf.untrusted.sig"),
+			err:  "untrusted key",
+		},
+		{
+			name: "wrong signature type",
+			sig:  readTestData(t, "armory-boot.conf.text.sig"),
+			err:  "unsupported signature type 0x1",
+		},
+		{
+			name: "unknown critical subpacket",
+			sig:  readTestData(t, "armory-boot.conf.critical.sig"),
+			err:  "unsupported critical subpacket 20",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := NewKeys(string(readTestData(t, "ed25519.pub")) + "," + string(readTestData(t, "rsa.pub")))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.buf == nil {
+				tt.buf = buf
+			}
+
+			m, err := keys.VerifyMetadata(tt.buf, tt.sig)
+
+			switch {
+			case len(tt.err) == 0 && err != nil:
+				t.Fatalf("unexpected error, %v", err)
+			case len(tt.err) > 0 && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Fatalf("expected error %q, got %v", tt.err, err)
+			case err == nil && m.Timestamp.IsZero():
+				t.Fatalf("invalid metadata %+v", m)
+			}
+		})
+	}
+}
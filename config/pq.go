@@ -0,0 +1,162 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"filippo.io/mldsa"
+	"golang.org/x/crypto/blake2b"
+)
+
+// PQContext is the ML-DSA context string of post-quantum signatures.
+const PQContext = "armory-boot"
+
+// Post-quantum signature algorithm identifiers, ML-DSA (FIPS 204) parameter
+// sets are identified by their security category.
+var (
+	MLDSA44 = [2]byte{'M', '2'}
+	MLDSA65 = [2]byte{'M', '3'}
+	MLDSA87 = [2]byte{'M', '5'}
+)
+
+// PQPublicKey represents a post-quantum public key.
+type PQPublicKey struct {
+	SignatureAlgorithm [2]byte
+	KeyId              [8]byte
+	PublicKey          *mldsa.PublicKey
+}
+
+// PQParameters returns the ML-DSA parameter set for the argument signature
+// algorithm identifier.
+func PQParameters(alg [2]byte) (params *mldsa.Parameters, err error) {
+	switch alg {
+	case MLDSA44:
+		return mldsa.MLDSA44(), nil
+	case MLDSA65:
+		return mldsa.MLDSA65(), nil
+	case MLDSA87:
+		return mldsa.MLDSA87(), nil
+	}
+
+	return nil, fmt.Errorf("unsupported post-quantum algorithm %q", alg[:])
+}
+
+// NewPQPublicKey parses a post-quantum public key, consisting of the base64
+// encoding of its signature algorithm identifier, key identifier and public
+// key.
+func NewPQPublicKey(s string) (pub *PQPublicKey, err error) {
+	buf, err := base64.StdEncoding.DecodeString(s)
+
+	if err != nil || len(buf) < 10 {
+		return nil, errors.New("invalid encoded post-quantum public key")
+	}
+
+	pub = &PQPublicKey{}
+
+	copy(pub.SignatureAlgorithm[:], buf[0:2])
+	copy(pub.KeyId[:], buf[2:10])
+
+	params, err := PQParameters(pub.SignatureAlgorithm)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if pub.PublicKey, err = mldsa.NewPublicKey(params, buf[10:]); err != nil {
+		return nil, fmt.Errorf("invalid %s public key", params)
+	}
+
+	return
+}
+
+// isPQKey returns whether the argument string is an encoded post-quantum
+// public key, rather than an X.509 root certificate.
+func isPQKey(s string) bool {
+	buf, err := base64.StdEncoding.DecodeString(s)
+
+	if err != nil || len(buf) < 2 {
+		return false
+	}
+
+	_, err = PQParameters([2]byte{buf[0], buf[1]})
+
+	return err == nil
+}
+
+// PQMessage returns the message authenticated by post-quantum signatures, it
+// consists of the BLAKE2b-512 digest of the input followed by the minisign
+// trusted comment (without the `trusted comment: ` prefix), if any.
+func PQMessage(buf []byte, trustedComment string) []byte {
+	h := blake2b.Sum512(buf)
+	return append(h[:], strings.TrimPrefix(trustedComment, "trusted comment: ")...)
+}
+
+// splitPQSignature separates a signify/minisign signature from the
+// post-quantum signatures appended to it, one per line, each consisting of
+// the base64 encoding of its signature algorithm identifier, key identifier
+// and signature.
+func splitPQSignature(sig []byte) (s string, pq []string) {
+	lines := strings.Split(strings.TrimRight(string(sig), "\r\n"), "\n")
+	n := 2
+
+	if len(lines) > 2 && strings.HasPrefix(lines[2], "trusted comment: ") {
+		n = 4
+	}
+
+	if len(lines) <= n {
+		return string(sig), nil
+	}
+
+	for _, line := range lines[n:] {
+		if line = strings.TrimSpace(line); len(line) > 0 {
+			pq = append(pq, line)
+		}
+	}
+
+	return strings.Join(lines[:n], "\n") + "\n", pq
+}
+
+// verifyPQ authenticates an input, along with the trusted comment of its
+// signify/minisign signature, against any of the post-quantum signatures
+// made with a trusted post-quantum key.
+func (keys *Keys) verifyPQ(buf []byte, s Signature, pq []string) (err error) {
+	if len(pq) == 0 {
+		return errors.New("invalid signature, missing post-quantum signature")
+	}
+
+	msg := PQMessage(buf, s.TrustedComment)
+	opts := &mldsa.Options{Context: PQContext}
+
+	for _, line := range pq {
+		var alg [2]byte
+		var id [8]byte
+
+		sig, err := base64.StdEncoding.DecodeString(line)
+
+		if err != nil || len(sig) < 10 {
+			continue
+		}
+
+		copy(alg[:], sig[0:2])
+		copy(id[:], sig[2:10])
+
+		pub, ok := keys.pq[id]
+
+		if !ok || pub.SignatureAlgorithm != alg {
+			continue
+		}
+
+		if mldsa.Verify(pub.PublicKey, msg, sig[10:], opts) == nil {
+			return nil
+		}
+	}
+
+	return errors.New("invalid signature, post-quantum verification failure")
+}
@@ -0,0 +1,199 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package config
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	"filippo.io/mldsa"
+	"golang.org/x/crypto/blake2b"
+)
+
+// testMinisign represents a test signify/minisign key pair.
+type testMinisign struct {
+	id  [8]byte
+	key ed25519.PrivateKey
+}
+
+func newMinisign(t *testing.T) (k *testMinisign) {
+	k = &testMinisign{}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rand.Read(k.id[:])
+	k.key = key
+
+	return
+}
+
+// String returns the encoded public key.
+func (k *testMinisign) String() string {
+	buf := append([]byte("Ed"), k.id[:]...)
+	buf = append(buf, k.key.Public().(ed25519.PublicKey)...)
+
+	return base64.StdEncoding.EncodeToString(buf)
+}
+
+// sign returns a prehashed minisign signature of the argument input with the
+// argument trusted comment.
+func (k *testMinisign) sign(buf []byte, comment string) string {
+	h := blake2b.Sum512(buf)
+	sig := ed25519.Sign(k.key, h[:])
+	global := ed25519.Sign(k.key, append(sig, comment...))
+
+	bin := append([]byte("ED"), k.id[:]...)
+	bin = append(bin, sig...)
+
+	return fmt.Sprintf("untrusted comment: test\n%s\ntrusted comment: %s\n%s\n",
+		base64.StdEncoding.EncodeToString(bin), comment, base64.StdEncoding.EncodeToString(global))
+}
+
+// testPQ represents a test post-quantum key pair.
+type testPQ struct {
+	alg [2]byte
+	id  [8]byte
+	key *mldsa.PrivateKey
+}
+
+func newPQ(t *testing.T) (k *testPQ) {
+	k = &testPQ{alg: MLDSA65}
+
+	key, err := mldsa.GenerateKey(mldsa.MLDSA65())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rand.Read(k.id[:])
+	k.key = key
+
+	return
+}
+
+// String returns the encoded public key.
+func (k *testPQ) String() string {
+	buf := append(k.alg[:], k.id[:]...)
+	return base64.StdEncoding.EncodeToString(append(buf, k.key.PublicKey().Bytes()...))
+}
+
+// sign returns a post-quantum signature line of the argument input with the
+// argument trusted comment.
+func (k *testPQ) sign(t *testing.T, buf []byte, comment string) string {
+	sig, err := k.key.Sign(nil, PQMessage(buf, comment), &mldsa.Options{Context: PQContext})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bin := append(k.alg[:], k.id[:]...)
+
+	return base64.StdEncoding.EncodeToString(append(bin, sig...)) + "\n"
+}
+
+func TestVerifyPQ(t *testing.T) {
+	ed := newMinisign(t)
+	pq := newPQ(t)
+	other := newPQ(t)
+
+	// same key identifier, different parameter set
+	alg := *pq
+	alg.alg = MLDSA44
+
+	buf := []byte("armory-boot configuration")
+	comment := "timestamp:1760000000 file:armory-boot.conf"
+
+	for _, tt := range []struct {
+		name string
+		sig  string
+		err  string
+	}{
+		{
+			name: "valid",
+			sig:  ed.sign(buf, comment) + pq.sign(t, buf, comment),
+		},
+		{
+			name: "valid with untrusted signatures",
+			sig:  ed.sign(buf, comment) + other.sign(t, buf, comment) + "\n" + pq.sign(t, buf, comment),
+		},
+		{
+			name: "missing ML-DSA line",
+			sig:  ed.sign(buf, comment),
+			err:  "missing post-quantum signature",
+		},
+		{
+			name: "blank ML-DSA line",
+			sig:  ed.sign(buf, comment) + "\n \n",
+			err:  "missing post-quantum signature",
+		},
+		{
+			name: "mismatching input",
+			sig:  ed.sign(buf, comment) + pq.sign(t, []byte("tampered"), comment),
+			err:  "post-quantum verification failure",
+		},
+		{
+			name: "mismatching trusted comment",
+			sig:  ed.sign(buf, comment) + pq.sign(t, buf, "timestamp:1760000000 file:other.conf"),
+			err:  "post-quantum verification failure",
+		},
+		{
+			name: "untrusted key",
+			sig:  ed.sign(buf, comment) + other.sign(t, buf, comment),
+			err:  "post-quantum verification failure",
+		},
+		{
+			name: "algorithm mismatch",
+			sig:  ed.sign(buf, comment) + alg.sign(t, buf, comment),
+			err:  "post-quantum verification failure",
+		},
+		{
+			name: "truncated ML-DSA line",
+			sig:  ed.sign(buf, comment) + pq.sign(t, buf, comment)[:64] + "\n",
+			err:  "post-quantum verification failure",
+		},
+		{
+			name: "invalid ML-DSA line",
+			sig:  ed.sign(buf, comment) + "not base64\n",
+			err:  "post-quantum verification failure",
+		},
+		{
+			name: "non-hybrid signature",
+			sig:  sshsigBegin + "\n",
+			err:  "post-quantum signature required",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := NewKeys(ed.String() + "\n" + pq.String())
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			m, err := keys.VerifyMetadata(buf, []byte(tt.sig))
+
+			switch {
+			case len(tt.err) == 0 && err != nil:
+				t.Fatalf("unexpected error, %v", err)
+			case len(tt.err) > 0 && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Fatalf("expected error %q, got %v", tt.err, err)
+			case err == nil && m.File != "armory-boot.conf":
+				t.Fatalf("invalid metadata %+v", m)
+			}
+		})
+	}
+
+	if _, err := NewKeys(pq.String()); err == nil || !strings.Contains(err.Error(), "require signify/minisign keys") {
+		t.Fatalf("unexpected error, %v", err)
+	}
+}
@@ -0,0 +1,295 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultRevocationPath is the default armory-boot key revocation list path.
+const DefaultRevocationPath = "/boot/armory-boot.rev"
+
+// DefaultRevocationSignaturePath is the default armory-boot key revocation
+// list signature path.
+const DefaultRevocationSignaturePath = "/boot/armory-boot.rev.sig"
+
+// Key types, used as key revocation list identifier prefixes (see
+// ParseRevocations()).
+const (
+	KeyMinisign = "minisign"
+	KeyOpenPGP  = "pgp"
+	KeySSH      = "ssh"
+	KeyX509     = "x509"
+)
+
+// Revocation represents a key revocation list entry.
+type Revocation struct {
+	// Type is the key type (e.g. KeyMinisign).
+	Type string
+	// ID is the key identifier, signify/minisign and post-quantum keys
+	// are identified by their key identifier, OpenPGP keys by their
+	// primary key fingerprint, OpenSSH keys and X.509 certificates by
+	// their SHA-256 fingerprint.
+	ID []byte
+	// Expiry is the time from which the key is retired, keys without
+	// expiry are retired immediately.
+	Expiry time.Time
+}
+
+// RevocationList represents a key revocation list.
+type RevocationList struct {
+	// Sequence is the revocation list sequence number, which must never
+	// decrease across list updates.
+	Sequence uint64
+	// Entries are the retired keys.
+	Entries []Revocation
+}
+
+// parseRevocation parses a key revocation list entry.
+func parseRevocation(line string) (r Revocation, err error) {
+	f := strings.Fields(line)
+
+	if len(f) > 2 {
+		return r, fmt.Errorf("invalid entry %q", line)
+	}
+
+	if len(f) == 2 {
+		if r.Expiry, err = parseTime(f[1]); err != nil {
+			return r, fmt.Errorf("invalid expiry %q", f[1])
+		}
+	}
+
+	typ, id, found := strings.Cut(f[0], ":")
+
+	if !found {
+		kid, err := ParseKeyID(f[0])
+
+		if err != nil {
+			return r, err
+		}
+
+		r.Type = KeyMinisign
+		r.ID = kid[:]
+
+		return r, nil
+	}
+
+	r.Type = typ
+
+	switch typ {
+	case KeyOpenPGP:
+		if r.ID, err = hex.DecodeString(id); err != nil || len(r.ID) != 20 {
+			return r, fmt.Errorf("invalid OpenPGP fingerprint %q", id)
+		}
+	case KeySSH:
+		s, _ := strings.CutPrefix(id, "SHA256:")
+
+		if r.ID, err = base64.RawStdEncoding.DecodeString(s); err != nil || len(r.ID) != sha256.Size {
+			return r, fmt.Errorf("invalid OpenSSH fingerprint %q", id)
+		}
+	case KeyX509:
+		if r.ID, err = hex.DecodeString(strings.ReplaceAll(id, ":", "")); err != nil || len(r.ID) != sha256.Size {
+			return r, fmt.Errorf("invalid X.509 fingerprint %q", id)
+		}
+	default:
+		return r, fmt.Errorf("invalid key type %q", typ)
+	}
+
+	return
+}
+
+// ParseRevocations parses a key revocation list, consisting of a sequence
+// number line (e.g. `sequence 3`) followed by one key identifier per line,
+// empty lines and lines starting with '#' are ignored. Lists without sequence
+// number line have sequence number 0.
+//
+// Signify/minisign and post-quantum keys are listed by their key identifier
+// (see KeyID()), OpenPGP keys by `pgp:` followed by their hexadecimal primary
+// key fingerprint, OpenSSH keys by `ssh:` followed by their SHA-256
+// fingerprint (as displayed by `ssh-keygen -l`) and X.509 certificates by
+// `x509:` followed by their hexadecimal SHA-256 fingerprint.
+//
+// Each key identifier can be followed by an expiry time (Unix time or
+// RFC3339), in which case the key is only retired from that time.
+func ParseRevocations(buf []byte) (list *RevocationList, err error) {
+	var sequence bool
+
+	list = &RevocationList{}
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if s, ok := strings.CutPrefix(line, "sequence "); ok {
+			if sequence || len(list.Entries) > 0 {
+				return nil, errors.New("misplaced sequence number")
+			}
+
+			if list.Sequence, err = strconv.ParseUint(strings.TrimSpace(s), 10, 64); err != nil {
+				return nil, fmt.Errorf("invalid sequence number %q", s)
+			}
+
+			sequence = true
+
+			continue
+		}
+
+		r, err := parseRevocation(line)
+
+		if err != nil {
+			return nil, err
+		}
+
+		list.Entries = append(list.Entries, r)
+	}
+
+	return list, scanner.Err()
+}
+
+// SetRevocationPolicy sets the minimum acceptable key revocation list
+// sequence number, a revocation list must be present when non-zero, and the
+// time against which the expiry of listed keys is enforced. Listed keys with
+// an expiry are retained when the time is zero.
+func (keys *Keys) SetRevocationPolicy(minSequence uint64, t time.Time) {
+	keys.minSequence = minSequence
+	keys.now = t
+}
+
+// RevocationSequence returns the sequence number of the key revocation list
+// applied by LoadKeys(), which is zero when no list is present.
+func (keys *Keys) RevocationSequence() uint64 {
+	return keys.sequence
+}
+
+// Revoke authenticates a key revocation list against its signature, which
+// must be generated by any of the trusted keys (or by enough of them in
+// threshold mode), and removes all listed keys from the set.
+//
+// Lists with a sequence number lower than the minimum one (see
+// SetRevocationPolicy()), or whose signature is no longer valid once the
+// listed keys are removed, are refused.
+func (keys *Keys) Revoke(buf []byte, sig []byte) (err error) {
+	return keys.revoke(buf, [][]byte{sig})
+}
+
+func (keys *Keys) revoke(buf []byte, sigs [][]byte) (err error) {
+	if _, err = keys.verifySignatures(buf, sigs); err != nil {
+		return fmt.Errorf("invalid revocation list, %v", err)
+	}
+
+	list, err := ParseRevocations(buf)
+
+	if err != nil {
+		return fmt.Errorf("invalid revocation list, %v", err)
+	}
+
+	if list.Sequence < keys.minSequence {
+		return fmt.Errorf("invalid revocation list, sequence %d is lower than minimum (%d)", list.Sequence, keys.minSequence)
+	}
+
+	for _, r := range list.Entries {
+		if !r.Expiry.IsZero() && (keys.now.IsZero() || keys.now.Before(r.Expiry)) {
+			continue
+		}
+
+		keys.retire(r)
+	}
+
+	// a list cannot be authenticated by the keys it retires
+	if _, err = keys.verifySignatures(buf, sigs); err != nil {
+		return fmt.Errorf("invalid revocation list, signed by retired key (%v)", err)
+	}
+
+	keys.sequence = list.Sequence
+
+	return
+}
+
+// retire removes a key from the set.
+func (keys *Keys) retire(r Revocation) {
+	switch r.Type {
+	case KeyMinisign:
+		var id [8]byte
+		copy(id[:], r.ID)
+
+		delete(keys.minisign, id)
+		delete(keys.pq, id)
+	case KeyOpenPGP:
+		for id, key := range keys.pgp {
+			if key.primary == string(r.ID) {
+				delete(keys.pgp, id)
+			}
+		}
+	case KeySSH:
+		for blob := range keys.ssh {
+			if sum := sha256.Sum256([]byte(blob)); bytes.Equal(sum[:], r.ID) {
+				delete(keys.ssh, blob)
+			}
+		}
+	case KeyX509:
+		var fp [32]byte
+		copy(fp[:], r.ID)
+
+		keys.retired[fp] = true
+
+		var roots []*x509.Certificate
+
+		for _, root := range keys.roots {
+			if !keys.retired[sha256.Sum256(root.Raw)] {
+				roots = append(roots, root)
+			}
+		}
+
+		keys.roots = roots
+	}
+}
+
+// loadRevocations applies the key revocation list, when present, to the
+// argument keys, along with the X.509 certificate revocation list when root
+// certificates are trusted. The key revocation list is required when a
+// minimum sequence number is set (see SetRevocationPolicy()).
+func loadRevocations(part Partition, keys *Keys) (err error) {
+	if len(keys.roots) > 0 {
+		if err = keys.loadCRL(part); err != nil {
+			return
+		}
+	}
+
+	buf, err := part.ReadAll(DefaultRevocationPath)
+
+	if errors.Is(err, fs.ErrNotExist) {
+		if keys.minSequence > 0 {
+			return fmt.Errorf("missing revocation list, sequence %d required", keys.minSequence)
+		}
+
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("invalid revocation list path, %v", err)
+	}
+
+	sigs, err := keys.readSignatures(part, DefaultRevocationSignaturePath)
+
+	if err != nil {
+		return fmt.Errorf("invalid revocation list signature path, %v", err)
+	}
+
+	return keys.revoke(buf, sigs)
+}
@@ -0,0 +1,455 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package config
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+)
+
+// SSHNamespace is the namespace of OpenSSH signatures (e.g. `ssh-keygen -Y
+// sign -n armory-boot`) accepted for verification.
+const SSHNamespace = "armory-boot"
+
+// OpenSSH key types
+const (
+	sshEd25519   = "ssh-ed25519"
+	sshECDSAP256 = "ecdsa-sha2-nistp256"
+)
+
+const (
+	sshsigMagic   = "SSHSIG"
+	sshsigVersion = 1
+	sshsigBegin   = "-----BEGIN SSH SIGNATURE-----"
+	sshsigEnd     = "-----END SSH SIGNATURE-----"
+)
+
+// sshSignature represents a parsed OpenSSH signature
+// (https://github.com/openssh/openssh-portable/blob/master/PROTOCOL.sshsig).
+type sshSignature struct {
+	PublicKey     []byte
+	Namespace     string
+	Reserved      []byte
+	HashAlgorithm string
+	Signature     []byte
+}
+
+func readString(buf []byte) (s []byte, rest []byte, err error) {
+	if len(buf) < 4 {
+		return nil, nil, errors.New("short buffer")
+	}
+
+	n := binary.BigEndian.Uint32(buf)
+
+	if uint64(len(buf)-4) < uint64(n) {
+		return nil, nil, errors.New("short buffer")
+	}
+
+	return buf[4 : 4+n], buf[4+n:], nil
+}
+
+func readStrings(buf []byte, n int) (s [][]byte, err error) {
+	for i := 0; i < n; i++ {
+		var f []byte
+
+		if f, buf, err = readString(buf); err != nil {
+			return nil, err
+		}
+
+		s = append(s, f)
+	}
+
+	if len(buf) > 0 {
+		return nil, errors.New("trailing data")
+	}
+
+	return
+}
+
+func appendString(buf []byte, s []byte) []byte {
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(s)))
+	return append(buf, s...)
+}
+
+func isSSHKeyType(s string) bool {
+	return strings.HasPrefix(s, "ssh-") || strings.HasPrefix(s, "ecdsa-") || strings.HasPrefix(s, "sk-")
+}
+
+func isSSHKey(s string) bool {
+	return slices.ContainsFunc(strings.Fields(s), isSSHKeyType)
+}
+
+func isSSHSignature(sig []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(sig), []byte(sshsigBegin))
+}
+
+// parseSSHPublicKey parses an OpenSSH public key in wire format.
+func parseSSHPublicKey(blob []byte) (pub crypto.PublicKey, err error) {
+	t, rest, err := readString(blob)
+
+	if err != nil {
+		return
+	}
+
+	switch string(t) {
+	case sshEd25519:
+		f, err := readStrings(rest, 1)
+
+		if err != nil || len(f[0]) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid ed25519 key")
+		}
+
+		return ed25519.PublicKey(f[0]), nil
+	case sshECDSAP256:
+		f, err := readStrings(rest, 2)
+
+		if err != nil || string(f[0]) != "nistp256" {
+			return nil, errors.New("invalid ecdsa key")
+		}
+
+		x, y := elliptic.Unmarshal(elliptic.P256(), f[1])
+
+		if x == nil {
+			return nil, errors.New("invalid ecdsa key")
+		}
+
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %q", t)
+}
+
+// authorized_keys options without value
+var sshFlags = []string{
+	"agent-forwarding",
+	"cert-authority",
+	"no-agent-forwarding",
+	"no-port-forwarding",
+	"no-pty",
+	"no-touch-required",
+	"no-user-rc",
+	"no-x11-forwarding",
+	"port-forwarding",
+	"pty",
+	"restrict",
+	"user-rc",
+	"verify-required",
+	"x11-forwarding",
+}
+
+// authorized_keys options with a quoted value
+var sshValueOptions = []string{
+	"command",
+	"environment",
+	"expiry-time",
+	"from",
+	"permitlisten",
+	"permitopen",
+	"principals",
+	"tunnel",
+}
+
+func hasOption(options []string, name string) bool {
+	return slices.ContainsFunc(options, func(o string) bool {
+		return strings.EqualFold(o, name)
+	})
+}
+
+// skipSSHOptions returns an `authorized_keys` format line without its leading
+// comma separated options, which must be either flags or `name="value"` pairs.
+func skipSSHOptions(line string) (rest string, err error) {
+	line = strings.TrimSpace(line)
+
+	if f := strings.Fields(line); len(f) == 0 || isSSHKeyType(f[0]) {
+		return line, nil
+	}
+
+	for {
+		i := strings.IndexAny(line, "=, \t")
+
+		if i <= 0 {
+			return "", errors.New("invalid SSH public key options")
+		}
+
+		name := line[:i]
+
+		switch {
+		case line[i] == '=' && hasOption(sshValueOptions, name):
+			if i+1 == len(line) || line[i+1] != '"' {
+				return "", fmt.Errorf("invalid SSH public key option %q", name)
+			}
+
+			j := i + 2
+
+			for ; j < len(line) && line[j] != '"'; j++ {
+				if line[j] == '\\' {
+					j++
+				}
+			}
+
+			if j >= len(line) {
+				return "", fmt.Errorf("invalid SSH public key option %q", name)
+			}
+
+			line = line[j+1:]
+		case line[i] != '=' && hasOption(sshFlags, name):
+			line = line[i:]
+		default:
+			return "", fmt.Errorf("invalid SSH public key option %q", name)
+		}
+
+		switch {
+		case len(line) == 0:
+			return "", errors.New("invalid SSH public key")
+		case line[0] == ',':
+			line = line[1:]
+		case line[0] == ' ' || line[0] == '\t':
+			return strings.TrimSpace(line), nil
+		default:
+			return "", errors.New("invalid SSH public key options")
+		}
+	}
+}
+
+// sshOptionsSize returns the size of the leading `authorized_keys` options of
+// the argument string, including the following whitespace, when followed by an
+// OpenSSH key type.
+func sshOptionsSize(s string) int {
+	var quoted bool
+
+	n := len(s) - len(strings.TrimLeft(s, " \t"))
+
+	for i := n; i < len(s); i++ {
+		switch {
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == '\\' && quoted:
+			i++
+		case s[i] == '\n':
+			return 0
+		case (s[i] == ' ' || s[i] == '\t') && !quoted:
+			if f := strings.Fields(s[n:i]); len(f) == 0 || isSSHKeyType(f[0]) {
+				return 0
+			}
+
+			rest := strings.TrimLeft(s[i:], " \t")
+
+			if !isSSHKeyType(rest) {
+				return 0
+			}
+
+			return len(s) - len(rest)
+		}
+	}
+
+	return 0
+}
+
+// splitKeys splits a comma or newline separated list of trusted keys, commas
+// within the leading options of `authorized_keys` format OpenSSH keys are not
+// considered separators.
+func splitKeys(s string) (keys []string) {
+	for len(s) > 0 {
+		n := sshOptionsSize(s)
+		i := strings.IndexAny(s[n:], ",\n")
+
+		if i < 0 {
+			i = len(s) - n
+		}
+
+		if k := s[:n+i]; len(strings.TrimSpace(k)) > 0 {
+			keys = append(keys, k)
+		}
+
+		s = s[min(n+i+1, len(s)):]
+	}
+
+	return
+}
+
+// addSSH parses an `authorized_keys` format line, options (see
+// skipSSHOptions()) and comments are ignored.
+func (keys *Keys) addSSH(line string) (err error) {
+	line, err = skipSSHOptions(line)
+
+	if err != nil {
+		return
+	}
+
+	f := strings.Fields(line)
+
+	if len(f) < 2 || !isSSHKeyType(f[0]) {
+		return errors.New("invalid SSH public key")
+	}
+
+	blob, err := base64.StdEncoding.DecodeString(f[1])
+
+	if err != nil {
+		return errors.New("invalid encoded SSH public key")
+	}
+
+	pub, err := parseSSHPublicKey(blob)
+
+	if err != nil {
+		return fmt.Errorf("invalid SSH public key, %v", err)
+	}
+
+	if t, _, _ := readString(blob); string(t) != f[0] {
+		return errors.New("SSH public key type mismatch")
+	}
+
+	keys.ssh[string(blob)] = pub
+
+	return
+}
+
+// decodeSSHSignature parses an armored OpenSSH signature.
+func decodeSSHSignature(in []byte) (s *sshSignature, err error) {
+	in = bytes.TrimSpace(in)
+
+	if !bytes.HasPrefix(in, []byte(sshsigBegin)) || !bytes.HasSuffix(in, []byte(sshsigEnd)) {
+		return nil, errors.New("invalid armor")
+	}
+
+	b64 := strings.Join(strings.Fields(string(in[len(sshsigBegin):len(in)-len(sshsigEnd)])), "")
+	buf, err := base64.StdEncoding.DecodeString(b64)
+
+	if err != nil {
+		return nil, errors.New("invalid encoding")
+	}
+
+	if len(buf) < len(sshsigMagic)+4 || string(buf[0:len(sshsigMagic)]) != sshsigMagic {
+		return nil, errors.New("invalid magic")
+	}
+
+	buf = buf[len(sshsigMagic):]
+
+	if v := binary.BigEndian.Uint32(buf); v != sshsigVersion {
+		return nil, fmt.Errorf("unsupported version %d", v)
+	}
+
+	f, err := readStrings(buf[4:], 5)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid format, %v", err)
+	}
+
+	s = &sshSignature{
+		PublicKey:     f[0],
+		Namespace:     string(f[1]),
+		Reserved:      f[2],
+		HashAlgorithm: string(f[3]),
+		Signature:     f[4],
+	}
+
+	return
+}
+
+// message returns the signed data for the argument input.
+func (s *sshSignature) message(buf []byte) (msg []byte, err error) {
+	var h []byte
+
+	switch s.HashAlgorithm {
+	case "sha256":
+		sum := sha256.Sum256(buf)
+		h = sum[:]
+	case "sha512":
+		sum := sha512.Sum512(buf)
+		h = sum[:]
+	default:
+		return nil, fmt.Errorf("unsupported hash algorithm %q", s.HashAlgorithm)
+	}
+
+	msg = []byte(sshsigMagic)
+	msg = appendString(msg, []byte(s.Namespace))
+	msg = appendString(msg, s.Reserved)
+	msg = appendString(msg, []byte(s.HashAlgorithm))
+	msg = appendString(msg, h)
+
+	return
+}
+
+// verifySSH authenticates an input against an armored OpenSSH signature
+// (`ssh-keygen -Y sign`), generated in the SSHNamespace namespace with any of
+// the trusted SSH keys, the signing key wire format encoding is returned.
+func (keys *Keys) verifySSH(buf []byte, sig []byte) (id string, err error) {
+	s, err := decodeSSHSignature(sig)
+
+	if err != nil {
+		return "", fmt.Errorf("invalid signature, %v", err)
+	}
+
+	if s.Namespace != SSHNamespace {
+		return "", fmt.Errorf("invalid signature, namespace mismatch (%s)", s.Namespace)
+	}
+
+	pub, ok := keys.ssh[string(s.PublicKey)]
+
+	if !ok {
+		return "", errors.New("invalid signature, untrusted key")
+	}
+
+	msg, err := s.message(buf)
+
+	if err != nil {
+		return "", fmt.Errorf("invalid signature, %v", err)
+	}
+
+	f, err := readStrings(s.Signature, 2)
+
+	if err != nil {
+		return "", errors.New("invalid signature, invalid format")
+	}
+
+	id = "ssh:" + string(s.PublicKey)
+
+	switch k := pub.(type) {
+	case ed25519.PublicKey:
+		if string(f[0]) == sshEd25519 && ed25519.Verify(k, msg, f[1]) {
+			return id, nil
+		}
+	case *ecdsa.PublicKey:
+		if string(f[0]) != sshECDSAP256 {
+			break
+		}
+
+		rs, err := readStrings(f[1], 2)
+
+		if err != nil {
+			break
+		}
+
+		der, err := asn1.Marshal(struct{ R, S *big.Int }{
+			new(big.Int).SetBytes(rs[0]),
+			new(big.Int).SetBytes(rs[1]),
+		})
+
+		if err != nil {
+			break
+		}
+
+		hash := sha256.Sum256(msg)
+
+		if verifyECDSA(k, hash[:], der) {
+			return id, nil
+		}
+	}
+
+	return "", errors.New("invalid signature")
+}
@@ -0,0 +1,111 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package config
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestNewKeysSSH(t *testing.T) {
+	pub, _, _ := ed25519.GenerateKey(rand.Reader)
+	blob := appendString(appendString(nil, []byte(sshEd25519)), pub)
+	ssh := sshEd25519 + " " + base64.StdEncoding.EncodeToString(blob)
+
+	minisign := base64.StdEncoding.EncodeToString(append([]byte("Ed01234567"), pub...))
+
+	for _, tt := range []struct {
+		name     string
+		keys     string
+		ssh      int
+		minisign int
+		err      string
+	}{
+		{
+			name: "key",
+			keys: ssh,
+			ssh:  1,
+		},
+		{
+			name: "key with comment",
+			keys: ssh + " user@host",
+			ssh:  1,
+		},
+		{
+			name: "comment with comma",
+			keys: ssh + " user@host, with comma",
+			err:  "Invalid encoded public key",
+		},
+		{
+			name: "key with flags",
+			keys: "no-pty,no-port-forwarding " + ssh + " user@host",
+			ssh:  1,
+		},
+		{
+			name: "key with quoted options",
+			keys: `command="echo a, b \"c d\"",from="10.0.0.1",restrict ` + ssh,
+			ssh:  1,
+		},
+		{
+			name:     "comma separated keys",
+			keys:     minisign + "," + ssh + " user@host," + minisign,
+			ssh:      1,
+			minisign: 1,
+		},
+		{
+			name:     "newline separated keys",
+			keys:     "no-pty " + ssh + "\n" + minisign,
+			ssh:      1,
+			minisign: 1,
+		},
+		{
+			name: "whitespace separated keys",
+			keys: minisign + " " + ssh,
+			err:  "invalid SSH public key option",
+		},
+		{
+			name: "unknown flag",
+			keys: "no-such-flag " + ssh,
+			err:  `invalid SSH public key option "no-such-flag"`,
+		},
+		{
+			name: "unknown option",
+			keys: `foo="bar" ` + ssh,
+			err:  `invalid SSH public key option "foo"`,
+		},
+		{
+			name: "unquoted option value",
+			keys: "from=10.0.0.1 " + ssh,
+			err:  `invalid SSH public key option "from"`,
+		},
+		{
+			name: "unterminated option value",
+			keys: `command="echo ` + ssh,
+			err:  "invalid SSH public key",
+		},
+		{
+			name: "type mismatch",
+			keys: "ssh-rsa " + base64.StdEncoding.EncodeToString(blob),
+			err:  "SSH public key type mismatch",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := NewKeys(tt.keys)
+
+			switch {
+			case len(tt.err) == 0 && err != nil:
+				t.Fatalf("unexpected error, %v", err)
+			case len(tt.err) > 0 && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Fatalf("expected error %q, got %v", tt.err, err)
+			case err == nil && (len(keys.ssh) != tt.ssh || len(keys.minisign) != tt.minisign):
+				t.Fatalf("unexpected keys (ssh:%d minisign:%d)", len(keys.ssh), len(keys.minisign))
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Tamper policies (see Config.Tamper)
+const (
+	// TamperRefuse refuses boot when a violation is detected.
+	TamperRefuse = "refuse"
+	// TamperRestricted boots the restricted kernel parameters (see
+	// Config.Restricted) when a violation is detected.
+	TamperRestricted = "restricted"
+)
+
+// SNVS tamper monitors (see Config.Monitors)
+const (
+	MonitorClock       = "clock"
+	MonitorTemperature = "temperature"
+	MonitorVoltage     = "voltage"
+)
+
+// RestrictedEntry is the slot name which, when passed to LoadSlot() or
+// LoadKeys(), selects the restricted kernel parameters in place of top-level
+// or slot ones, to be used when a violation is detected.
+const RestrictedEntry = "restricted"
+
+// ErrTamper is returned when the restricted entry is selected and the
+// configuration tamper policy refuses boot.
+var ErrTamper = errors.New("boot refused by tamper policy")
+
+func (c *Config) validateMonitors() (err error) {
+	seen := make(map[string]bool)
+
+	for _, m := range c.Monitors {
+		switch m {
+		case MonitorClock, MonitorTemperature, MonitorVoltage:
+		default:
+			return &FieldError{Field: "monitors", Err: ErrInvalidValue, Detail: fmt.Sprintf("unsupported monitor %q", m)}
+		}
+
+		if seen[m] {
+			return &FieldError{Field: "monitors", Err: ErrInvalidValue, Detail: fmt.Sprintf("duplicate monitor %q", m)}
+		}
+
+		seen[m] = true
+	}
+
+	return
+}
+
+func (c *Config) validateTamper(version int) (err error) {
+	if err = c.validateMonitors(); err != nil {
+		return
+	}
+
+	switch c.Tamper {
+	case "", TamperRefuse:
+		if c.Restricted != nil {
+			return &FieldError{Field: "restricted", Err: ErrConflict, Detail: "requires restricted tamper policy"}
+		}
+	case TamperRestricted:
+		if c.Restricted == nil {
+			return &FieldError{Field: "restricted", Err: ErrMissing}
+		}
+
+		return c.Restricted.validateImages("restricted.", version, c.Detached)
+	default:
+		return &FieldError{Field: "tamper", Err: ErrInvalidValue, Detail: "unsupported policy"}
+	}
+
+	return
+}
+
+func (c *Config) selectRestricted() (err error) {
+	if c.Tamper != TamperRestricted {
+		return ErrTamper
+	}
+
+	r := c.Restricted
+
+	c.KernelImage = r.KernelImage
+	c.DeviceTreeBlobImage = r.DeviceTreeBlobImage
+	c.InitialRamDiskImage = r.InitialRamDiskImage
+	c.DeviceTreeOverlayImages = r.DeviceTreeOverlayImages
+	c.CmdLine = r.CmdLine
+	c.UnikernelImage = r.UnikernelImage
+	c.Env = r.Env
+	c.Slot = RestrictedEntry
+
+	return
+}
@@ -0,0 +1,206 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+// maxSignatures is the maximum number of numbered signature files read in
+// threshold mode.
+const maxSignatures = 64
+
+// signatureMarkers are the lines starting each signature within a bundle.
+var signatureMarkers = [][]byte{
+	[]byte("untrusted comment: "),
+	[]byte(sshsigBegin),
+	[]byte(pgpArmorBegin),
+	[]byte("-----BEGIN " + pemSignature + "-----"),
+	[]byte("-----BEGIN " + pemCMS + "-----"),
+	[]byte("-----BEGIN " + pemPKCS7 + "-----"),
+}
+
+// SetThreshold sets the minimum number of distinct trusted keys which must
+// sign each authenticated file, signatures are then read from bundles and
+// numbered signature files (see readSignatures()).
+//
+// Signify/minisign keys are identified by their key identifier, OpenSSH keys
+// by their public key, OpenPGP keys by their primary key and X.509 signatures
+// by their trusted root certificate.
+func (keys *Keys) SetThreshold(k int) (err error) {
+	n := len(keys.minisign) + len(keys.ssh) + keys.pgpPrimaryKeys() + len(keys.roots)
+
+	if k < 1 || k > n {
+		return fmt.Errorf("invalid signature threshold (%d-of-%d)", k, n)
+	}
+
+	keys.threshold = k
+
+	return
+}
+
+// readSignatures reads the signature at the argument path and, in threshold
+// mode, all numbered signatures (e.g. `armory-boot.conf.sig.1`,
+// `armory-boot.conf.sig.2`) following it, in which case the signature at the
+// argument path is optional.
+func (keys *Keys) readSignatures(part Partition, path string) (sigs [][]byte, err error) {
+	sig, err := part.ReadAll(path)
+
+	if keys.threshold <= 1 {
+		if err != nil {
+			return
+		}
+
+		return [][]byte{sig}, nil
+	}
+
+	if err == nil {
+		sigs = append(sigs, sig)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+
+	for i := 1; i <= maxSignatures; i++ {
+		sig, err = part.ReadAll(fmt.Sprintf("%s.%d", path, i))
+
+		if errors.Is(err, fs.ErrNotExist) {
+			break
+		} else if err != nil {
+			return
+		}
+
+		sigs = append(sigs, sig)
+	}
+
+	if len(sigs) == 0 {
+		return nil, fmt.Errorf("missing signatures, %w", fs.ErrNotExist)
+	}
+
+	return sigs, nil
+}
+
+// splitSignatures splits a signature bundle, consisting of concatenated
+// signify/minisign, OpenSSH, armored OpenPGP and PEM encoded X.509
+// signatures, in its individual signatures. Binary OpenPGP and DER encoded
+// X.509 signatures cannot be bundled.
+func splitSignatures(bundle []byte) (sigs [][]byte) {
+	var sig []byte
+
+	if len(bundle) > 0 && (bundle[0] == 0x30 || bundle[0]&0x80 != 0) {
+		return [][]byte{bundle}
+	}
+
+	for _, line := range bytes.SplitAfter(bundle, []byte("\n")) {
+		for _, marker := range signatureMarkers {
+			if bytes.HasPrefix(line, marker) && len(bytes.TrimSpace(sig)) > 0 {
+				sigs = append(sigs, sig)
+				sig = nil
+				break
+			}
+		}
+
+		sig = append(sig, line...)
+	}
+
+	if len(bytes.TrimSpace(sig)) > 0 {
+		sigs = append(sigs, sig)
+	}
+
+	return
+}
+
+// mergeMetadata combines the metadata of threshold signatures, so that the
+// policy of each signature is enforced: the latest timestamp and earliest
+// expiry are retained, while conflicting trusted comment fields are rejected.
+func mergeMetadata(metadata []*Metadata) (m *Metadata, err error) {
+	for _, s := range metadata {
+		if s == nil {
+			continue
+		}
+
+		if m == nil {
+			m = &Metadata{
+				Fields: make(map[string]string),
+			}
+		}
+
+		for k, v := range s.Fields {
+			if k == MetadataTimestamp || k == MetadataExpiry {
+				continue
+			}
+
+			if prev, ok := m.Fields[k]; ok && prev != v {
+				return nil, fmt.Errorf("conflicting trusted comment field %s", k)
+			}
+
+			m.Fields[k] = v
+		}
+
+		if s.Timestamp.After(m.Timestamp) {
+			m.Timestamp = s.Timestamp
+
+			if v, ok := s.Fields[MetadataTimestamp]; ok {
+				m.Fields[MetadataTimestamp] = v
+			}
+		}
+
+		if !s.Expiry.IsZero() && (m.Expiry.IsZero() || s.Expiry.Before(m.Expiry)) {
+			m.Expiry = s.Expiry
+			m.Fields[MetadataExpiry] = s.Fields[MetadataExpiry]
+		}
+
+		m.Certificates = append(m.Certificates, s.Certificates...)
+	}
+
+	if m != nil {
+		m.File = m.Fields[MetadataFile]
+		m.Version = m.Fields[MetadataVersion]
+		m.Serial = m.Fields[MetadataSerial]
+		m.Class = m.Fields[MetadataClass]
+	}
+
+	return
+}
+
+// verifySignatures authenticates an input against the argument signatures,
+// in threshold mode each signature can be a bundle (see splitSignatures())
+// and only valid signatures made by distinct keys are counted.
+func (keys *Keys) verifySignatures(buf []byte, sigs [][]byte) (m *Metadata, err error) {
+	if keys.threshold <= 1 {
+		m, _, err = keys.verify(buf, sigs[0])
+		return
+	}
+
+	var metadata []*Metadata
+
+	valid := make(map[string]bool)
+
+	for _, bundle := range sigs {
+		for _, sig := range splitSignatures(bundle) {
+			s, id, err := keys.verify(buf, sig)
+
+			if err != nil || valid[id] {
+				continue
+			}
+
+			valid[id] = true
+			metadata = append(metadata, s)
+		}
+	}
+
+	if len(valid) < keys.threshold {
+		return nil, fmt.Errorf("invalid signature, insufficient valid signatures (%d < %d)", len(valid), keys.threshold)
+	}
+
+	if m, err = mergeMetadata(metadata); err != nil {
+		return nil, fmt.Errorf("invalid signature, %v", err)
+	}
+
+	return
+}
@@ -0,0 +1,144 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestThreshold(t *testing.T) {
+	a := newMinisign(t)
+	b := newMinisign(t)
+	c := newMinisign(t)
+	untrusted := newMinisign(t)
+
+	buf := []byte("armory-boot configuration")
+	comment := "timestamp:1760000000 file:armory-boot.conf"
+
+	for _, tt := range []struct {
+		name string
+		sigs []string
+		err  string
+	}{
+		{
+			name: "valid bundle",
+			sigs: []string{a.sign(buf, comment) + b.sign(buf, comment)},
+		},
+		{
+			name: "valid numbered signatures",
+			sigs: []string{a.sign(buf, comment), c.sign(buf, comment)},
+		},
+		{
+			name: "valid with invalid signatures",
+			sigs: []string{a.sign(buf, comment) + untrusted.sign(buf, comment) + c.sign([]byte("tampered"), comment) + b.sign(buf, comment)},
+		},
+		{
+			name: "insufficient signatures",
+			sigs: []string{a.sign(buf, comment)},
+			err:  "insufficient valid signatures (1 < 2)",
+		},
+		{
+			name: "duplicate key in bundle",
+			sigs: []string{a.sign(buf, comment) + a.sign(buf, comment)},
+			err:  "insufficient valid signatures (1 < 2)",
+		},
+		{
+			name: "duplicate key across signatures",
+			sigs: []string{a.sign(buf, comment) + untrusted.sign(buf, comment), a.sign(buf, comment)},
+			err:  "insufficient valid signatures (1 < 2)",
+		},
+		{
+			name: "invalid signature by distinct key",
+			sigs: []string{a.sign(buf, comment) + b.sign([]byte("tampered"), comment)},
+			err:  "insufficient valid signatures (1 < 2)",
+		},
+		{
+			name: "conflicting trusted comments",
+			sigs: []string{a.sign(buf, comment) + b.sign(buf, "timestamp:1760000000 file:other.conf")},
+			err:  "conflicting trusted comment field file",
+		},
+		{
+			name: "conflicting serial",
+			sigs: []string{a.sign(buf, comment+" serial:1") + b.sign(buf, comment+" serial:2")},
+			err:  "conflicting trusted comment field serial",
+		},
+		{
+			name: "missing trusted comment field",
+			sigs: []string{a.sign(buf, comment+" class:dev") + b.sign(buf, comment)},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := NewKeys(strings.Join([]string{a.String(), b.String(), c.String()}, ","))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err = keys.SetThreshold(2); err != nil {
+				t.Fatal(err)
+			}
+
+			var sigs [][]byte
+
+			for _, sig := range tt.sigs {
+				sigs = append(sigs, []byte(sig))
+			}
+
+			m, err := keys.verifySignatures(buf, sigs)
+
+			switch {
+			case len(tt.err) == 0 && err != nil:
+				t.Fatalf("unexpected error, %v", err)
+			case len(tt.err) > 0 && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Fatalf("expected error %q, got %v", tt.err, err)
+			case err == nil && m.File != "armory-boot.conf":
+				t.Fatalf("invalid metadata %+v", m)
+			}
+		})
+	}
+}
+
+func TestMergeMetadata(t *testing.T) {
+	var metadata []*Metadata
+
+	for _, s := range []string{
+		"timestamp:1760000000 expiry:1790000000 file:armory-boot.conf class:dev",
+		"timestamp:1770000000 expiry:1780000000 file:armory-boot.conf",
+		"timestamp:1750000000 file:armory-boot.conf serial:1234",
+	} {
+		m, err := ParseTrustedComment(s)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		metadata = append(metadata, m)
+	}
+
+	m, err := mergeMetadata(append(metadata, nil))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	switch {
+	case m.Timestamp.Unix() != 1770000000 || m.Fields[MetadataTimestamp] != "1770000000":
+		t.Fatalf("invalid timestamp %v", m.Timestamp)
+	case m.Expiry.Unix() != 1780000000 || m.Fields[MetadataExpiry] != "1780000000":
+		t.Fatalf("invalid expiry %v", m.Expiry)
+	case m.File != "armory-boot.conf" || m.Class != "dev" || m.Serial != "1234":
+		t.Fatalf("invalid metadata %+v", m)
+	}
+
+	if m, err = mergeMetadata([]*Metadata{nil, nil}); err != nil || m != nil {
+		t.Fatalf("unexpected metadata %+v, %v", m, err)
+	}
+
+	if err = (&Keys{}).SetThreshold(1); err == nil {
+		t.Fatal("expected invalid threshold error")
+	}
+}
@@ -0,0 +1,399 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"reflect"
+	"strings"
+
+	"github.com/usbarmory/armory-boot/slot"
+)
+
+// Configuration validation errors
+var (
+	ErrSyntax        = errors.New("invalid syntax")
+	ErrUnknownField  = errors.New("unknown field")
+	ErrInvalidType   = errors.New("invalid type")
+	ErrMissing       = errors.New("missing parameter")
+	ErrConflict      = errors.New("conflicting parameters")
+	ErrInvalidSize   = errors.New("invalid parameter size")
+	ErrInvalidPath   = errors.New("invalid path")
+	ErrInvalidDigest = errors.New("invalid digest")
+	ErrInvalidHash   = errors.New("invalid hash")
+	ErrInvalidValue  = errors.New("invalid value")
+	ErrSizeMismatch  = errors.New("size mismatch")
+)
+
+// FieldError represents a configuration validation error, the reason can be
+// matched with errors.Is() against the package validation errors (e.g.
+// ErrInvalidPath).
+type FieldError struct {
+	// Field is the configuration field name (e.g. `kernel` or
+	// `slots.a.kernel`), it is empty for errors not specific to a field.
+	Field string
+	// Err is the validation error reason.
+	Err error
+	// Detail optionally holds additional error information.
+	Detail string
+}
+
+// Error implements the error interface.
+func (e *FieldError) Error() string {
+	var s string
+
+	if len(e.Field) > 0 {
+		s = e.Field + ": "
+	}
+
+	s += e.Err.Error()
+
+	if len(e.Detail) > 0 {
+		s += ", " + e.Detail
+	}
+
+	return s
+}
+
+// Unwrap returns the validation error reason.
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// slotFields represents the fields allowed within A/B slot configurations.
+var slotFields = []string{"kernel", "dtb", "initrd", "overlays", "cmdline", "unikernel", "env"}
+
+func configFields() (fields []string) {
+	t := reflect.TypeFor[Config]()
+
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+
+		if len(name) > 0 && name != "-" {
+			fields = append(fields, name)
+		}
+	}
+
+	return
+}
+
+// imageKeys represents the configuration fields holding kernel images.
+var imageKeys = []string{"kernel", "dtb", "initrd", "unikernel"}
+
+// imageListKeys represents the configuration fields holding lists of images.
+var imageListKeys = []string{"overlays", "include"}
+
+// checkFields ensures that a JSON object only contains the argument fields,
+// the comparison is case sensitive unlike encoding/json field matching.
+func checkFields(buf []byte, prefix string, fields []string) (m map[string]json.RawMessage, err error) {
+	if err = json.Unmarshal(buf, &m); err != nil {
+		return nil, &FieldError{Field: strings.TrimSuffix(prefix, "."), Err: ErrSyntax, Detail: err.Error()}
+	}
+
+	known := make(map[string]bool)
+
+	for _, f := range fields {
+		known[f] = true
+	}
+
+	for k := range m {
+		if !known[k] {
+			return nil, &FieldError{Field: prefix + k, Err: ErrUnknownField}
+		}
+	}
+
+	return
+}
+
+// decode strictly decodes a JSON configuration, rejecting unknown fields.
+func (c *Config) decode(buf []byte) (err error) {
+	m, err := checkFields(buf, "", configFields())
+
+	if err != nil {
+		return
+	}
+
+	if raw, ok := m["slots"]; ok {
+		var slots map[string]json.RawMessage
+
+		if err = json.Unmarshal(raw, &slots); err != nil {
+			return &FieldError{Field: "slots", Err: ErrInvalidType}
+		}
+
+		for name, s := range slots {
+			sm, err := checkFields(s, "slots."+name+".", slotFields)
+
+			if err != nil {
+				return err
+			}
+
+			if err = checkImageFields(sm, "slots."+name+"."); err != nil {
+				return err
+			}
+		}
+	}
+
+	if raw, ok := m["restricted"]; ok {
+		rm, err := checkFields(raw, "restricted.", slotFields)
+
+		if err != nil {
+			return err
+		}
+
+		if err = checkImageFields(rm, "restricted."); err != nil {
+			return err
+		}
+	}
+
+	if err = checkImageFields(m, ""); err != nil {
+		return
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.DisallowUnknownFields()
+
+	if err = dec.Decode(c); err != nil {
+		var typeErr *json.UnmarshalTypeError
+
+		if errors.As(err, &typeErr) {
+			return &FieldError{Field: typeErr.Field, Err: ErrInvalidType}
+		}
+
+		return &FieldError{Err: ErrSyntax, Detail: err.Error()}
+	}
+
+	if _, err = dec.Token(); err != io.EOF {
+		return &FieldError{Err: ErrSyntax, Detail: "trailing data"}
+	}
+
+	c.normalize()
+
+	return nil
+}
+
+// checkImageFields ensures that version 2 image objects only contain known
+// fields.
+func checkImageFields(m map[string]json.RawMessage, prefix string) (err error) {
+	for _, k := range imageKeys {
+		raw, ok := m[k]
+
+		if !ok || !bytes.HasPrefix(bytes.TrimSpace(raw), []byte("{")) {
+			continue
+		}
+
+		if _, err = checkFields(raw, prefix+k+".", imageFields); err != nil {
+			return
+		}
+	}
+
+	for _, k := range imageListKeys {
+		var images []json.RawMessage
+
+		if raw, ok := m[k]; !ok || json.Unmarshal(raw, &images) != nil {
+			continue
+		}
+
+		for i, raw := range images {
+			if !bytes.HasPrefix(bytes.TrimSpace(raw), []byte("{")) {
+				continue
+			}
+
+			if _, err = checkFields(raw, fmt.Sprintf("%s%s.%d.", prefix, k, i), imageFields); err != nil {
+				return
+			}
+		}
+	}
+
+	return
+}
+
+// ValidatePath validates the syntax of a kernel image path, which must be
+// absolute and in canonical form.
+func ValidatePath(p string) error {
+	if !strings.HasPrefix(p, "/") || path.Clean(p) != p || p == "/" {
+		return ErrInvalidPath
+	}
+
+	if strings.ContainsFunc(p, func(r rune) bool { return r < 0x20 || r == 0x7f }) {
+		return ErrInvalidPath
+	}
+
+	return nil
+}
+
+func validateImage(field string, i *Image, version int, detached bool, required bool) (err error) {
+	switch {
+	case i == nil && !required:
+		return
+	case i == nil:
+		return &FieldError{Field: field, Err: ErrMissing}
+	case i.Positional() && version != Version1:
+		return &FieldError{Field: field, Err: ErrInvalidType, Detail: "positional parameters require version 1"}
+	case !i.Positional() && version == Version1:
+		return &FieldError{Field: field, Err: ErrInvalidType, Detail: "object parameters require version 2"}
+	}
+
+	if i.Positional() {
+		n := 2
+
+		if detached {
+			n = 1
+		}
+
+		if len(i.param) != n {
+			return &FieldError{Field: field, Err: ErrInvalidSize}
+		}
+	}
+
+	if len(i.Path) == 0 {
+		return &FieldError{Field: field + ".path", Err: ErrMissing}
+	}
+
+	if err = ValidatePath(i.Path); err != nil {
+		return &FieldError{Field: field, Err: err, Detail: i.Path}
+	}
+
+	if i.Size < 0 {
+		return &FieldError{Field: field + ".size", Err: ErrInvalidValue}
+	}
+
+	if i.Encryption != nil {
+		if err = i.Encryption.validate(); err != nil {
+			return &FieldError{Field: field + ".encryption", Err: ErrInvalidValue, Detail: err.Error()}
+		}
+	}
+
+	switch {
+	case detached && len(i.Digest) > 0:
+		return &FieldError{Field: field + ".digest", Err: ErrConflict, Detail: "digests are not supported with detached signatures"}
+	case detached:
+		return
+	case len(i.Digest) == 0:
+		return &FieldError{Field: field + ".digest", Err: ErrMissing}
+	}
+
+	if _, _, err = ParseDigest(i.Digest); err != nil {
+		return &FieldError{Field: field, Err: ErrInvalidDigest, Detail: err.Error()}
+	}
+
+	return
+}
+
+func (c *Config) validateImages(prefix string, version int, detached bool) (err error) {
+	if _, err = ExpandCmdLine(c.CmdLine, cmdLineVars); err != nil {
+		return &FieldError{Field: prefix + "cmdline", Err: ErrInvalidValue, Detail: err.Error()}
+	}
+
+	for i, env := range c.Env {
+		if k, _, ok := strings.Cut(env, "="); !ok || len(k) == 0 {
+			return &FieldError{Field: fmt.Sprintf("%senv.%d", prefix, i), Err: ErrInvalidValue, Detail: "must be in key=value format"}
+		}
+	}
+
+	isUnikernel, isKernel := c.UnikernelImage != nil, c.KernelImage != nil
+
+	switch {
+	case isUnikernel && isKernel:
+		return &FieldError{Field: prefix + "unikernel", Err: ErrConflict, Detail: "must specify either unikernel or kernel"}
+	case isUnikernel:
+		if c.DeviceTreeBlobImage != nil || c.InitialRamDiskImage != nil || len(c.DeviceTreeOverlayImages) > 0 {
+			return &FieldError{Field: prefix + "unikernel", Err: ErrConflict, Detail: "dtb, initrd and overlays are not supported"}
+		}
+
+		return validateImage(prefix+"unikernel", c.UnikernelImage, version, detached, true)
+	case isKernel:
+		if len(c.Env) > 0 {
+			return &FieldError{Field: prefix + "env", Err: ErrConflict, Detail: "only supported with unikernel"}
+		}
+
+		if err = validateImage(prefix+"kernel", c.KernelImage, version, detached, true); err != nil {
+			return
+		}
+
+		if err = validateImage(prefix+"dtb", c.DeviceTreeBlobImage, version, detached, true); err != nil {
+			return
+		}
+
+		if err = validateImage(prefix+"initrd", c.InitialRamDiskImage, version, detached, false); err != nil {
+			return
+		}
+
+		for i, overlay := range c.DeviceTreeOverlayImages {
+			if err = validateImage(fmt.Sprintf("%soverlays.%d", prefix, i), overlay, version, detached, true); err != nil {
+				return
+			}
+		}
+
+		return
+	default:
+		return &FieldError{Field: prefix + "kernel", Err: ErrMissing, Detail: "must specify either unikernel or kernel"}
+	}
+}
+
+func (c *Config) version() int {
+	if c.Version == 0 {
+		return Version1
+	}
+
+	return c.Version
+}
+
+// Validate verifies the configuration parameters, returning a *FieldError for
+// any invalid one.
+func (c *Config) Validate() (err error) {
+	version := c.version()
+
+	if version != Version1 && version != Version2 {
+		return &FieldError{Field: "version", Err: ErrInvalidValue, Detail: "unsupported version"}
+	}
+
+	for i, include := range c.Includes {
+		if err = validateImage(fmt.Sprintf("include.%d", i), include, version, c.Detached, true); err != nil {
+			return
+		}
+
+		if include.Encryption != nil {
+			return &FieldError{Field: fmt.Sprintf("include.%d.encryption", i), Err: ErrConflict, Detail: "fragments cannot be encrypted"}
+		}
+	}
+
+	if c.MinSecurityVersion > c.SecurityVersion {
+		return &FieldError{Field: "min_security_version", Err: ErrInvalidValue, Detail: "exceeds security_version"}
+	}
+
+	if err = c.validateTamper(version); err != nil {
+		return
+	}
+
+	if len(c.Slots) == 0 {
+		return c.validateImages("", version, c.Detached)
+	}
+
+	if c.KernelImage != nil || c.UnikernelImage != nil || c.DeviceTreeBlobImage != nil || c.InitialRamDiskImage != nil || len(c.DeviceTreeOverlayImages) > 0 || len(c.CmdLine) > 0 || len(c.Env) > 0 {
+		return &FieldError{Field: "slots", Err: ErrConflict, Detail: "slots and kernel parameters are mutually exclusive"}
+	}
+
+	for name, s := range c.Slots {
+		if !slot.Valid(name) {
+			return &FieldError{Field: "slots." + name, Err: ErrInvalidValue, Detail: "invalid slot name"}
+		}
+
+		if s == nil {
+			return &FieldError{Field: "slots." + name, Err: ErrMissing}
+		}
+
+		if err = s.validateImages("slots."+name+".", version, c.Detached); err != nil {
+			return
+		}
+	}
+
+	return
+}
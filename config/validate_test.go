@@ -0,0 +1,404 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"testing"
+)
+
+const (
+	testKernelDigest = "aceb3514d5ba6ac591a7d5f2cad680e83a9f848d19763563da8024f003e927c7"
+	testDTBDigest    = "60d4fe465ef60042293f5723bf4a001d8e75f26e517af2b55e6efaef9c0db1f6"
+)
+
+// memPart implements Partition over in-memory files.
+type memPart map[string][]byte
+
+func (p memPart) ReadAll(path string) ([]byte, error) {
+	if buf, ok := p[path]; ok {
+		return buf, nil
+	}
+
+	return nil, fs.ErrNotExist
+}
+
+// trusted implements Targets accepting any file, so that kernel image
+// digests are verified without signatures.
+type trusted struct{}
+
+func (trusted) Verify(string, []byte) error {
+	return nil
+}
+
+var (
+	testKernel = []byte("kernel")
+	testDTB    = []byte("dtb")
+)
+
+func hexDigest(buf []byte) string {
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:])
+}
+
+// testPartition returns a partition holding a configuration with the argument
+// JSON contents, where `$K` and `$D` are replaced with the test kernel and dtb
+// digests.
+func testPartition(s string) memPart {
+	s = strings.NewReplacer("$K", hexDigest(testKernel), "$D", hexDigest(testDTB)).Replace(s)
+
+	return memPart{
+		DefaultConfigPath: []byte(s),
+		"/boot/zImage":    testKernel,
+		"/boot/x.dtb":     testDTB,
+	}
+}
+
+// validate decodes and validates a configuration with the argument JSON
+// contents, where `$K` and `$D` are replaced with valid digests.
+func validate(s string) (err error) {
+	s = strings.NewReplacer("$K", testKernelDigest, "$D", testDTBDigest).Replace(s)
+	c := &Config{}
+
+	if err = c.decode([]byte(s)); err != nil {
+		return
+	}
+
+	return c.Validate()
+}
+
+func TestFieldError(t *testing.T) {
+	for _, tt := range []struct {
+		err  *FieldError
+		want string
+	}{
+		{&FieldError{Err: ErrSyntax}, "invalid syntax"},
+		{&FieldError{Field: "kernel", Err: ErrMissing}, "kernel: missing parameter"},
+		{&FieldError{Field: "dtb", Err: ErrInvalidSize, Detail: "3 != 2"}, "dtb: invalid parameter size, 3 != 2"},
+		{&FieldError{Err: ErrSyntax, Detail: "trailing data"}, "invalid syntax, trailing data"},
+	} {
+		if got := tt.err.Error(); got != tt.want {
+			t.Errorf("Error() = %q, want %q", got, tt.want)
+		}
+
+		var err error = fmt.Errorf("wrapped, %w", tt.err)
+
+		if !errors.Is(err, tt.err.Err) {
+			t.Errorf("%q does not match %v", err, tt.err.Err)
+		}
+
+		var fe *FieldError
+
+		if !errors.As(err, &fe) || fe.Field != tt.err.Field {
+			t.Errorf("%q does not unwrap to field %q", err, tt.err.Field)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		conf  string
+		field string
+		err   error
+	}{
+		{
+			name: "valid",
+			conf: `{"kernel": ["/boot/zImage", "$K"], "dtb": ["/boot/x.dtb", "$D"], "cmdline": "console=ttymxc1"}`,
+		},
+		{
+			name: "valid version 2",
+			conf: `{"version": 2, "kernel": {"path": "/boot/zImage", "digest": "sha256:$K", "size": 6}, "dtb": {"path": "/boot/x.dtb", "digest": "$D"}}`,
+		},
+		{
+			name: "valid slots",
+			conf: `{"slots": {"a": {"kernel": ["/boot/zImage", "$K"], "dtb": ["/boot/x.dtb", "$D"]}, "b": {"unikernel": ["/boot/app", "$K"]}}}`,
+		},
+		{
+			name: "syntax",
+			conf: `{"kernel": `,
+			err:  ErrSyntax,
+		},
+		{
+			name: "trailing data",
+			conf: `{"kernel": ["/boot/zImage", "$K"], "dtb": ["/boot/x.dtb", "$D"]} {}`,
+			err:  ErrSyntax,
+		},
+		{
+			name:  "unknown field",
+			conf:  `{"kernel": ["/boot/zImage", "$K"], "dtb": ["/boot/x.dtb", "$D"], "Kernel": []}`,
+			field: "Kernel",
+			err:   ErrUnknownField,
+		},
+		{
+			name:  "unknown slot field",
+			conf:  `{"slots": {"a": {"kernel": ["/boot/zImage", "$K"], "dtb": ["/boot/x.dtb", "$D"], "detached": true}}}`,
+			field: "slots.a.detached",
+			err:   ErrUnknownField,
+		},
+		{
+			name:  "unknown image field",
+			conf:  `{"version": 2, "kernel": {"path": "/boot/zImage", "digest": "$K", "hash": ""}, "dtb": {"path": "/boot/x.dtb", "digest": "$D"}}`,
+			field: "kernel.hash",
+			err:   ErrUnknownField,
+		},
+		{
+			name:  "invalid type",
+			conf:  `{"kernel": ["/boot/zImage", "$K"], "dtb": ["/boot/x.dtb", "$D"], "cmdline": 1}`,
+			field: "cmdline",
+			err:   ErrInvalidType,
+		},
+		{
+			name:  "object in version 1",
+			conf:  `{"kernel": {"path": "/boot/zImage", "digest": "$K"}, "dtb": ["/boot/x.dtb", "$D"]}`,
+			field: "kernel",
+			err:   ErrInvalidType,
+		},
+		{
+			name:  "positional in version 2",
+			conf:  `{"version": 2, "kernel": ["/boot/zImage", "$K"], "dtb": {"path": "/boot/x.dtb", "digest": "$D"}}`,
+			field: "kernel",
+			err:   ErrInvalidType,
+		},
+		{
+			name:  "missing kernel",
+			conf:  `{"dtb": ["/boot/x.dtb", "$D"]}`,
+			field: "kernel",
+			err:   ErrMissing,
+		},
+		{
+			name:  "missing dtb",
+			conf:  `{"kernel": ["/boot/zImage", "$K"]}`,
+			field: "dtb",
+			err:   ErrMissing,
+		},
+		{
+			name:  "missing path",
+			conf:  `{"version": 2, "kernel": {"digest": "$K"}, "dtb": {"path": "/boot/x.dtb", "digest": "$D"}}`,
+			field: "kernel.path",
+			err:   ErrMissing,
+		},
+		{
+			name:  "missing digest",
+			conf:  `{"version": 2, "kernel": {"path": "/boot/zImage"}, "dtb": {"path": "/boot/x.dtb", "digest": "$D"}}`,
+			field: "kernel.digest",
+			err:   ErrMissing,
+		},
+		{
+			name:  "kernel and unikernel",
+			conf:  `{"kernel": ["/boot/zImage", "$K"], "unikernel": ["/boot/zImage", "$K"]}`,
+			field: "unikernel",
+			err:   ErrConflict,
+		},
+		{
+			name:  "overlays with unikernel",
+			conf:  `{"unikernel": ["/boot/app", "$K"], "overlays": [["/boot/x.dtbo", "$D"]]}`,
+			field: "unikernel",
+			err:   ErrConflict,
+		},
+		{
+			name:  "env with kernel",
+			conf:  `{"kernel": ["/boot/zImage", "$K"], "dtb": ["/boot/x.dtb", "$D"], "env": ["A=1"]}`,
+			field: "env",
+			err:   ErrConflict,
+		},
+		{
+			name:  "invalid env",
+			conf:  `{"unikernel": ["/boot/app", "$K"], "env": ["A=1", "=1"]}`,
+			field: "env.1",
+			err:   ErrInvalidValue,
+		},
+		{
+			name:  "positional size",
+			conf:  `{"kernel": ["/boot/zImage"], "dtb": ["/boot/x.dtb", "$D"]}`,
+			field: "kernel",
+			err:   ErrInvalidSize,
+		},
+		{
+			name:  "relative path",
+			conf:  `{"kernel": ["boot/zImage", "$K"], "dtb": ["/boot/x.dtb", "$D"]}`,
+			field: "kernel",
+			err:   ErrInvalidPath,
+		},
+		{
+			name:  "non canonical path",
+			conf:  `{"kernel": ["/boot/../zImage", "$K"], "dtb": ["/boot/x.dtb", "$D"]}`,
+			field: "kernel",
+			err:   ErrInvalidPath,
+		},
+		{
+			name:  "invalid overlay path",
+			conf:  `{"kernel": ["/boot/zImage", "$K"], "dtb": ["/boot/x.dtb", "$D"], "overlays": [["x.dtbo", "$D"]]}`,
+			field: "overlays.0",
+			err:   ErrInvalidPath,
+		},
+		{
+			name:  "invalid digest",
+			conf:  `{"kernel": ["/boot/zImage", "md5:00"], "dtb": ["/boot/x.dtb", "$D"]}`,
+			field: "kernel",
+			err:   ErrInvalidDigest,
+		},
+		{
+			name:  "invalid size",
+			conf:  `{"version": 2, "kernel": {"path": "/boot/zImage", "digest": "$K", "size": -1}, "dtb": {"path": "/boot/x.dtb", "digest": "$D"}}`,
+			field: "kernel.size",
+			err:   ErrInvalidValue,
+		},
+		{
+			name:  "invalid version",
+			conf:  `{"version": 3, "kernel": ["/boot/zImage", "$K"], "dtb": ["/boot/x.dtb", "$D"]}`,
+			field: "version",
+			err:   ErrInvalidValue,
+		},
+		{
+			name:  "invalid minimum security version",
+			conf:  `{"security_version": 1, "min_security_version": 2, "kernel": ["/boot/zImage", "$K"], "dtb": ["/boot/x.dtb", "$D"]}`,
+			field: "min_security_version",
+			err:   ErrInvalidValue,
+		},
+		{
+			name:  "invalid tamper policy",
+			conf:  `{"tamper": "ignore", "kernel": ["/boot/zImage", "$K"], "dtb": ["/boot/x.dtb", "$D"]}`,
+			field: "tamper",
+			err:   ErrInvalidValue,
+		},
+		{
+			name:  "missing restricted entry",
+			conf:  `{"tamper": "restricted", "kernel": ["/boot/zImage", "$K"], "dtb": ["/boot/x.dtb", "$D"]}`,
+			field: "restricted",
+			err:   ErrMissing,
+		},
+		{
+			name:  "unused restricted entry",
+			conf:  `{"kernel": ["/boot/zImage", "$K"], "dtb": ["/boot/x.dtb", "$D"], "restricted": {"unikernel": ["/boot/app", "$K"]}}`,
+			field: "restricted",
+			err:   ErrConflict,
+		},
+		{
+			name:  "invalid monitor",
+			conf:  `{"monitors": ["clock", "light"], "kernel": ["/boot/zImage", "$K"], "dtb": ["/boot/x.dtb", "$D"]}`,
+			field: "monitors",
+			err:   ErrInvalidValue,
+		},
+		{
+			name:  "duplicate monitor",
+			conf:  `{"monitors": ["clock", "clock"], "kernel": ["/boot/zImage", "$K"], "dtb": ["/boot/x.dtb", "$D"]}`,
+			field: "monitors",
+			err:   ErrInvalidValue,
+		},
+		{
+			name:  "slots and kernel",
+			conf:  `{"kernel": ["/boot/zImage", "$K"], "slots": {"a": {"unikernel": ["/boot/app", "$K"]}}}`,
+			field: "slots",
+			err:   ErrConflict,
+		},
+		{
+			name:  "invalid slot name",
+			conf:  `{"slots": {"c": {"unikernel": ["/boot/app", "$K"]}}}`,
+			field: "slots.c",
+			err:   ErrInvalidValue,
+		},
+		{
+			name:  "invalid slot image",
+			conf:  `{"slots": {"a": {"kernel": ["/boot/zImage", "$K"]}}}`,
+			field: "slots.a.dtb",
+			err:   ErrMissing,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validate(tt.conf)
+
+			if tt.err == nil {
+				if err != nil {
+					t.Fatalf("unexpected error, %v", err)
+				}
+
+				return
+			}
+
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error %v, want %v", err, tt.err)
+			}
+
+			var fe *FieldError
+
+			if len(tt.field) > 0 && (!errors.As(err, &fe) || fe.Field != tt.field) {
+				t.Fatalf("error %v, want field %q", err, tt.field)
+			}
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		conf  string
+		slot  string
+		field string
+		err   error
+	}{
+		{
+			name: "valid",
+			conf: `{"version": 2, "kernel": {"path": "/boot/zImage", "digest": "sha256:$K", "size": 6}, "dtb": {"path": "/boot/x.dtb", "digest": "$D"}}`,
+		},
+		{
+			name:  "invalid configuration",
+			conf:  `{"kernel": ["/boot/zImage", "$K"]}`,
+			field: "dtb",
+			err:   ErrMissing,
+		},
+		{
+			name:  "invalid hash",
+			conf:  `{"kernel": ["/boot/zImage", "$D"], "dtb": ["/boot/x.dtb", "$D"]}`,
+			field: "kernel",
+			err:   ErrInvalidHash,
+		},
+		{
+			name:  "size mismatch",
+			conf:  `{"version": 2, "kernel": {"path": "/boot/zImage", "digest": "$K", "size": 7}, "dtb": {"path": "/boot/x.dtb", "digest": "$D"}}`,
+			field: "kernel",
+			err:   ErrSizeMismatch,
+		},
+		{
+			name: "restricted entry",
+			conf: `{"tamper": "restricted", "kernel": ["/boot/zImage", "$K"], "dtb": ["/boot/x.dtb", "$D"], "restricted": {"kernel": ["/boot/zImage", "$K"], "dtb": ["/boot/x.dtb", "$D"]}}`,
+			slot: RestrictedEntry,
+		},
+		{
+			name: "tamper refused",
+			conf: `{"kernel": ["/boot/zImage", "$K"], "dtb": ["/boot/x.dtb", "$D"]}`,
+			slot: RestrictedEntry,
+			err:  ErrTamper,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadTargets(testPartition(tt.conf), DefaultConfigPath, trusted{}, tt.slot)
+
+			if tt.err == nil {
+				if err != nil {
+					t.Fatalf("unexpected error, %v", err)
+				}
+
+				return
+			}
+
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error %v, want %v", err, tt.err)
+			}
+
+			var fe *FieldError
+
+			if len(tt.field) > 0 && (!errors.As(err, &fe) || fe.Field != tt.field) {
+				t.Fatalf("error %v, want field %q", err, tt.field)
+			}
+		})
+	}
+}
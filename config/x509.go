@@ -0,0 +1,497 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package config
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"time"
+)
+
+// DefaultCRLPath is the default armory-boot X.509 certificate revocation list
+// path, the file can hold one or more DER or PEM encoded revocation lists,
+// each authenticated by its issuer signature.
+const DefaultCRLPath = "/boot/armory-boot.crl"
+
+// PEM block types of simple X.509 signatures, consisting of a DER signature
+// followed by the signer certificate and any intermediate certificate.
+const (
+	pemSignature   = "SIGNATURE"
+	pemCertificate = "CERTIFICATE"
+	pemCMS         = "CMS"
+	pemPKCS7       = "PKCS7"
+	pemCRL         = "X509 CRL"
+)
+
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+
+	oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+)
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,tag:0"`
+}
+
+type encapsulatedContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     []byte `asn1:"explicit,optional,tag:0"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      encapsulatedContentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type issuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type signerInfo struct {
+	Version            int
+	SID                asn1.RawValue
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+	UnsignedAttrs      asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue `asn1:"set"`
+}
+
+// x509Signature represents a parsed X.509 signature.
+type x509Signature struct {
+	// signer certificate
+	cert *x509.Certificate
+	// additional certificates
+	certs []*x509.Certificate
+	// digest algorithm
+	hash crypto.Hash
+	// signed message
+	msg []byte
+	// signature value
+	sig []byte
+	// signing time
+	time time.Time
+}
+
+func isX509Signature(sig []byte) bool {
+	return bytes.HasPrefix(sig, []byte{0x30}) || bytes.HasPrefix(sig, []byte("-----BEGIN "))
+}
+
+func (keys *Keys) addRoot(s string) (err error) {
+	der, err := base64.StdEncoding.DecodeString(s)
+
+	if err != nil {
+		return errors.New("invalid encoded public key")
+	}
+
+	cert, err := x509.ParseCertificate(der)
+
+	if err != nil {
+		return fmt.Errorf("invalid root certificate, %v", err)
+	}
+
+	if !cert.IsCA {
+		return fmt.Errorf("invalid root certificate, %s is not a CA", cert.Subject)
+	}
+
+	keys.roots = append(keys.roots, cert)
+
+	return
+}
+
+// loadCRL reads the X.509 certificate revocation lists, when present.
+func (keys *Keys) loadCRL(part Partition) (err error) {
+	buf, err := part.ReadAll(DefaultCRLPath)
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("invalid CRL path, %v", err)
+	}
+
+	var ders [][]byte
+
+	if bytes.HasPrefix(buf, []byte{0x30}) {
+		ders = append(ders, buf)
+	}
+
+	for block, rest := pem.Decode(buf); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type == pemCRL {
+			ders = append(ders, block.Bytes)
+		}
+	}
+
+	for _, der := range ders {
+		crl, err := x509.ParseRevocationList(der)
+
+		if err != nil {
+			return fmt.Errorf("invalid CRL, %v", err)
+		}
+
+		keys.crls = append(keys.crls, crl)
+	}
+
+	return
+}
+
+func digestAlgorithm(oid asn1.ObjectIdentifier) (crypto.Hash, error) {
+	switch {
+	case oid.Equal(oidSHA256):
+		return crypto.SHA256, nil
+	case oid.Equal(oidSHA384):
+		return crypto.SHA384, nil
+	case oid.Equal(oidSHA512):
+		return crypto.SHA512, nil
+	}
+
+	return 0, fmt.Errorf("unsupported digest algorithm %s", oid)
+}
+
+func digest(h crypto.Hash, buf []byte) []byte {
+	d := h.New()
+	d.Write(buf)
+	return d.Sum(nil)
+}
+
+func parseCertificates(raw asn1.RawValue) (certs []*x509.Certificate, err error) {
+	if len(raw.Bytes) == 0 {
+		return
+	}
+
+	return x509.ParseCertificates(raw.Bytes)
+}
+
+// parseCMS parses a detached (or attached, matching the argument content) CMS
+// SignedData structure, with a single signer.
+func parseCMS(buf []byte, der []byte) (s *x509Signature, err error) {
+	ci := &contentInfo{}
+
+	if rest, err := asn1.Unmarshal(der, ci); err != nil || len(rest) > 0 || !ci.ContentType.Equal(oidSignedData) {
+		return nil, errors.New("invalid CMS content")
+	}
+
+	sd := &signedData{}
+
+	if rest, err := asn1.Unmarshal(ci.Content.Bytes, sd); err != nil || len(rest) > 0 {
+		return nil, errors.New("invalid CMS signed data")
+	}
+
+	if sd.ContentInfo.Content != nil && !bytes.Equal(sd.ContentInfo.Content, buf) {
+		return nil, errors.New("CMS content mismatch")
+	}
+
+	if len(sd.SignerInfos) != 1 {
+		return nil, errors.New("unsupported CMS signer count")
+	}
+
+	si := sd.SignerInfos[0]
+	s = &x509Signature{
+		msg: buf,
+		sig: si.Signature,
+	}
+
+	if s.hash, err = digestAlgorithm(si.DigestAlgorithm.Algorithm); err != nil {
+		return nil, err
+	}
+
+	if s.certs, err = parseCertificates(sd.Certificates); err != nil {
+		return nil, fmt.Errorf("invalid CMS certificates, %v", err)
+	}
+
+	for _, cert := range s.certs {
+		if signerMatch(si.SID, cert) {
+			s.cert = cert
+			break
+		}
+	}
+
+	if s.cert == nil {
+		return nil, errors.New("missing CMS signer certificate")
+	}
+
+	if len(si.SignedAttrs.Bytes) == 0 {
+		if !sd.ContentInfo.ContentType.Equal(oidData) {
+			return nil, errors.New("invalid CMS content type")
+		}
+
+		return
+	}
+
+	// the signature covers the DER encoding of signed attributes, as a SET
+	attrs := bytes.Clone(si.SignedAttrs.FullBytes)
+	attrs[0] = 0x31
+	s.msg = attrs
+
+	if err = s.parseAttributes(buf, si.SignedAttrs.Bytes, sd.ContentInfo.ContentType); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+func signerMatch(sid asn1.RawValue, cert *x509.Certificate) bool {
+	if sid.Class == asn1.ClassContextSpecific && sid.Tag == 0 {
+		return len(cert.SubjectKeyId) > 0 && bytes.Equal(sid.Bytes, cert.SubjectKeyId)
+	}
+
+	ias := &issuerAndSerialNumber{}
+
+	if _, err := asn1.Unmarshal(sid.FullBytes, ias); err != nil {
+		return false
+	}
+
+	return bytes.Equal(ias.Issuer.FullBytes, cert.RawIssuer) && ias.SerialNumber.Cmp(cert.SerialNumber) == 0
+}
+
+func (s *x509Signature) parseAttributes(buf []byte, der []byte, contentType asn1.ObjectIdentifier) (err error) {
+	var hasDigest, hasType bool
+
+	for len(der) > 0 {
+		attr := &attribute{}
+
+		if der, err = asn1.Unmarshal(der, attr); err != nil {
+			return errors.New("invalid CMS signed attributes")
+		}
+
+		switch {
+		case attr.Type.Equal(oidContentType):
+			var oid asn1.ObjectIdentifier
+
+			if _, err = asn1.Unmarshal(attr.Values.Bytes, &oid); err != nil || !oid.Equal(contentType) {
+				return errors.New("invalid CMS content type")
+			}
+
+			hasType = true
+		case attr.Type.Equal(oidMessageDigest):
+			var md []byte
+
+			if _, err = asn1.Unmarshal(attr.Values.Bytes, &md); err != nil || !bytes.Equal(md, digest(s.hash, buf)) {
+				return errors.New("CMS message digest mismatch")
+			}
+
+			hasDigest = true
+		case attr.Type.Equal(oidSigningTime):
+			if _, err = asn1.Unmarshal(attr.Values.Bytes, &s.time); err != nil {
+				return errors.New("invalid CMS signing time")
+			}
+		}
+	}
+
+	if !hasDigest || !hasType || !contentType.Equal(oidData) {
+		return errors.New("invalid CMS signed attributes")
+	}
+
+	return
+}
+
+// parsePEM parses a PEM encoded CMS signature, or a simple X.509 signature
+// consisting of a DER signature followed by the signer certificate and any
+// intermediate certificate.
+func parsePEM(buf []byte, sig []byte) (s *x509Signature, err error) {
+	block, rest := pem.Decode(sig)
+
+	if block == nil {
+		return nil, errors.New("invalid PEM signature")
+	}
+
+	switch block.Type {
+	case pemCMS, pemPKCS7:
+		return parseCMS(buf, block.Bytes)
+	case pemSignature:
+	default:
+		return nil, fmt.Errorf("unsupported PEM signature type %s", block.Type)
+	}
+
+	s = &x509Signature{
+		msg: buf,
+		sig: block.Bytes,
+	}
+
+	for block, rest = pem.Decode(rest); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != pemCertificate {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate, %v", err)
+		}
+
+		s.certs = append(s.certs, cert)
+	}
+
+	if len(s.certs) == 0 {
+		return nil, errors.New("missing signer certificate")
+	}
+
+	s.cert = s.certs[0]
+	s.hash = crypto.SHA256
+
+	if pub, ok := s.cert.PublicKey.(*ecdsa.PublicKey); ok && pub.Curve.Params().BitSize > 256 {
+		s.hash = crypto.SHA384
+	}
+
+	return
+}
+
+// verify authenticates the signature with the signer certificate public key.
+func (s *x509Signature) verify() (err error) {
+	hash := digest(s.hash, s.msg)
+
+	switch pub := s.cert.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		if !verifyECDSA(pub, hash, s.sig) {
+			return errors.New("verification failure")
+		}
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(pub, s.hash, hash, s.sig) != nil {
+			return errors.New("verification failure")
+		}
+	default:
+		return errors.New("unsupported public key algorithm")
+	}
+
+	return
+}
+
+func (keys *Keys) revoked(chain []*x509.Certificate) bool {
+	for _, cert := range chain {
+		if keys.retired[sha256.Sum256(cert.Raw)] {
+			return true
+		}
+	}
+
+	for i := 0; i < len(chain)-1; i++ {
+		cert := chain[i]
+		issuer := chain[i+1]
+
+		for _, crl := range keys.crls {
+			if !bytes.Equal(crl.RawIssuer, cert.RawIssuer) || crl.CheckSignatureFrom(issuer) != nil {
+				continue
+			}
+
+			for _, entry := range crl.RevokedCertificateEntries {
+				if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// verifyChain validates the signer certificate chain, up to a trusted root
+// certificate, returning the first valid one.
+//
+// Certificate validity periods are not enforced, as the current time is not
+// known at this stage, the chain is rather validated at the signer
+// certificate issuance time and its validity is enforced by Policy.Check()
+// through the returned metadata.
+func (keys *Keys) verifyChain(s *x509Signature) (chain []*x509.Certificate, err error) {
+	if len(keys.roots) == 0 {
+		return nil, errors.New("no trusted root certificates")
+	}
+
+	if s.cert.KeyUsage != 0 && s.cert.KeyUsage&x509.KeyUsageDigitalSignature == 0 {
+		return nil, errors.New("signer certificate key usage does not allow signing")
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         x509.NewCertPool(),
+		Intermediates: x509.NewCertPool(),
+		CurrentTime:   s.cert.NotBefore,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}
+
+	for _, root := range keys.roots {
+		opts.Roots.AddCert(root)
+	}
+
+	for _, cert := range s.certs {
+		if cert != s.cert {
+			opts.Intermediates.AddCert(cert)
+		}
+	}
+
+	chains, err := s.cert.Verify(opts)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate chain, %v", err)
+	}
+
+	for _, chain := range chains {
+		if !keys.revoked(chain) {
+			return chain, nil
+		}
+	}
+
+	return nil, errors.New("revoked certificate")
+}
+
+// verifyX509 authenticates an input against a CMS (DER or PEM encoded) or
+// simple X.509 signature, whose signer certificate must chain up to a trusted
+// root certificate.
+func (keys *Keys) verifyX509(buf []byte, sig []byte) (m *Metadata, err error) {
+	var s *x509Signature
+
+	if sig[0] == 0x30 {
+		s, err = parseCMS(buf, sig)
+	} else {
+		s, err = parsePEM(buf, sig)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature, %v", err)
+	}
+
+	chain, err := keys.verifyChain(s)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature, %v", err)
+	}
+
+	if err = s.verify(); err != nil {
+		return nil, fmt.Errorf("invalid signature, %v", err)
+	}
+
+	m = &Metadata{
+		Timestamp:    s.time,
+		Certificates: chain,
+	}
+
+	return
+}
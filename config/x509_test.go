@@ -0,0 +1,299 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package config
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCA represents a test certificate and its private key.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+var testSerial int64
+
+// newCert issues a certificate, valid within the argument period, signed by
+// the argument issuer or self-signed when nil.
+func newCert(t *testing.T, cn string, ca bool, issuer *testCA, notBefore time.Time, notAfter time.Time) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testSerial += 1
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(testSerial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}
+
+	if ca {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+		tmpl.ExtKeyUsage = nil
+	}
+
+	parent, parentKey := tmpl, key
+
+	if issuer != nil {
+		parent, parentKey = issuer.cert, issuer.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCA{cert: cert, key: key}
+}
+
+func marshal(t *testing.T, v any) []byte {
+	der, err := asn1.Marshal(v)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return der
+}
+
+func tagged(t *testing.T, class int, tag int, contents ...[]byte) []byte {
+	return marshal(t, asn1.RawValue{Class: class, Tag: tag, IsCompound: true, Bytes: bytes.Join(contents, nil)})
+}
+
+func testAttribute(t *testing.T, oid asn1.ObjectIdentifier, v any) []byte {
+	return marshal(t, attribute{
+		Type:   oid,
+		Values: asn1.RawValue{FullBytes: tagged(t, asn1.ClassUniversal, asn1.TagSet, marshal(t, v))},
+	})
+}
+
+// newCMS returns a detached DER CMS SignedData signature, with signed
+// attributes, of the argument input made by the argument signer.
+func newCMS(t *testing.T, buf []byte, signer *testCA, certs ...*x509.Certificate) []byte {
+	h := sha256.Sum256(buf)
+
+	attrs := [][]byte{
+		testAttribute(t, oidContentType, oidData),
+		testAttribute(t, oidSigningTime, time.Now().UTC()),
+		testAttribute(t, oidMessageDigest, h[:]),
+	}
+
+	signedAttrs := tagged(t, asn1.ClassContextSpecific, 0, attrs...)
+	set := append([]byte{0x31}, signedAttrs[1:]...)
+	digest := sha256.Sum256(set)
+
+	sig, err := ecdsa.SignASN1(rand.Reader, signer.key, digest[:])
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var raw [][]byte
+
+	for _, cert := range append([]*x509.Certificate{signer.cert}, certs...) {
+		raw = append(raw, cert.Raw)
+	}
+
+	sha256Alg := pkix.AlgorithmIdentifier{Algorithm: oidSHA256}
+
+	sd := signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{sha256Alg},
+		ContentInfo:      encapsulatedContentInfo{ContentType: oidData},
+		Certificates:     asn1.RawValue{FullBytes: tagged(t, asn1.ClassContextSpecific, 0, raw...)},
+		SignerInfos: []signerInfo{{
+			Version: 1,
+			SID: asn1.RawValue{FullBytes: marshal(t, issuerAndSerialNumber{
+				Issuer:       asn1.RawValue{FullBytes: signer.cert.RawIssuer},
+				SerialNumber: signer.cert.SerialNumber,
+			})},
+			DigestAlgorithm:    sha256Alg,
+			SignedAttrs:        asn1.RawValue{FullBytes: signedAttrs},
+			SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}},
+			Signature:          sig,
+		}},
+	}
+
+	return marshal(t, contentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{FullBytes: tagged(t, asn1.ClassContextSpecific, 0, marshal(t, sd))},
+	})
+}
+
+func newCRL(t *testing.T, issuer *testCA, revoked ...*x509.Certificate) *x509.RevocationList {
+	tmpl := &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now().Add(-time.Hour),
+		NextUpdate: time.Now().Add(time.Hour),
+	}
+
+	for _, cert := range revoked {
+		tmpl.RevokedCertificateEntries = append(tmpl.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   cert.SerialNumber,
+			RevocationTime: time.Now(),
+		})
+	}
+
+	der, err := x509.CreateRevocationList(rand.Reader, tmpl, issuer.cert, issuer.key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crl, err := x509.ParseRevocationList(der)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return crl
+}
+
+func TestVerifyX509(t *testing.T) {
+	now := time.Now()
+	year := 365 * 24 * time.Hour
+
+	root := newCert(t, "root", true, nil, now.Add(-2*year), now.Add(10*year))
+	other := newCert(t, "other root", true, nil, now.Add(-2*year), now.Add(10*year))
+	intermediate := newCert(t, "intermediate", true, root, now.Add(-2*year), now.Add(5*year))
+	signer := newCert(t, "signer", false, intermediate, now.Add(-time.Hour), now.Add(year))
+
+	// expired before the signer certificate issuance
+	expired := newCert(t, "expired intermediate", true, root, now.Add(-2*year), now.Add(-year))
+	expiredSigner := newCert(t, "expired signer", false, expired, now.Add(-time.Hour), now.Add(year))
+
+	// expired after the signer certificate issuance
+	lapsed := newCert(t, "lapsed intermediate", true, root, now.Add(-2*year), now.Add(-time.Minute))
+	lapsedSigner := newCert(t, "lapsed signer", false, lapsed, now.Add(-time.Hour), now.Add(year))
+
+	revoked := newCert(t, "revoked intermediate", true, root, now.Add(-2*year), now.Add(5*year))
+	revokedSigner := newCert(t, "revoked signer", false, revoked, now.Add(-time.Hour), now.Add(year))
+
+	buf := []byte("armory-boot configuration")
+
+	for _, tt := range []struct {
+		name string
+		sig  []byte
+		crls []*x509.RevocationList
+		// retire is retired by the key revocation list
+		retire *x509.Certificate
+		// time is the current time passed to the policy check
+		time time.Time
+		err  string
+	}{
+		{
+			name: "valid",
+			sig:  newCMS(t, buf, signer, intermediate.cert),
+			time: now,
+		},
+		{
+			name: "valid PEM",
+			sig:  pem.EncodeToMemory(&pem.Block{Type: pemCMS, Bytes: newCMS(t, buf, signer, intermediate.cert)}),
+			time: now,
+		},
+		{
+			name: "missing intermediate",
+			sig:  newCMS(t, buf, signer),
+			err:  "invalid certificate chain",
+		},
+		{
+			name: "untrusted root",
+			sig:  newCMS(t, buf, newCert(t, "untrusted", false, other, now.Add(-time.Hour), now.Add(year))),
+			err:  "invalid certificate chain",
+		},
+		{
+			name: "content mismatch",
+			sig:  newCMS(t, []byte("tampered"), signer, intermediate.cert),
+			err:  "CMS message digest mismatch",
+		},
+		{
+			name: "expired intermediate",
+			sig:  newCMS(t, buf, expiredSigner, expired.cert),
+			err:  "invalid certificate chain",
+		},
+		{
+			name: "lapsed intermediate",
+			sig:  newCMS(t, buf, lapsedSigner, lapsed.cert),
+			time: now,
+			err:  "certificate CN=lapsed intermediate not valid",
+		},
+		{
+			name: "revoked intermediate",
+			sig:  newCMS(t, buf, revokedSigner, revoked.cert),
+			crls: []*x509.RevocationList{newCRL(t, root, revoked.cert)},
+			err:  "revoked certificate",
+		},
+		{
+			name: "revocation list by untrusted issuer",
+			sig:  newCMS(t, buf, revokedSigner, revoked.cert),
+			crls: []*x509.RevocationList{newCRL(t, other, revoked.cert)},
+		},
+		{
+			name:   "retired intermediate",
+			sig:    newCMS(t, buf, signer, intermediate.cert),
+			retire: intermediate.cert,
+			err:    "revoked certificate",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := NewKeys(base64.StdEncoding.EncodeToString(root.cert.Raw))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			keys.crls = tt.crls
+
+			if tt.retire != nil {
+				keys.retired[sha256.Sum256(tt.retire.Raw)] = true
+			}
+
+			m, err := keys.VerifyMetadata(buf, tt.sig)
+
+			if err == nil {
+				err = (&Policy{Time: tt.time}).Check(m, DefaultConfigPath)
+			}
+
+			switch {
+			case len(tt.err) == 0 && err != nil:
+				t.Fatalf("unexpected error, %v", err)
+			case len(tt.err) > 0 && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Fatalf("expected error %q, got %v", tt.err, err)
+			case err == nil && (len(m.Certificates) != 3 || !m.Certificates[2].Equal(root.cert)):
+				t.Fatalf("invalid certificate chain %v", m.Certificates)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+//go:build tamago
+
+package disk
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/usbarmory/tamago/soc/nxp/usdhc"
+)
+
+// sdCard represents an SD/MMC card as block device.
+type sdCard struct {
+	*usdhc.USDHC
+}
+
+// Size implements the Card interface.
+func (card sdCard) Size() int64 {
+	info := card.Info()
+	return int64(info.Blocks) * int64(info.BlockSize)
+}
+
+// Detect initializes the USB armory internal flash ("eMMC") or external
+// microSD card ("uSD") as boot device, an ext4 partition must be present at
+// the passed start offset. An empty value for device or start parameter selects
+// its default value.
+func Detect(card *usdhc.USDHC, start string) (part *Partition, err error) {
+	offset := int64(DefaultOffset)
+
+	if card == nil {
+		return nil, errors.New("invalid card")
+	}
+
+	if len(start) > 0 {
+		if offset, err = strconv.ParseInt(start, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid start offset, %v\n", err)
+		}
+	}
+
+	part = &Partition{
+		Card:   sdCard{card},
+		Offset: offset,
+	}
+
+	if err := card.Detect(); err != nil {
+		return nil, fmt.Errorf("could not detect card, %v\n", err)
+	}
+
+	return
+}
@@ -6,11 +6,7 @@
 package disk
 
 import (
-	"errors"
-	"fmt"
-	"strconv"
-
-	"github.com/usbarmory/tamago/soc/nxp/usdhc"
+	"os"
 )
 
 // DefaultBootDevice is the default boot device
@@ -19,31 +15,53 @@ const DefaultBootDevice = "uSD"
 // DefaultOffset is the default start offset of the ext4 partition
 const DefaultOffset = 5242880
 
-// Detect initializes the USB armory internal flash ("eMMC") or external
-// microSD card ("uSD") as boot device, an ext4 partition must be present at
-// the passed start offset. An empty value for device or start parameter selects
-// its default value.
-func Detect(card *usdhc.USDHC, start string) (part *Partition, err error) {
-	offset := int64(DefaultOffset)
+// Card represents the block device holding a partition (e.g. an SD/MMC card
+// or a raw disk image).
+type Card interface {
+	// Read reads size bytes at the argument byte offset.
+	Read(offset int64, size int64) ([]byte, error)
+	// Size returns the block device size in bytes.
+	Size() int64
+}
 
-	if card == nil {
-		return nil, errors.New("invalid card")
-	}
+// Image represents a raw disk image file.
+type Image struct {
+	file *os.File
+	size int64
+}
 
-	if len(start) > 0 {
-		if offset, err = strconv.ParseInt(start, 10, 64); err != nil {
-			return nil, fmt.Errorf("invalid start offset, %v\n", err)
-		}
-	}
+// OpenImage opens a raw disk image file for reading.
+func OpenImage(name string) (img *Image, err error) {
+	f, err := os.Open(name)
 
-	part = &Partition{
-		Card:   card,
-		Offset: offset,
+	if err != nil {
+		return
 	}
 
-	if err := part.Card.Detect(); err != nil {
-		return nil, fmt.Errorf("could not detect card, %v\n", err)
+	st, err := f.Stat()
+
+	if err != nil {
+		f.Close()
+		return
 	}
 
+	return &Image{file: f, size: st.Size()}, nil
+}
+
+// Read implements the Card interface.
+func (img *Image) Read(offset int64, size int64) (buf []byte, err error) {
+	buf = make([]byte, size)
+	_, err = img.file.ReadAt(buf, offset)
+
 	return
 }
+
+// Size implements the Card interface.
+func (img *Image) Size() int64 {
+	return img.size
+}
+
+// Close closes the raw disk image file.
+func (img *Image) Close() error {
+	return img.file.Close()
+}
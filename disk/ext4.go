@@ -3,29 +3,27 @@
 // Use of this source code is governed by the license
 // that can be found in the LICENSE file.
 
-// Package disk provides support for SD/MMC card partition access, only ext4
-// filesystems are currently supported.
+// Package disk provides support for SD/MMC card and raw disk image partition
+// access, only ext4 filesystems are currently supported.
 //
-// This package is only meant to be used with `GOOS=tamago GOARCH=arm` as
+// SD/MMC card detection is only available with `GOOS=tamago GOARCH=arm` as
 // supported by the TamaGo framework for bare metal Go, see
 // https://github.com/usbarmory/tamago.
 package disk
 
 import (
-	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"strings"
 
 	"github.com/dsoprea/go-ext4"
-
-	"github.com/usbarmory/tamago/soc/nxp/usdhc"
 )
 
-// Partition represents an SD/MMC card partition, only ext4 filesystems are
+// Partition represents a block device partition, only ext4 filesystems are
 // currently supported.
 type Partition struct {
-	Card    *usdhc.USDHC
+	Card    Card
 	Offset  int64
 	_offset int64
 }
@@ -66,8 +64,7 @@ func (part *Partition) Read(p []byte) (n int, err error) {
 }
 
 func (part *Partition) Seek(offset int64, whence int) (int64, error) {
-	info := part.Card.Info()
-	end := int64(info.Blocks) * int64(info.BlockSize)
+	end := part.Card.Size()
 
 	switch whence {
 	case io.SeekStart:
@@ -144,7 +141,7 @@ func (part *Partition) ReadAll(fullPath string) (buf []byte, err error) {
 	}
 
 	if inodeNumber == 0 {
-		return nil, errors.New("file not found")
+		return nil, fs.ErrNotExist
 	}
 
 	inode, err := ext4.NewInodeWithReadSeeker(bgd, part, inodeNumber)
@@ -0,0 +1,153 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package disk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+)
+
+const (
+	sectorSize = 512
+
+	mbrSignature   = 0x1b8
+	mbrPartitions  = 0x1be
+	mbrEntrySize   = 16
+	mbrEntries     = 4
+	mbrTypeGPT     = 0xee
+	mbrBootMagic   = 0xaa55
+	mbrBootMagicAt = 0x1fe
+
+	gptMagic         = "EFI PART"
+	gptHeaderSize    = 92
+	gptHeaderCRC     = 0x10
+	gptEntriesLBA    = 0x48
+	gptEntries       = 0x50
+	gptEntrySize     = 0x54
+	gptEntriesCRC    = 0x58
+	gptMinEntrySize  = 128
+	gptMaxEntrySize  = 512
+	gptMaxEntries    = 1024
+	gptMaxEntriesLBA = math.MaxInt64 / sectorSize
+)
+
+// PartUUID returns the partition UUID, in the format used by the Linux kernel
+// `root=PARTUUID=` parameter, of the partition starting at the ext4 offset.
+//
+// Both MBR (disk signature and partition number) and GPT (unique partition
+// GUID) partition tables are supported.
+func (part *Partition) PartUUID() (uuid string, err error) {
+	mbr, err := part.Card.Read(0, sectorSize)
+
+	if err != nil {
+		return
+	}
+
+	if binary.LittleEndian.Uint16(mbr[mbrBootMagicAt:]) != mbrBootMagic {
+		return "", errors.New("partition table not found")
+	}
+
+	for i := 0; i < mbrEntries; i++ {
+		entry := mbr[mbrPartitions+i*mbrEntrySize:]
+
+		if entry[4] == mbrTypeGPT {
+			return part.gptPartUUID()
+		}
+
+		if int64(binary.LittleEndian.Uint32(entry[8:]))*sectorSize == part.Offset {
+			return fmt.Sprintf("%08x-%02x", binary.LittleEndian.Uint32(mbr[mbrSignature:]), i+1), nil
+		}
+	}
+
+	return "", errors.New("partition not found")
+}
+
+// gptHeader validates the primary GPT header, returning the location and
+// layout of the partition entry array.
+func gptHeader(hdr []byte) (lba int64, n int, size int, err error) {
+	if len(hdr) < sectorSize || !bytes.Equal(hdr[0:8], []byte(gptMagic)) {
+		return 0, 0, 0, errors.New("invalid GPT header")
+	}
+
+	hdrSize := binary.LittleEndian.Uint32(hdr[0x0c:])
+
+	if hdrSize < gptHeaderSize || hdrSize > sectorSize {
+		return 0, 0, 0, fmt.Errorf("invalid GPT header size %d", hdrSize)
+	}
+
+	buf := bytes.Clone(hdr[0:hdrSize])
+	clear(buf[gptHeaderCRC : gptHeaderCRC+4])
+
+	if crc32.ChecksumIEEE(buf) != binary.LittleEndian.Uint32(hdr[gptHeaderCRC:]) {
+		return 0, 0, 0, errors.New("invalid GPT header checksum")
+	}
+
+	entriesLBA := binary.LittleEndian.Uint64(hdr[gptEntriesLBA:])
+	entries := binary.LittleEndian.Uint32(hdr[gptEntries:])
+	entrySize := binary.LittleEndian.Uint32(hdr[gptEntrySize:])
+
+	// entry sizes are 128 multiplied by a power of 2
+	if entrySize < gptMinEntrySize || entrySize > gptMaxEntrySize || entrySize&(entrySize-1) != 0 {
+		return 0, 0, 0, fmt.Errorf("invalid GPT entry size %d", entrySize)
+	}
+
+	if entries == 0 || entries > gptMaxEntries {
+		return 0, 0, 0, fmt.Errorf("invalid GPT entries %d", entries)
+	}
+
+	if entriesLBA < 2 || entriesLBA > gptMaxEntriesLBA {
+		return 0, 0, 0, fmt.Errorf("invalid GPT entries LBA %d", entriesLBA)
+	}
+
+	return int64(entriesLBA), int(entries), int(entrySize), nil
+}
+
+func (part *Partition) gptPartUUID() (uuid string, err error) {
+	hdr, err := part.Card.Read(sectorSize, sectorSize)
+
+	if err != nil {
+		return
+	}
+
+	lba, n, size, err := gptHeader(hdr)
+
+	if err != nil {
+		return
+	}
+
+	entries, err := part.Card.Read(lba*sectorSize, int64(n*size))
+
+	if err != nil {
+		return
+	}
+
+	if len(entries) < n*size || crc32.ChecksumIEEE(entries[0:n*size]) != binary.LittleEndian.Uint32(hdr[gptEntriesCRC:]) {
+		return "", errors.New("invalid GPT entries checksum")
+	}
+
+	for i := 0; i < n; i++ {
+		entry := entries[i*size:]
+		first := binary.LittleEndian.Uint64(entry[32:])
+
+		if part.Offset%sectorSize != 0 || first != uint64(part.Offset/sectorSize) {
+			continue
+		}
+
+		g := entry[16:32]
+
+		return fmt.Sprintf("%08x-%04x-%04x-%x-%x",
+			binary.LittleEndian.Uint32(g[0:]),
+			binary.LittleEndian.Uint16(g[4:]),
+			binary.LittleEndian.Uint16(g[6:]),
+			g[8:10], g[10:16]), nil
+	}
+
+	return "", errors.New("partition not found")
+}
@@ -0,0 +1,153 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package disk
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"strings"
+	"testing"
+)
+
+const testOffset = 2048 * sectorSize
+
+type memCard []byte
+
+func (c memCard) Read(offset int64, size int64) ([]byte, error) {
+	if offset < 0 || size < 0 || offset+size > int64(len(c)) {
+		return nil, errors.New("out of bounds")
+	}
+
+	return c[offset : offset+size], nil
+}
+
+func (c memCard) Size() int64 {
+	return int64(len(c))
+}
+
+// gpt returns a disk image with a protective MBR and a GPT holding a single
+// partition at testOffset, the argument function can alter the image before
+// checksums are computed.
+func gpt(n uint32, size uint32, setup func(card memCard)) memCard {
+	card := make(memCard, 64*sectorSize)
+
+	mbr := card[0:sectorSize]
+	mbr[mbrPartitions+4] = mbrTypeGPT
+	binary.LittleEndian.PutUint16(mbr[mbrBootMagicAt:], mbrBootMagic)
+
+	hdr := card[sectorSize : 2*sectorSize]
+	copy(hdr, gptMagic)
+	binary.LittleEndian.PutUint32(hdr[0x0c:], gptHeaderSize)
+	binary.LittleEndian.PutUint64(hdr[gptEntriesLBA:], 2)
+	binary.LittleEndian.PutUint32(hdr[gptEntries:], n)
+	binary.LittleEndian.PutUint32(hdr[gptEntrySize:], size)
+
+	entry := card[2*sectorSize:]
+	copy(entry[16:32], []byte{0x78, 0x56, 0x34, 0x12, 0x34, 0x12, 0x78, 0x56, 0x9a, 0xbc, 0xde, 0xf0, 0x12, 0x34, 0x56, 0x78})
+	binary.LittleEndian.PutUint64(entry[32:], testOffset/sectorSize)
+
+	if setup != nil {
+		setup(card)
+	}
+
+	if total := int(n) * int(size); total <= len(entry) {
+		binary.LittleEndian.PutUint32(hdr[gptEntriesCRC:], crc32.ChecksumIEEE(entry[0:total]))
+	}
+
+	binary.LittleEndian.PutUint32(hdr[gptHeaderCRC:], crc32.ChecksumIEEE(hdr[0:gptHeaderSize]))
+
+	return card
+}
+
+func TestPartUUID(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		card    memCard
+		corrupt func(card memCard)
+		err     string
+	}{
+		{
+			name: "valid",
+			card: gpt(128, 128, nil),
+		},
+		{
+			name: "valid entry size",
+			card: gpt(32, 256, nil),
+		},
+		{
+			name:    "invalid magic",
+			card:    gpt(128, 128, nil),
+			corrupt: func(card memCard) { card[sectorSize] = 0 },
+			err:     "invalid GPT header",
+		},
+		{
+			name:    "invalid header checksum",
+			card:    gpt(128, 128, nil),
+			corrupt: func(card memCard) { card[sectorSize+gptEntries] = 127 },
+			err:     "invalid GPT header checksum",
+		},
+		{
+			name:    "invalid entries checksum",
+			card:    gpt(128, 128, nil),
+			corrupt: func(card memCard) { card[2*sectorSize+16] ^= 0xff },
+			err:     "invalid GPT entries checksum",
+		},
+		{
+			name: "invalid header size",
+			card: gpt(128, 128, func(card memCard) { binary.LittleEndian.PutUint32(card[sectorSize+0x0c:], 0xffffffff) }),
+			err:  "invalid GPT header size",
+		},
+		{
+			name: "invalid entry size",
+			card: gpt(128, 0x80000000, nil),
+			err:  "invalid GPT entry size",
+		},
+		{
+			name: "non power of 2 entry size",
+			card: gpt(128, 384, nil),
+			err:  "invalid GPT entry size",
+		},
+		{
+			name: "invalid entries",
+			card: gpt(0xffffffff, 128, nil),
+			err:  "invalid GPT entries",
+		},
+		{
+			name: "overflowing entries LBA",
+			card: gpt(128, 128, func(card memCard) { binary.LittleEndian.PutUint64(card[sectorSize+gptEntriesLBA:], 1<<62) }),
+			err:  "invalid GPT entries LBA",
+		},
+		{
+			name: "out of bounds entries",
+			card: gpt(128, 128, func(card memCard) { binary.LittleEndian.PutUint64(card[sectorSize+gptEntriesLBA:], 1<<40) }),
+			err:  "out of bounds",
+		},
+		{
+			name: "partition not found",
+			card: gpt(128, 128, func(card memCard) { card[2*sectorSize+33] = 0 }),
+			err:  "partition not found",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.corrupt != nil {
+				tt.corrupt(tt.card)
+			}
+
+			part := &Partition{Card: tt.card, Offset: testOffset}
+			uuid, err := part.PartUUID()
+
+			switch {
+			case len(tt.err) == 0 && err != nil:
+				t.Fatalf("unexpected error, %v", err)
+			case len(tt.err) > 0 && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Fatalf("expected error %q, got %v", tt.err, err)
+			case err == nil && uuid != "12345678-1234-5678-9abc-def012345678":
+				t.Fatalf("invalid PARTUUID %s", uuid)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/usbarmory/armory-boot/config"
+	"github.com/usbarmory/armory-boot/disk"
+)
+
+// decryptImages decrypts the encrypted kernel images, already authenticated as
+// ciphertext, with the device class key unwrapped through the hardware unique
+// key.
+func decryptImages(conf *config.Config, part *disk.Partition) (err error) {
+	if !conf.Encrypted() {
+		return
+	}
+
+	blob, err := part.ReadAll(config.DefaultClassKeyPath)
+
+	if err != nil {
+		return fmt.Errorf("could not read class key, %v", err)
+	}
+
+	key, err := config.UnwrapClassKey(blob, Class)
+
+	if err != nil {
+		return fmt.Errorf("invalid class key, %v", err)
+	}
+	defer clear(key)
+
+	return conf.Decrypt(key)
+}
@@ -11,6 +11,8 @@ import (
 	"errors"
 	"fmt"
 
+	"github.com/usbarmory/armory-boot/bootinfo"
+
 	"github.com/usbarmory/tamago/dma"
 )
 
@@ -20,8 +22,15 @@ type ELFImage struct {
 	Region *dma.Region
 	// ELF is a bootable bare-metal ELF image.
 	ELF []byte
-
-	entry uint
+	// BootInfo, when set, is the boot information passed to the image (see
+	// package bootinfo), its image and memory parameters are filled in on
+	// Load(). The image memory layout must exclude the boot information
+	// block, beyond its segments, as the block is otherwise overwritten by
+	// the image runtime before it can be read (see package bootinfo).
+	BootInfo *bootinfo.Info
+
+	entry  uint
+	params uint
 }
 
 // Load loads a bare-metal ELF image in memory.
@@ -39,6 +48,11 @@ func (image *ELFImage) Load() (err error) {
 		return
 	}
 
+	var segments []segment
+	var data [][]byte
+
+	start := image.Region.End()
+
 	for idx, prg := range f.Progs {
 		if prg.Type != elf.PT_LOAD {
 			continue
@@ -56,15 +70,54 @@ func (image *ELFImage) Load() (err error) {
 
 		off := uint(prg.Paddr) - image.Region.Start()
 
-		if off > image.Region.Size() {
-			return fmt.Errorf("incompatible memory layout (paddr:%x off:%x)", prg.Paddr, off)
+		segments = append(segments, segment{fmt.Sprintf("LOAD section %d", idx), off, uint(prg.Memsz)})
+		data = append(data, b)
+
+		start = min(start, uint(prg.Paddr))
+	}
+
+	if image.BootInfo != nil {
+		if bootinfo.Address < image.Region.Start() {
+			return errors.New("incompatible memory layout (boot information outside region)")
 		}
 
-		image.Region.Write(image.Region.Start(), int(off), b)
+		segments = append(segments, segment{"boot information", bootinfo.Address - image.Region.Start(), bootinfo.Size})
+	}
+
+	if err = checkLayout(image.Region, segments); err != nil {
+		return
+	}
+
+	for i, b := range data {
+		image.Region.Write(image.Region.Start(), int(segments[i].off), b)
 	}
 
 	image.entry = uint(f.Entry)
 
+	if image.BootInfo != nil {
+		return image.loadBootInfo(start)
+	}
+
+	return
+}
+
+func (image *ELFImage) loadBootInfo(start uint) (err error) {
+	image.BootInfo.Entry = uint32(image.entry)
+	image.BootInfo.LoadAddress = uint32(start)
+	image.BootInfo.MemoryStart = uint32(image.Region.Start())
+	image.BootInfo.MemorySize = uint32(image.Region.Size())
+
+	buf, err := image.BootInfo.MarshalBinary()
+
+	if err != nil {
+		return
+	}
+
+	// the block is passed at a fixed address, which the image must reserve
+	// in its own memory layout as only its ELF segments are checked here
+	image.Region.Write(image.Region.Start(), int(bootinfo.Address-image.Region.Start()), buf)
+	image.params = bootinfo.Address
+
 	return
 }
 
@@ -79,5 +132,5 @@ func (image *ELFImage) Boot(cleanup func()) (err error) {
 		return errors.New("Load() kernel before Boot()")
 	}
 
-	return boot(image.entry, 0, cleanup, image.Region)
+	return boot(image.entry, image.params, cleanup, image.Region)
 }
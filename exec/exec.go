@@ -11,9 +11,45 @@
 // https://github.com/usbarmory/tamago.
 package exec
 
+import (
+	"fmt"
+
+	"github.com/usbarmory/tamago/dma"
+)
+
 // BootImage represents a bootable image.
 type BootImage interface {
 	Load() error
 	Entry() uint
 	Boot(cleanup func()) error
 }
+
+// segment represents an image memory range, as offset from its region start.
+type segment struct {
+	name string
+	off  uint
+	size uint
+}
+
+// checkLayout ensures that all non-empty segments lie within the argument
+// region and that none of them overlap, it must be invoked before any segment
+// is written.
+func checkLayout(r *dma.Region, segments []segment) (err error) {
+	for i, s := range segments {
+		if s.size == 0 {
+			continue
+		}
+
+		if s.off > r.Size() || s.size > r.Size()-s.off {
+			return fmt.Errorf("incompatible memory layout (%s off:%#x size:%#x outside region)", s.name, s.off, s.size)
+		}
+
+		for _, t := range segments[:i] {
+			if t.size > 0 && s.off < t.off+t.size && t.off < s.off+s.size {
+				return fmt.Errorf("incompatible memory layout (%s overlaps %s)", s.name, t.name)
+			}
+		}
+	}
+
+	return
+}
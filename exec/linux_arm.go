@@ -31,6 +31,10 @@ type LinuxImage struct {
 	// DeviceTreeBlobOffset is the dtb offset from RAM start address.
 	DeviceTreeBlobOffset int
 
+	// Overlays are the device tree overlays (dtbo) applied, in order, to
+	// the Linux kernel dtb.
+	Overlays [][]byte
+
 	// InitialRamDisk is the Linux kernel initrd file.
 	InitialRamDisk []byte
 	// InitialRamDiskOffset is the initrd offset from RAM start address.
@@ -61,6 +65,28 @@ func (image *LinuxImage) updateDTB(fdt *dt.FDT) (err error) {
 	return
 }
 
+func (image *LinuxImage) fixupOverlays() (err error) {
+	fdt, err := image.fdt()
+
+	if err != nil {
+		return
+	}
+
+	for i, buf := range image.Overlays {
+		overlay, err := dt.ReadFDT(bytes.NewReader(buf))
+
+		if err != nil {
+			return fmt.Errorf("invalid overlay %d, %v", i, err)
+		}
+
+		if err = ApplyOverlay(fdt, overlay); err != nil {
+			return fmt.Errorf("overlay %d, %v", i, err)
+		}
+	}
+
+	return image.updateDTB(fdt)
+}
+
 func (image *LinuxImage) fixupBootArgs() (err error) {
 	fdt, err := image.fdt()
 
@@ -75,7 +101,7 @@ func (image *LinuxImage) fixupBootArgs() (err error) {
 				Value: []byte(image.CmdLine + "\x00"),
 			}
 
-			node.Properties = append(node.Properties, bootargs)
+			setProperty(node, bootargs)
 		}
 	}
 
@@ -107,8 +133,8 @@ func (image *LinuxImage) fixupInitrd(addr uint) (err error) {
 			binary.BigEndian.PutUint64(initrdStart.Value, uint64(start))
 			binary.BigEndian.PutUint64(initrdEnd.Value, uint64(end))
 
-			node.Properties = append(node.Properties, initrdStart)
-			node.Properties = append(node.Properties, initrdEnd)
+			setProperty(node, initrdStart)
+			setProperty(node, initrdEnd)
 		}
 	}
 
@@ -121,6 +147,16 @@ func (image *LinuxImage) Load() (err error) {
 		return errors.New("image memory Region must be assigned")
 	}
 
+	if len(image.Overlays) > 0 {
+		if len(image.DeviceTreeBlob) == 0 {
+			return errors.New("overlays require dtb")
+		}
+
+		if err = image.fixupOverlays(); err != nil {
+			return fmt.Errorf("overlay dtb fixup error, %v", err)
+		}
+	}
+
 	if len(image.CmdLine) > 0 {
 		if len(image.DeviceTreeBlob) == 0 {
 			return errors.New("cmdline requires dtb")
@@ -139,7 +175,20 @@ func (image *LinuxImage) Load() (err error) {
 		if err = image.fixupInitrd(image.Region.Start()); err != nil {
 			return fmt.Errorf("initrd dtb fixup error, %v", err)
 		}
+	}
+
+	// the dtb is checked after all fixups as they change its size
+	err = checkLayout(image.Region, []segment{
+		{"kernel", uint(image.KernelOffset), uint(len(image.Kernel))},
+		{"dtb", uint(image.DeviceTreeBlobOffset), uint(len(image.DeviceTreeBlob))},
+		{"initrd", uint(image.InitialRamDiskOffset), uint(len(image.InitialRamDisk))},
+	})
 
+	if err != nil {
+		return
+	}
+
+	if len(image.InitialRamDisk) > 0 {
 		image.Region.Write(image.Region.Start(), image.InitialRamDiskOffset, image.InitialRamDisk)
 	}
 
@@ -0,0 +1,400 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/dt"
+)
+
+// Device tree overlay special nodes
+const (
+	fixupsNode      = "__fixups__"
+	localFixupsNode = "__local_fixups__"
+	symbolsNode     = "__symbols__"
+	overlayNode     = "__overlay__"
+)
+
+func phandle(n *dt.Node) (uint32, bool) {
+	for _, name := range []string{"phandle", "linux,phandle"} {
+		if p, ok := n.LookProperty(name); ok {
+			if v, err := p.AsU32(); err == nil {
+				return v, true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+func maxPHandle(root *dt.Node) (h uint32) {
+	root.Walk(func(n *dt.Node) error {
+		if v, ok := phandle(n); ok && v > h {
+			h = v
+		}
+
+		return nil
+	})
+
+	return
+}
+
+// lookupPath returns the node at the argument absolute path.
+func lookupPath(root *dt.Node, path string) (n *dt.Node, err error) {
+	n = root
+
+	for _, name := range strings.Split(strings.Trim(path, "/"), "/") {
+		if len(name) == 0 {
+			continue
+		}
+
+		var ok bool
+
+		if n, ok = n.LookupChildByName(name); !ok {
+			return nil, fmt.Errorf("node %s not found", path)
+		}
+	}
+
+	return
+}
+
+// lookupPHandle returns the node with the argument phandle.
+func lookupPHandle(root *dt.Node, v uint32) (n *dt.Node, err error) {
+	n, ok := root.Find(func(n *dt.Node) bool {
+		h, ok := phandle(n)
+		return ok && h == v
+	})
+
+	if !ok {
+		return nil, fmt.Errorf("unresolved phandle %#x", v)
+	}
+
+	return
+}
+
+// symbol returns the phandle of the node referenced by a base tree
+// `__symbols__` label, assigning it one if missing.
+func symbol(root *dt.Node, overlay *dt.Node, label string) (v uint32, err error) {
+	symbols, ok := root.LookupChildByName(symbolsNode)
+
+	if !ok {
+		return 0, fmt.Errorf("unresolved symbol %s, base dtb lacks %s", label, symbolsNode)
+	}
+
+	p, ok := symbols.LookProperty(label)
+
+	if !ok {
+		return 0, fmt.Errorf("unresolved symbol %s", label)
+	}
+
+	path, err := p.AsString()
+
+	if err != nil {
+		return
+	}
+
+	n, err := lookupPath(root, path)
+
+	if err != nil {
+		return 0, fmt.Errorf("unresolved symbol %s, %v", label, err)
+	}
+
+	if v, ok = phandle(n); ok {
+		return
+	}
+
+	v = max(maxPHandle(root), maxPHandle(overlay)) + 1
+	n.Properties = append(n.Properties, dt.PropertyU32("phandle", v))
+
+	return
+}
+
+// setProperty sets a node property, replacing any existing one with the same
+// name.
+func setProperty(n *dt.Node, p dt.Property) {
+	for i := range n.Properties {
+		if n.Properties[i].Name == p.Name {
+			n.Properties[i] = p
+			return
+		}
+	}
+
+	n.Properties = append(n.Properties, p)
+}
+
+func writeU32(n *dt.Node, name string, off uint32, fn func(uint32) (uint32, error)) (err error) {
+	p, ok := n.LookProperty(name)
+
+	if !ok {
+		return fmt.Errorf("property %s not found", name)
+	}
+
+	if off%4 != 0 || int(off)+4 > len(p.Value) {
+		return fmt.Errorf("invalid property %s offset %d", name, off)
+	}
+
+	v, err := fn(binary.BigEndian.Uint32(p.Value[off:]))
+
+	if err != nil {
+		return
+	}
+
+	binary.BigEndian.PutUint32(p.Value[off:], v)
+
+	return
+}
+
+// adjustLocalFixups relocates the overlay phandles above the argument delta,
+// updating all local references listed in `__local_fixups__`.
+func adjustLocalFixups(overlay *dt.Node, delta uint32) (err error) {
+	overlay.Walk(func(n *dt.Node) error {
+		for i, p := range n.Properties {
+			if p.Name == "phandle" || p.Name == "linux,phandle" {
+				if v, err := p.AsU32(); err == nil {
+					n.Properties[i] = dt.PropertyU32(p.Name, v+delta)
+				}
+			}
+		}
+
+		return nil
+	})
+
+	fixups, ok := overlay.LookupChildByName(localFixupsNode)
+
+	if !ok {
+		return
+	}
+
+	return applyLocalFixups(overlay, fixups, delta)
+}
+
+func applyLocalFixups(n *dt.Node, fixups *dt.Node, delta uint32) (err error) {
+	for _, p := range fixups.Properties {
+		for i := 0; i+4 <= len(p.Value); i += 4 {
+			off := binary.BigEndian.Uint32(p.Value[i:])
+
+			err = writeU32(n, p.Name, off, func(v uint32) (uint32, error) {
+				return v + delta, nil
+			})
+
+			if err != nil {
+				return fmt.Errorf("%s: %v", localFixupsNode, err)
+			}
+		}
+	}
+
+	for _, f := range fixups.Children {
+		child, ok := n.LookupChildByName(f.Name)
+
+		if !ok {
+			return fmt.Errorf("%s: node %s not found", localFixupsNode, f.Name)
+		}
+
+		if err = applyLocalFixups(child, f, delta); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// applyFixups resolves the overlay references to base tree labels listed in
+// `__fixups__`.
+func applyFixups(base *dt.Node, overlay *dt.Node) (err error) {
+	fixups, ok := overlay.LookupChildByName(fixupsNode)
+
+	if !ok {
+		return
+	}
+
+	for _, p := range fixups.Properties {
+		v, err := symbol(base, overlay, p.Name)
+
+		if err != nil {
+			return err
+		}
+
+		if len(p.Value) == 0 || p.Value[len(p.Value)-1] != 0 {
+			return fmt.Errorf("%s: invalid %s", fixupsNode, p.Name)
+		}
+
+		for _, ref := range strings.Split(string(p.Value[:len(p.Value)-1]), "\x00") {
+			f := strings.Split(ref, ":")
+
+			if len(f) != 3 {
+				return fmt.Errorf("%s: invalid reference %s", fixupsNode, ref)
+			}
+
+			off, err := strconv.ParseUint(f[2], 10, 32)
+
+			if err != nil {
+				return fmt.Errorf("%s: invalid reference %s", fixupsNode, ref)
+			}
+
+			n, err := lookupPath(overlay, f[0])
+
+			if err != nil {
+				return fmt.Errorf("%s: %v", fixupsNode, err)
+			}
+
+			err = writeU32(n, f[1], uint32(off), func(uint32) (uint32, error) {
+				return v, nil
+			})
+
+			if err != nil {
+				return fmt.Errorf("%s: %v", fixupsNode, err)
+			}
+		}
+	}
+
+	return
+}
+
+// target returns the base tree node targeted by an overlay fragment.
+func target(base *dt.Node, fragment *dt.Node) (n *dt.Node, err error) {
+	if p, ok := fragment.LookProperty("target"); ok {
+		v, err := p.AsU32()
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid target, %v", err)
+		}
+
+		return lookupPHandle(base, v)
+	}
+
+	if p, ok := fragment.LookProperty("target-path"); ok {
+		path, err := p.AsString()
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid target-path, %v", err)
+		}
+
+		return lookupPath(base, path)
+	}
+
+	return nil, errors.New("missing target")
+}
+
+// merge recursively merges overlay node properties and children into the
+// base node.
+func merge(base *dt.Node, overlay *dt.Node) {
+	for _, p := range overlay.Properties {
+		base.UpdateProperty(p.Name, p.Value)
+	}
+
+	for _, c := range overlay.Children {
+		if n, ok := base.LookupChildByName(c.Name); ok {
+			merge(n, c)
+		} else {
+			base.Children = append(base.Children, c)
+		}
+	}
+}
+
+// mergeSymbols adds the overlay `__symbols__` labels to the base tree,
+// translating fragment paths to their target ones.
+func mergeSymbols(base *dt.Node, overlay *dt.Node, targets map[string]string) (err error) {
+	symbols, ok := overlay.LookupChildByName(symbolsNode)
+
+	if !ok {
+		return
+	}
+
+	baseSymbols, ok := base.LookupChildByName(symbolsNode)
+
+	if !ok {
+		return
+	}
+
+	for _, p := range symbols.Properties {
+		path, err := p.AsString()
+
+		if err != nil {
+			return fmt.Errorf("%s: invalid %s, %v", symbolsNode, p.Name, err)
+		}
+
+		f := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 3)
+
+		if len(f) < 2 || f[1] != overlayNode {
+			continue
+		}
+
+		t, ok := targets[f[0]]
+
+		if !ok {
+			return fmt.Errorf("%s: invalid %s fragment", symbolsNode, p.Name)
+		}
+
+		if len(f) == 3 {
+			t = strings.TrimSuffix(t, "/") + "/" + f[2]
+		}
+
+		baseSymbols.Update(dt.PropertyString(p.Name, t))
+	}
+
+	return
+}
+
+// nodePath returns the absolute path of a base tree node.
+func nodePath(root *dt.Node, n *dt.Node) (path string, ok bool) {
+	if root == n {
+		return "/", true
+	}
+
+	for _, c := range root.Children {
+		if p, ok := nodePath(c, n); ok {
+			return strings.TrimSuffix("/"+c.Name+p, "/"), true
+		}
+	}
+
+	return
+}
+
+// ApplyOverlay applies a compiled device tree overlay (dtbo) to a base device
+// tree, resolving overlay references to base tree labels (`__fixups__`) and
+// relocating overlay phandles (`__local_fixups__`).
+//
+// Overlay labels are added to the base tree `__symbols__` node, when
+// present, to allow further overlays to reference them.
+func ApplyOverlay(base *dt.FDT, overlay *dt.FDT) (err error) {
+	root := overlay.RootNode
+
+	if err = adjustLocalFixups(root, maxPHandle(base.RootNode)); err != nil {
+		return
+	}
+
+	if err = applyFixups(base.RootNode, root); err != nil {
+		return
+	}
+
+	targets := make(map[string]string)
+
+	for _, fragment := range root.Children {
+		o, ok := fragment.LookupChildByName(overlayNode)
+
+		if !ok {
+			continue
+		}
+
+		t, err := target(base.RootNode, fragment)
+
+		if err != nil {
+			return fmt.Errorf("%s: %v", fragment.Name, err)
+		}
+
+		path, _ := nodePath(base.RootNode, t)
+		targets[fragment.Name] = path
+
+		merge(t, o)
+	}
+
+	return mergeSymbols(base.RootNode, root, targets)
+}
@@ -5,6 +5,7 @@ go 1.26.1
 tool github.com/usbarmory/tamago/cmd/tamago
 
 require (
+	filippo.io/mldsa v0.0.0-20260215214346-43d0283efc3e
 	github.com/dsoprea/go-ext4 v0.0.0-20190528173430-c13b09fc0ff8
 	github.com/u-root/u-root v0.15.0
 	github.com/usbarmory/hid v0.0.0-20210318233634-85ced88a1ffe
@@ -0,0 +1,127 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"log"
+
+	"github.com/usbarmory/tamago/soc/nxp/imx6ul"
+	"github.com/usbarmory/tamago/soc/nxp/snvs"
+)
+
+// The HAB security configuration is held in the SEC_CONFIG[1] fuse of
+// OCOTP_CFG5, which is blown on closed (secure booted) parts
+// (37.5 OCOTP Memory Map/Register Definition, IMX6ULLRM).
+const (
+	secConfigBank = 0
+	secConfigWord = 6
+	secConfigBit  = 1
+)
+
+// The field return state is held in the FIELD_RETURN fuse of
+// OCOTP_FIELD_RETURN (bank 5 word 6, shadowed at 0x6E0[0]), which re-opens HAB
+// on closed parts returned for failure analysis (37.5 OCOTP Memory
+// Map/Register Definition, IMX6ULLRM).
+const (
+	fieldReturnBank = 5
+	fieldReturnWord = 6
+	fieldReturnBit  = 0
+)
+
+// HAB security states
+const (
+	habOpen = iota
+	habClosed
+	habFieldReturn
+)
+
+var habStates = map[int]string{
+	habOpen:        "open",
+	habClosed:      "closed",
+	habFieldReturn: "field return",
+}
+
+var ssmStates = map[uint8]string{
+	snvs.SSM_STATE_INIT:      "init",
+	snvs.SSM_STATE_HARD_FAIL: "hard fail",
+	snvs.SSM_STATE_SOFT_FAIL: "soft fail",
+	snvs.SSM_STATE_CHECK:     "check",
+	snvs.SSM_STATE_NONSECURE: "non-secure",
+	snvs.SSM_STATE_TRUSTED:   "trusted",
+	snvs.SSM_STATE_SECURE:    "secure",
+}
+
+// habState returns the HAB security state, along with the SNVS Security State
+// Machine (SSM) one.
+//
+// The state is derived from fuses only, a closed part is reported as field
+// returned only when its FIELD_RETURN fuse is positively read as blown,
+// regardless of the SSM state, so that any other closed part (including one
+// whose FIELD_RETURN fuse cannot be read) is always treated as closed.
+func habState() (state int, ssm uint8, err error) {
+	if !imx6ul.Native {
+		return habOpen, snvs.SSM_STATE_NONSECURE, nil
+	}
+
+	cfg5, err := imx6ul.OCOTP.Read(secConfigBank, secConfigWord)
+
+	if err != nil {
+		return
+	}
+
+	ssm = imx6ul.SNVS.Monitor().State
+
+	if (cfg5>>secConfigBit)&1 == 0 {
+		return habOpen, ssm, nil
+	}
+
+	fieldReturn, err := imx6ul.OCOTP.Read(fieldReturnBank, fieldReturnWord)
+
+	if err != nil {
+		log.Printf("armory-boot: could not read FIELD_RETURN fuse, %v", err)
+		return habClosed, ssm, nil
+	}
+
+	if (fieldReturn>>fieldReturnBit)&1 == 1 {
+		return habFieldReturn, ssm, nil
+	}
+
+	return habClosed, ssm, nil
+}
+
+// authenticated returns whether configuration authentication is enabled.
+func authenticated() bool {
+	return len(PublicKeyStr) > 0 || len(TUFRoot) > 0 || anchored()
+}
+
+// verifySecurityState derives the boot policy from the HAB security state,
+// closed parts must never boot unauthenticated configurations as this would
+// break the chain of trust, while open ones can do so with a warning.
+func verifySecurityState() (err error) {
+	state, ssm, err := habState()
+
+	if err != nil {
+		return
+	}
+
+	log.Printf("armory-boot: HAB %s, SSM %s", habStates[state], ssmStates[ssm])
+
+	if authenticated() {
+		return
+	}
+
+	if state == habClosed {
+		return errors.New("closed part requires configuration authentication, missing PUBLIC_KEY, TUF_ROOT or fused key digest")
+	}
+
+	log.Printf("armory-boot: ****************************************************")
+	log.Printf("armory-boot: WARNING: no public key, signature verification DISABLED")
+	log.Printf("armory-boot: WARNING: booting unauthenticated configuration on %s part", habStates[state])
+	log.Printf("armory-boot: ****************************************************")
+
+	return
+}
@@ -6,9 +6,11 @@
 package main
 
 import (
+	"crypto/sha256"
 	"fmt"
 	"log"
 
+	"github.com/usbarmory/armory-boot/bootinfo"
 	"github.com/usbarmory/armory-boot/config"
 	"github.com/usbarmory/armory-boot/disk"
 	"github.com/usbarmory/armory-boot/exec"
@@ -29,11 +31,42 @@ func init() {
 	}
 }
 
-func preLaunch() {
+// preLaunch runs right before jumping to the kernel image, refusing boot when
+// a violation is detected after the configuration has been loaded, unless the
+// restricted entry is already selected.
+func preLaunch(conf *config.Config) {
+	if conf.Slot != config.RestrictedEntry && tampered() {
+		panic("security violation detected before launch")
+	}
+
 	usbarmory.LED("blue", false)
 	usbarmory.LED("white", false)
 }
 
+func linuxImage(conf *config.Config) (image *exec.LinuxImage, err error) {
+	image = &exec.LinuxImage{
+		Region:         mem,
+		Kernel:         conf.Kernel(),
+		DeviceTreeBlob: conf.DeviceTreeBlob(),
+		InitialRamDisk: conf.InitialRamDisk(),
+		Overlays:       conf.DeviceTreeOverlays(),
+		CmdLine:        conf.CmdLine,
+	}
+
+	if image.KernelOffset, err = loadOffset(conf.KernelImage, kernelOffset, len(image.Kernel)); err != nil {
+		return
+	}
+
+	if image.DeviceTreeBlobOffset, err = loadOffset(conf.DeviceTreeBlobImage, paramsOffset, len(image.DeviceTreeBlob)); err != nil {
+		return
+	}
+
+	image.InitialRamDiskOffset, err = loadOffset(conf.InitialRamDiskImage, initrdOffset, len(image.InitialRamDisk))
+
+	// overlaps are checked on Load(), after the dtb is final
+	return
+}
+
 func main() {
 	var card *usdhc.USDHC
 
@@ -57,11 +90,32 @@ func main() {
 
 	usbarmory.LED("blue", true)
 
-	if len(PublicKeyStr) == 0 {
-		log.Printf("armory-boot: no public key, skipping signature verification")
+	if err = verifySecurityState(); err != nil {
+		panic(fmt.Sprintf("security state error, %v\n", err))
+	}
+
+	if len(Provision) > 0 {
+		if err = provision(card, part); err != nil {
+			panic(fmt.Sprintf("provisioning error, %v\n", err))
+		}
+
+		log.Printf("armory-boot: provisioning complete")
+		return
 	}
 
-	conf, err := config.Load(part, config.DefaultConfigPath, config.DefaultSignaturePath, PublicKeyStr)
+	rs, err := loadRollbackState(card)
+
+	if err != nil {
+		panic(fmt.Sprintf("rollback state error, %v\n", err))
+	}
+
+	restricted := tampered()
+
+	if restricted {
+		log.Printf("armory-boot: applying tamper policy")
+	}
+
+	conf, err := loadConfig(card, part, rs, restricted)
 
 	if err != nil {
 		panic(fmt.Sprintf("configuration error, %v\n", err))
@@ -69,6 +123,41 @@ func main() {
 
 	log.Printf("\n%s", conf.JSON)
 
+	if err = verifyPolicy(conf); err != nil {
+		panic(fmt.Sprintf("policy error, %v\n", err))
+	}
+
+	if err = verifyTransparency(conf, card, part, rs); err != nil {
+		panic(fmt.Sprintf("transparency error, %v\n", err))
+	}
+
+	if err = verifyVersion(conf); err != nil {
+		panic(fmt.Sprintf("rollback error, %v\n", err))
+	}
+
+	if err = enableMonitors(conf); err != nil {
+		panic(fmt.Sprintf("tamper monitor error, %v\n", err))
+	}
+
+	if err = expandCmdLine(conf, card, part); err != nil {
+		panic(fmt.Sprintf("cmdline error, %v\n", err))
+	}
+
+	if err = decryptImages(conf, part); err != nil {
+		panic(fmt.Sprintf("decryption error, %v\n", err))
+	}
+
+	measure("config", conf.JSON)
+	measure("kernel", conf.Kernel())
+	measure("dtb", conf.DeviceTreeBlob())
+	measure("initrd", conf.InitialRamDisk())
+
+	for i, overlay := range conf.DeviceTreeOverlays() {
+		measure(fmt.Sprintf("overlays.%d", i), overlay)
+	}
+
+	measure("cmdline", []byte(conf.CmdLine))
+
 	usbarmory.LED("white", true)
 
 	var image exec.BootImage
@@ -77,17 +166,18 @@ func main() {
 		image = &exec.ELFImage{
 			Region: mem,
 			ELF:    conf.Kernel(),
+			BootInfo: &bootinfo.Info{
+				BootDevice:   Boot,
+				ConfigDigest: sha256.Sum256(conf.JSON),
+				CmdLine:      conf.CmdLine,
+				Env:          conf.Env,
+			},
 		}
 	} else {
-		image = &exec.LinuxImage{
-			Region:               mem,
-			Kernel:               conf.Kernel(),
-			DeviceTreeBlob:       conf.DeviceTreeBlob(),
-			InitialRamDisk:       conf.InitialRamDisk(),
-			KernelOffset:         kernelOffset,
-			DeviceTreeBlobOffset: paramsOffset,
-			InitialRamDiskOffset: initrdOffset,
-			CmdLine:              conf.CmdLine,
+		image, err = linuxImage(conf)
+
+		if err != nil {
+			panic(fmt.Sprintf("load error, %v\n", err))
 		}
 	}
 
@@ -97,7 +187,7 @@ func main() {
 
 	log.Printf("armory-boot: starting kernel@%.8x\n", image.Entry())
 
-	if err = image.Boot(preLaunch); err != nil {
+	if err = image.Boot(func() { preLaunch(conf) }); err != nil {
 		panic(fmt.Sprintf("load error, %v\n", err))
 	}
 }
@@ -0,0 +1,31 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"log"
+)
+
+// measurementHooks are invoked, before kernel launch, with each boot
+// component as it is going to be executed or passed to the kernel.
+var measurementHooks = []func(name string, buf []byte){
+	logMeasurement,
+}
+
+func logMeasurement(name string, buf []byte) {
+	log.Printf("armory-boot: measured %s %x", name, sha256.Sum256(buf))
+}
+
+func measure(name string, buf []byte) {
+	if len(buf) == 0 {
+		return
+	}
+
+	for _, hook := range measurementHooks {
+		hook(name, buf)
+	}
+}
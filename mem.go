@@ -6,8 +6,11 @@
 package main
 
 import (
+	"fmt"
 	_ "unsafe"
 
+	"github.com/usbarmory/armory-boot/config"
+
 	"github.com/usbarmory/tamago/dma"
 )
 
@@ -45,3 +48,26 @@ func init() {
 	mem, _ = dma.NewRegion(memoryStart, memorySize, false)
 	mem.Reserve(memorySize, 0)
 }
+
+// loadOffset returns the offset, within the target kernel DMA region, of the
+// argument image load address or the default offset if unspecified, ensuring
+// that an image of the argument size fits within the region.
+func loadOffset(image *config.Image, def int, size int) (off int, err error) {
+	off = def
+
+	if image != nil && image.LoadAddress != 0 {
+		addr := uint32(image.LoadAddress)
+
+		if addr < memoryStart || addr >= memoryStart+memorySize {
+			return 0, fmt.Errorf("invalid load address %#.8x", addr)
+		}
+
+		off = int(addr - memoryStart)
+	}
+
+	if size > memorySize-off {
+		return 0, fmt.Errorf("image at %#.8x exceeds memory region (size:%d)", uint32(memoryStart)+uint32(off), size)
+	}
+
+	return
+}
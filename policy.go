@@ -0,0 +1,20 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/usbarmory/armory-boot/config"
+
+	"github.com/usbarmory/tamago/soc/nxp/imx6ul"
+)
+
+// verifyPolicy enforces the trusted comment policy, with the options set at
+// compile time, on the configuration file signature metadata.
+func verifyPolicy(conf *config.Config) (err error) {
+	return conf.VerifyPolicy(fmt.Sprintf("%x", imx6ul.UniqueID()), Class, CommentPolicy, now)
+}
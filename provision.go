@@ -0,0 +1,93 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+
+	"github.com/usbarmory/armory-boot/config"
+	"github.com/usbarmory/armory-boot/disk"
+	"github.com/usbarmory/armory-boot/rollback"
+
+	"github.com/usbarmory/tamago/soc/nxp/usdhc"
+)
+
+// provision initializes the persistent state required by the compile time
+// configuration, it runs in place of the boot process when `PROVISION` is
+// set at compile time.
+//
+// Valid state is never overwritten, so that provisioning cannot be used to
+// roll back persistent values.
+func provision(card *usdhc.USDHC, part *disk.Partition) (err error) {
+	off, err := rollbackOffset()
+
+	if err != nil {
+		return
+	}
+
+	dev := &device{card: card}
+
+	if _, err = rollback.Load(dev, off); err != nil {
+		log.Printf("armory-boot: initializing rollback state (%v)", err)
+
+		if err = (&rollback.State{}).Save(dev, off); err != nil {
+			return
+		}
+	}
+
+	return provisionClassKey(part)
+}
+
+// classKeyInputPath is the path of the unwrapped device class key, only read
+// in provisioning mode.
+const classKeyInputPath = config.DefaultClassKeyPath + ".key"
+
+// provisionClassKey wraps, with the hardware unique key, the device class key
+// found at classKeyInputPath, the resulting blob is printed base64 encoded as
+// the boot partition is read-only, and it must be stored at
+// config.DefaultClassKeyPath.
+//
+// A valid wrapped class key already stored on the device is never replaced.
+func provisionClassKey(part *disk.Partition) (err error) {
+	key, err := part.ReadAll(classKeyInputPath)
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("could not read class key, %v", err)
+	}
+	defer clear(key)
+
+	if blob, err := part.ReadAll(config.DefaultClassKeyPath); err == nil {
+		if k, err := config.UnwrapClassKey(blob, Class); err == nil {
+			clear(k)
+			log.Printf("armory-boot: valid wrapped class key already present, remove %s", classKeyInputPath)
+			return nil
+		}
+	}
+
+	blob, err := config.WrapClassKey(key, Class)
+
+	if err != nil {
+		return fmt.Errorf("could not wrap class key, %v", err)
+	}
+
+	k, err := config.UnwrapClassKey(blob, Class)
+
+	if err != nil {
+		return fmt.Errorf("could not unwrap class key, %v", err)
+	}
+	clear(k)
+
+	log.Printf("armory-boot: wrapped class key for %s (remove %s):", config.DefaultClassKeyPath, classKeyInputPath)
+	log.Printf("%s", base64.StdEncoding.EncodeToString(blob))
+
+	return
+}
@@ -0,0 +1,165 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/bits"
+	"strconv"
+	"time"
+
+	"github.com/usbarmory/armory-boot/config"
+	"github.com/usbarmory/armory-boot/rollback"
+
+	"github.com/usbarmory/tamago/soc/nxp/imx6ul"
+	"github.com/usbarmory/tamago/soc/nxp/usdhc"
+)
+
+// The minimum security version is held in the OCOTP_GP1 and OCOTP_GP2
+// general purpose fuses, used as a 64-bit thermometer counter where each
+// blown bit increments its value
+// (p2392, 37.5 OCOTP Memory Map/Register Definition, IMX6ULLRM).
+const (
+	rollbackBank  = 4
+	rollbackWord  = 6
+	rollbackWords = 2
+
+	maxSecurityVersion = rollbackWords * 32
+)
+
+func minSecurityVersion() (v uint32, err error) {
+	for i := 0; i < rollbackWords; i++ {
+		w, err := imx6ul.OCOTP.Read(rollbackBank, rollbackWord+i)
+
+		if err != nil {
+			return 0, err
+		}
+
+		v += uint32(bits.OnesCount32(w))
+	}
+
+	return
+}
+
+func setMinSecurityVersion(v uint32) (err error) {
+	if v > maxSecurityVersion {
+		return fmt.Errorf("security version exceeds maximum (%d)", maxSecurityVersion)
+	}
+
+	for i := 0; i < rollbackWords && v > 0; i++ {
+		n := min(v, 32)
+
+		if err = imx6ul.OCOTP.Blow(rollbackBank, rollbackWord+i, uint32(1<<n-1)); err != nil {
+			return
+		}
+
+		v -= n
+	}
+
+	return
+}
+
+// verifyVersion enforces anti-rollback protection by comparing the
+// configuration security version against the minimum one held in fuses, which
+// is raised when requested by an authenticated configuration.
+func verifyVersion(conf *config.Config) (err error) {
+	minVersion, err := minSecurityVersion()
+
+	if err != nil {
+		return fmt.Errorf("could not read minimum security version, %v", err)
+	}
+
+	if conf.SecurityVersion < minVersion {
+		return fmt.Errorf("security version %d is lower than minimum (%d)", conf.SecurityVersion, minVersion)
+	}
+
+	if conf.MinSecurityVersion <= minVersion {
+		return
+	}
+
+	if !authenticated() {
+		return errors.New("minimum security version update requires configuration authentication")
+	}
+
+	log.Printf("armory-boot: raising minimum security version to %d", conf.MinSecurityVersion)
+
+	return setMinSecurityVersion(conf.MinSecurityVersion)
+}
+
+func rollbackOffset() (off int64, err error) {
+	off = int64(rollback.DefaultOffset)
+
+	if len(RollbackState) > 0 {
+		if off, err = strconv.ParseInt(RollbackState, 10, 64); err != nil {
+			return 0, fmt.Errorf("invalid rollback state offset, %v", err)
+		}
+	}
+
+	return
+}
+
+func saveRollbackState(card *usdhc.USDHC, st *rollback.State) (err error) {
+	off, err := rollbackOffset()
+
+	if err != nil {
+		return
+	}
+
+	return st.Save(&device{card: card}, off)
+}
+
+// loadRollbackState reads the persistent rollback state, which is required
+// when configuration authentication or boot transparency are enabled and must
+// otherwise be initialized in provisioning mode (see provision()).
+func loadRollbackState(card *usdhc.USDHC) (st *rollback.State, err error) {
+	if !authenticated() && len(LogKey) == 0 {
+		return &rollback.State{}, nil
+	}
+
+	off, err := rollbackOffset()
+
+	if err != nil {
+		return
+	}
+
+	if st, err = rollback.Load(&device{card: card}, off); err != nil {
+		return nil, fmt.Errorf("invalid rollback state (%v), provisioning required", err)
+	}
+
+	return
+}
+
+// raiseTime raises the rollback state trusted time to the argument
+// authenticated time (e.g. a signature timestamp).
+//
+// The SNVS RTC time is never used, as it can be set by the booted operating
+// system, and raising the trusted time would permanently expire metadata and
+// retire revoked keys.
+func raiseTime(card *usdhc.USDHC, st *rollback.State, t time.Time) (err error) {
+	if !t.After(st.Time) {
+		return
+	}
+
+	st.Time = t
+
+	return saveRollbackState(card, st)
+}
+
+// raiseRevocation raises the minimum key revocation list sequence number,
+// held in the rollback state, to the one of the applied revocation list.
+func raiseRevocation(card *usdhc.USDHC, st *rollback.State, keys *config.Keys) (err error) {
+	if keys == nil || keys.RevocationSequence() <= st.Revocation {
+		return
+	}
+
+	log.Printf("armory-boot: raising minimum revocation list sequence to %d", keys.RevocationSequence())
+
+	st.Revocation = keys.RevocationSequence()
+
+	return saveRollbackState(card, st)
+}
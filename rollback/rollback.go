@@ -0,0 +1,158 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// Package rollback implements the armory-boot persistent anti-rollback state
+// format, holding values which must never decrease across boots.
+//
+// The rollback state is a single block, stored in a reserved sector range of
+// the boot media, which holds the sequence number of the last applied key
+// revocation list, the versions of the last verified TUF metadata, the tree
+// size of the last verified transparency log checkpoint and the latest
+// authenticated time observed by the bootloader.
+//
+// Unlike the A/B boot state, a missing or corrupted rollback state must never
+// be replaced with a default one, as this would reset the values it holds, it
+// must rather be initialized explicitly when provisioning the device.
+package rollback
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+
+	"github.com/usbarmory/armory-boot/tuf"
+)
+
+const (
+	// DefaultOffset is the default rollback state offset, it corresponds
+	// to the block preceding the default A/B boot state one.
+	DefaultOffset = 5242880 - 2*BlockSize
+	// BlockSize is the rollback state block size.
+	BlockSize = 512
+)
+
+const (
+	magic   = "ABRB"
+	version = 1
+)
+
+// State represents the persistent anti-rollback state.
+type State struct {
+	// Revocation is the sequence number of the last applied key
+	// revocation list, which is the minimum acceptable one.
+	Revocation uint64
+	// Time is the latest authenticated time observed (e.g. a signature
+	// timestamp), used as lower bound of the current time when the RTC is
+	// not valid.
+	Time time.Time
+	// TUF holds the versions of the last verified TUF metadata, which are
+	// the minimum acceptable ones.
+	TUF tuf.Versions
+	// TreeSize is the tree size of the last verified transparency log
+	// checkpoint, which is the minimum acceptable one.
+	TreeSize uint64
+}
+
+type block struct {
+	Magic      [4]byte
+	Version    uint32
+	Revocation uint64
+	Time       int64
+	TUF        tuf.Versions
+	TreeSize   uint64
+	Checksum   uint32
+}
+
+// checksumOffset is the offset of the block checksum, computed over all
+// preceding fields.
+const checksumOffset = 64
+
+// MarshalBinary implements the [encoding.BinaryMarshaler] interface.
+func (s *State) MarshalBinary() (data []byte, err error) {
+	b := &block{
+		Version:    version,
+		Revocation: s.Revocation,
+		TUF:        s.TUF,
+		TreeSize:   s.TreeSize,
+	}
+
+	copy(b.Magic[:], magic)
+
+	if !s.Time.IsZero() {
+		b.Time = s.Time.Unix()
+	}
+
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, b)
+
+	data = make([]byte, BlockSize)
+	copy(data, buf.Bytes())
+	binary.LittleEndian.PutUint32(data[checksumOffset:], crc32.ChecksumIEEE(data[0:checksumOffset]))
+
+	return
+}
+
+// UnmarshalBinary implements the [encoding.BinaryUnmarshaler] interface.
+func (s *State) UnmarshalBinary(data []byte) (err error) {
+	b := &block{}
+
+	if err = binary.Read(bytes.NewReader(data), binary.LittleEndian, b); err != nil {
+		return
+	}
+
+	if string(b.Magic[:]) != magic {
+		return errors.New("invalid rollback state magic")
+	}
+
+	if b.Version != version {
+		return fmt.Errorf("unsupported rollback state version %d", b.Version)
+	}
+
+	if b.Checksum != crc32.ChecksumIEEE(data[0:checksumOffset]) {
+		return errors.New("invalid rollback state checksum")
+	}
+
+	s.Revocation = b.Revocation
+	s.TUF = b.TUF
+	s.TreeSize = b.TreeSize
+	s.Time = time.Time{}
+
+	if b.Time != 0 {
+		s.Time = time.Unix(b.Time, 0)
+	}
+
+	return
+}
+
+// Load reads the rollback state at the argument offset.
+func Load(r io.ReaderAt, off int64) (s *State, err error) {
+	buf := make([]byte, BlockSize)
+
+	if _, err = r.ReadAt(buf, off); err != nil {
+		return
+	}
+
+	s = &State{}
+	err = s.UnmarshalBinary(buf)
+
+	return
+}
+
+// Save writes the rollback state at the argument offset.
+func (s *State) Save(w io.WriterAt, off int64) (err error) {
+	buf, err := s.MarshalBinary()
+
+	if err != nil {
+		return
+	}
+
+	_, err = w.WriteAt(buf, off)
+
+	return
+}
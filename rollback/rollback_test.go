@@ -0,0 +1,91 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package rollback
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/usbarmory/armory-boot/tuf"
+)
+
+func TestState(t *testing.T) {
+	st := &State{
+		Revocation: 7,
+		Time:       time.Unix(1760000000, 0),
+		TUF:        tuf.Versions{Root: 2, Timestamp: 9, Snapshot: 5, Targets: 3},
+		TreeSize:   1234,
+	}
+
+	for _, tt := range []struct {
+		name    string
+		state   *State
+		corrupt func(buf []byte)
+		err     string
+	}{
+		{
+			name:  "valid",
+			state: st,
+		},
+		{
+			name:  "empty",
+			state: &State{},
+		},
+		{
+			name:    "invalid magic",
+			state:   st,
+			corrupt: func(buf []byte) { buf[0] ^= 0xff },
+			err:     "invalid rollback state magic",
+		},
+		{
+			name:    "unsupported version",
+			state:   st,
+			corrupt: func(buf []byte) { buf[4] = 2 },
+			err:     "unsupported rollback state version 2",
+		},
+		{
+			name:    "invalid checksum",
+			state:   st,
+			corrupt: func(buf []byte) { buf[checksumOffset-1] ^= 0xff },
+			err:     "invalid rollback state checksum",
+		},
+		{
+			name:    "erased block",
+			state:   st,
+			corrupt: func(buf []byte) { clear(buf) },
+			err:     "invalid rollback state magic",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			buf, err := tt.state.MarshalBinary()
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(buf) != BlockSize {
+				t.Fatalf("invalid block size %d", len(buf))
+			}
+
+			if tt.corrupt != nil {
+				tt.corrupt(buf)
+			}
+
+			s := &State{}
+			err = s.UnmarshalBinary(buf)
+
+			switch {
+			case len(tt.err) == 0 && err != nil:
+				t.Fatalf("unexpected error, %v", err)
+			case len(tt.err) > 0 && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Fatalf("error %v, want %q", err, tt.err)
+			case err == nil && (s.Revocation != tt.state.Revocation || !s.Time.Equal(tt.state.Time) || s.TUF != tt.state.TUF || s.TreeSize != tt.state.TreeSize):
+				t.Fatalf("state mismatch %+v != %+v", s, tt.state)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/usbarmory/tamago/soc/nxp/imx6ul"
+)
+
+// SNVS Secure Real Time Counter registers
+// (p3180, 46.7 SNVS Memory Map/Register Definition, IMX6ULLRM).
+const (
+	SNVS_LPCR     = imx6ul.SNVS_HP_BASE + 0x38
+	LPCR_SRTC_ENV = 0
+
+	SNVS_LPSRTCMR = imx6ul.SNVS_HP_BASE + 0x50
+	SNVS_LPSRTCLR = imx6ul.SNVS_HP_BASE + 0x54
+
+	// the SRTC counts 32768 Hz clock ticks
+	srtcShift = 15
+)
+
+func read(addr uint32) uint32 {
+	var ptr unsafe.Pointer
+	return atomic.LoadUint32((*uint32)(unsafe.Add(ptr, addr)))
+}
+
+// now returns the SNVS Secure Real Time Counter time, which is only considered
+// valid when its counter is enabled and its value does not precede the
+// argument time (e.g. a signature timestamp).
+//
+// The counter value is retained only while the SNVS low power domain is
+// powered, and it is meant to be set by the booted operating system.
+func now(notBefore time.Time) (t time.Time, valid bool) {
+	if (read(SNVS_LPCR)>>LPCR_SRTC_ENV)&1 == 0 {
+		return
+	}
+
+	for {
+		mr := read(SNVS_LPSRTCMR)
+		lr := read(SNVS_LPSRTCLR)
+
+		// ensure a consistent read across registers
+		if mr == read(SNVS_LPSRTCMR) {
+			t = time.Unix(int64((uint64(mr&0x7fff)<<32|uint64(lr))>>srtcShift), 0)
+			break
+		}
+	}
+
+	return t, !t.Before(notBefore)
+}
@@ -0,0 +1,260 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// Package slot implements A/B boot slot selection, with boot counting and
+// automatic rollback, for the armory-boot boot state format.
+//
+// The boot state is a single block, stored in a reserved sector range of the
+// boot media, which holds the active slot, the number of remaining boot
+// attempts and whether the active slot has been marked as successfully booted.
+//
+// The bootloader decrements the remaining attempts before each unconfirmed
+// boot and falls back to the other slot once they are exhausted, the booted
+// operating system is responsible for marking a boot as successful (see
+// MarkSuccessful()). Once the attempts of both slots are exhausted no slot is
+// selected until either is switched to or marked as successful.
+package slot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// Boot slots
+const (
+	A = "a"
+	B = "b"
+)
+
+const (
+	// DefaultOffset is the default boot state offset, it corresponds to
+	// the last block before the default ext4 partition offset.
+	DefaultOffset = 5242880 - BlockSize
+	// DefaultTries is the default number of boot attempts for a slot
+	// which has not yet been marked as successful.
+	DefaultTries = 3
+	// BlockSize is the boot state block size.
+	BlockSize = 512
+)
+
+const (
+	magic          = "ABST"
+	version        = 1
+	checksumOffset = 12
+)
+
+// ErrExhausted is returned when the boot attempts of both slots are
+// exhausted.
+var ErrExhausted = errors.New("boot attempts of both slots exhausted")
+
+// State represents the persistent A/B boot state.
+type State struct {
+	// Active is the active boot slot.
+	Active string
+	// Tries is the number of remaining boot attempts for the active slot.
+	Tries int
+	// Successful indicates whether the active slot has been successfully
+	// booted.
+	Successful bool
+	// Exhausted indicates whether the active slot has been activated after
+	// the other one exhausted its boot attempts.
+	Exhausted bool
+}
+
+type block struct {
+	Magic      [4]byte
+	Version    uint8
+	Active     uint8
+	Tries      uint8
+	Successful uint8
+	Exhausted  uint8
+	_          [3]uint8
+	Checksum   uint32
+}
+
+// Other returns the opposite slot of the argument one.
+func Other(slot string) string {
+	if slot == B {
+		return A
+	}
+
+	return B
+}
+
+// Valid returns whether the argument is a valid slot name.
+func Valid(slot string) bool {
+	return slot == A || slot == B
+}
+
+// Default returns the initial boot state, assumed when no valid one is found
+// on the boot media.
+func Default() *State {
+	return &State{
+		Active: A,
+		Tries:  DefaultTries,
+	}
+}
+
+// MarshalBinary implements the [encoding.BinaryMarshaler] interface.
+func (s *State) MarshalBinary() (data []byte, err error) {
+	if !Valid(s.Active) {
+		return nil, fmt.Errorf("invalid slot %q", s.Active)
+	}
+
+	if s.Tries < 0 || s.Tries > 0xff {
+		return nil, fmt.Errorf("invalid tries %d", s.Tries)
+	}
+
+	b := &block{
+		Version: version,
+		Active:  s.Active[0],
+		Tries:   uint8(s.Tries),
+	}
+
+	copy(b.Magic[:], magic)
+
+	if s.Successful {
+		b.Successful = 1
+	}
+
+	if s.Exhausted {
+		b.Exhausted = 1
+	}
+
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, b)
+
+	data = make([]byte, BlockSize)
+	copy(data, buf.Bytes())
+	binary.LittleEndian.PutUint32(data[checksumOffset:], crc32.ChecksumIEEE(data[0:checksumOffset]))
+
+	return
+}
+
+// UnmarshalBinary implements the [encoding.BinaryUnmarshaler] interface.
+func (s *State) UnmarshalBinary(data []byte) (err error) {
+	b := &block{}
+
+	if err = binary.Read(bytes.NewReader(data), binary.LittleEndian, b); err != nil {
+		return
+	}
+
+	if string(b.Magic[:]) != magic {
+		return errors.New("invalid boot state magic")
+	}
+
+	if b.Version != version {
+		return fmt.Errorf("unsupported boot state version %d", b.Version)
+	}
+
+	if b.Checksum != crc32.ChecksumIEEE(data[0:checksumOffset]) {
+		return errors.New("invalid boot state checksum")
+	}
+
+	if !Valid(string(b.Active)) {
+		return fmt.Errorf("invalid slot %q", b.Active)
+	}
+
+	s.Active = string(b.Active)
+	s.Tries = int(b.Tries)
+	s.Successful = b.Successful == 1
+	s.Exhausted = b.Exhausted == 1
+
+	return
+}
+
+// Next returns the slot to be booted, updating the boot state to account for
+// the boot attempt. The active slot is booted until its attempts are
+// exhausted without being marked as successful, in which case the other slot
+// is activated (see Fallback()).
+func (s *State) Next() (slot string, err error) {
+	if s.Successful {
+		return s.Active, nil
+	}
+
+	if s.Tries == 0 {
+		if err = s.Fallback(); err != nil {
+			return
+		}
+	}
+
+	s.Tries -= 1
+
+	return s.Active, nil
+}
+
+// Fallback activates the other slot with the default number of boot attempts,
+// ErrExhausted is returned when the active slot has itself been activated by
+// a fallback.
+func (s *State) Fallback() (err error) {
+	if s.Exhausted {
+		s.Tries = 0
+		return ErrExhausted
+	}
+
+	s.Active = Other(s.Active)
+	s.Tries = DefaultTries
+	s.Successful = false
+	s.Exhausted = true
+
+	return
+}
+
+// MarkSuccessful marks the active slot as successfully booted.
+func (s *State) MarkSuccessful() {
+	s.Successful = true
+	s.Exhausted = false
+}
+
+// Switch activates the argument slot, which is booted for the argument
+// number of attempts before falling back to the other one unless marked as
+// successful.
+func (s *State) Switch(slot string, tries int) (err error) {
+	if !Valid(slot) {
+		return fmt.Errorf("invalid slot %q", slot)
+	}
+
+	if tries <= 0 || tries > 0xff {
+		return fmt.Errorf("invalid tries %d", tries)
+	}
+
+	s.Active = slot
+	s.Tries = tries
+	s.Successful = false
+	s.Exhausted = false
+
+	return
+}
+
+// Load reads the boot state at the argument offset.
+func Load(r io.ReaderAt, off int64) (s *State, err error) {
+	buf := make([]byte, BlockSize)
+
+	if _, err = r.ReadAt(buf, off); err != nil {
+		return
+	}
+
+	s = &State{}
+	err = s.UnmarshalBinary(buf)
+
+	return
+}
+
+// Save writes the boot state at the argument offset.
+func (s *State) Save(w io.WriterAt, off int64) (err error) {
+	buf, err := s.MarshalBinary()
+
+	if err != nil {
+		return
+	}
+
+	_, err = w.WriteAt(buf, off)
+
+	return
+}
@@ -0,0 +1,189 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package slot
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNext(t *testing.T) {
+	st := Default()
+
+	// slot a attempts
+	for i := DefaultTries - 1; i >= 0; i-- {
+		name, err := st.Next()
+
+		if err != nil || name != A || st.Tries != i {
+			t.Fatalf("unexpected slot %s (tries:%d), %v", name, st.Tries, err)
+		}
+	}
+
+	// fallback to slot b
+	for i := DefaultTries - 1; i >= 0; i-- {
+		name, err := st.Next()
+
+		if err != nil || name != B || st.Tries != i || !st.Exhausted {
+			t.Fatalf("unexpected slot %s (tries:%d), %v", name, st.Tries, err)
+		}
+	}
+
+	// both slots exhausted
+	for range 2 {
+		if _, err := st.Next(); !errors.Is(err, ErrExhausted) {
+			t.Fatalf("unexpected error, %v", err)
+		}
+	}
+
+	st.MarkSuccessful()
+
+	for range DefaultTries + 1 {
+		if name, err := st.Next(); err != nil || name != B {
+			t.Fatalf("unexpected slot %s, %v", name, err)
+		}
+	}
+}
+
+func TestFallback(t *testing.T) {
+	st := Default()
+	st.MarkSuccessful()
+
+	if err := st.Fallback(); err != nil {
+		t.Fatal(err)
+	}
+
+	if st.Active != B || st.Tries != DefaultTries || st.Successful || !st.Exhausted {
+		t.Fatalf("invalid state %+v", st)
+	}
+
+	if err := st.Fallback(); !errors.Is(err, ErrExhausted) {
+		t.Fatalf("unexpected error, %v", err)
+	}
+
+	if st.Active != B || st.Tries != 0 {
+		t.Fatalf("invalid state %+v", st)
+	}
+}
+
+func TestSwitch(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		slot  string
+		tries int
+		err   string
+	}{
+		{
+			name:  "valid",
+			slot:  B,
+			tries: 5,
+		},
+		{
+			name:  "invalid slot",
+			slot:  "c",
+			tries: 1,
+			err:   `invalid slot "c"`,
+		},
+		{
+			name: "zero tries",
+			slot: A,
+			err:  "invalid tries 0",
+		},
+		{
+			name:  "too many tries",
+			slot:  A,
+			tries: 0x100,
+			err:   "invalid tries 256",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			st := &State{Active: A, Successful: true, Exhausted: true}
+			err := st.Switch(tt.slot, tt.tries)
+
+			switch {
+			case len(tt.err) == 0 && err != nil:
+				t.Fatalf("unexpected error, %v", err)
+			case len(tt.err) > 0 && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Fatalf("expected error %q, got %v", tt.err, err)
+			case err == nil && (st.Active != tt.slot || st.Tries != tt.tries || st.Successful || st.Exhausted):
+				t.Fatalf("invalid state %+v", st)
+			}
+		})
+	}
+}
+
+func TestMarshalBinary(t *testing.T) {
+	st := &State{Active: B, Tries: 2, Exhausted: true}
+	buf, err := st.MarshalBinary()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(buf) != BlockSize {
+		t.Fatalf("invalid block size %d", len(buf))
+	}
+
+	for _, tt := range []struct {
+		name    string
+		corrupt func(buf []byte)
+		err     string
+	}{
+		{
+			name: "valid",
+		},
+		{
+			name:    "invalid magic",
+			corrupt: func(buf []byte) { buf[0] ^= 0xff },
+			err:     "invalid boot state magic",
+		},
+		{
+			name:    "unsupported version",
+			corrupt: func(buf []byte) { buf[4] = 2 },
+			err:     "unsupported boot state version 2",
+		},
+		{
+			name:    "invalid checksum",
+			corrupt: func(buf []byte) { buf[6] ^= 0xff },
+			err:     "invalid boot state checksum",
+		},
+		{
+			name:    "erased block",
+			corrupt: func(buf []byte) { clear(buf) },
+			err:     "invalid boot state magic",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			data := append([]byte{}, buf...)
+
+			if tt.corrupt != nil {
+				tt.corrupt(data)
+			}
+
+			s := &State{}
+			err := s.UnmarshalBinary(data)
+
+			switch {
+			case len(tt.err) == 0 && err != nil:
+				t.Fatalf("unexpected error, %v", err)
+			case len(tt.err) > 0 && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Fatalf("expected error %q, got %v", tt.err, err)
+			case err == nil && *s != *st:
+				t.Fatalf("state mismatch %+v != %+v", s, st)
+			}
+		})
+	}
+
+	for _, s := range []*State{
+		{Active: "c"},
+		{Active: A, Tries: -1},
+		{Active: A, Tries: 0x100},
+	} {
+		if _, err := s.MarshalBinary(); err == nil {
+			t.Fatalf("expected error for %+v", s)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/usbarmory/armory-boot/config"
+	"github.com/usbarmory/armory-boot/disk"
+	"github.com/usbarmory/armory-boot/rollback"
+	"github.com/usbarmory/armory-boot/slot"
+
+	"github.com/usbarmory/tamago/soc/nxp/usdhc"
+)
+
+// device implements raw block access to the boot media for boot state
+// persistence.
+type device struct {
+	card *usdhc.USDHC
+}
+
+func (d *device) ReadAt(p []byte, off int64) (n int, err error) {
+	buf, err := d.card.Read(off, int64(len(p)))
+
+	if err != nil {
+		return
+	}
+
+	return copy(p, buf), nil
+}
+
+func (d *device) WriteAt(p []byte, off int64) (n int, err error) {
+	blockSize := int64(d.card.Info().BlockSize)
+
+	if blockSize == 0 || off%blockSize != 0 || int64(len(p))%blockSize != 0 {
+		return 0, errors.New("unaligned write")
+	}
+
+	if err = d.card.WriteBlocks(int(off/blockSize), p); err != nil {
+		return
+	}
+
+	return len(p), nil
+}
+
+// publicKeys returns the trusted keys, including the post-quantum ones which
+// enable hybrid signature verification. When a public key digest is held in
+// fuses only the matching public key is trusted (see anchoredKey()).
+func publicKeys(part *disk.Partition) (s string, err error) {
+	digest, set, err := keyDigest()
+
+	if err != nil {
+		return "", fmt.Errorf("could not read public key digest, %v", err)
+	}
+
+	s = PublicKeyStr
+
+	if set {
+		if s, err = anchoredKey(part, digest); err != nil {
+			return
+		}
+	}
+
+	if len(PQPublicKeyStr) > 0 {
+		s += "," + PQPublicKeyStr
+	}
+
+	return
+}
+
+// trustedKeys returns the trusted keys, with the signature threshold set at
+// compile time, or nil when no key is set.
+func trustedKeys(part *disk.Partition) (keys *config.Keys, err error) {
+	s, err := publicKeys(part)
+
+	if err != nil || len(s) == 0 {
+		return
+	}
+
+	if keys, err = config.NewKeys(s); err != nil {
+		return nil, fmt.Errorf("invalid public key, %v", err)
+	}
+
+	if len(Threshold) == 0 {
+		return
+	}
+
+	k, err := strconv.Atoi(Threshold)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature threshold, %v", err)
+	}
+
+	if err = keys.SetThreshold(k); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// loadConfig reads the armory-boot configuration, A/B slot configurations are
+// selected according to the boot state, which is updated to account for each
+// boot attempt, unless the restricted entry is requested. Once the attempts of
+// both slots are exhausted the restricted entry is booted, when allowed by the
+// configuration tamper policy, otherwise boot is refused. The configuration is
+// authenticated through TUF targets metadata, when a trusted root is set,
+// rather than its signature.
+//
+// The key revocation list and TUF metadata versions are enforced according to
+// the rollback state, which is raised once newer ones are verified, along with
+// its trusted time to the configuration signature timestamp.
+func loadConfig(card *usdhc.USDHC, part *disk.Partition, rs *rollback.State, restricted bool) (conf *config.Config, err error) {
+	var keys *config.Keys
+	var targets config.Targets
+
+	if len(TUFRoot) > 0 {
+		repo, err := loadTargets(card, part, rs)
+
+		if err != nil {
+			return nil, fmt.Errorf("TUF error, %v", err)
+		}
+
+		targets = repo
+	} else if keys, err = trustedKeys(part); err != nil {
+		return
+	}
+
+	if keys != nil {
+		keys.SetRevocationPolicy(rs.Revocation, rs.Time)
+	}
+
+	load := func(name string) (c *config.Config, err error) {
+		if targets != nil {
+			return config.LoadTargets(part, config.DefaultConfigPath, targets, name)
+		}
+
+		c, err = config.LoadKeys(part, config.DefaultConfigPath, config.DefaultSignaturePath, keys, name)
+
+		if rerr := raiseRevocation(card, rs, keys); rerr != nil {
+			return nil, fmt.Errorf("rollback state error, %v", rerr)
+		}
+
+		if err != nil || keys == nil || c.Metadata == nil {
+			return
+		}
+
+		if rerr := raiseTime(card, rs, c.Metadata.Timestamp); rerr != nil {
+			return nil, fmt.Errorf("rollback state error, %v", rerr)
+		}
+
+		return
+	}
+
+	if restricted {
+		return load(config.RestrictedEntry)
+	}
+
+	off := int64(slot.DefaultOffset)
+
+	if len(State) > 0 {
+		if off, err = strconv.ParseInt(State, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid boot state offset, %v", err)
+		}
+	}
+
+	dev := &device{card: card}
+	st, err := slot.Load(dev, off)
+
+	if err != nil {
+		log.Printf("armory-boot: resetting boot state, %v", err)
+		st = slot.Default()
+	}
+
+	// at most one fallback is attempted within a single boot
+	for range 2 {
+		name, serr := st.Next()
+
+		if serr != nil {
+			return exhausted(st, dev, off, load)
+		}
+
+		if conf, err = load(name); conf == nil || len(conf.Slots) == 0 {
+			return
+		}
+
+		if serr = st.Save(dev, off); serr != nil {
+			return nil, fmt.Errorf("boot state error, %v", serr)
+		}
+
+		if err == nil {
+			log.Printf("armory-boot: slot %s (tries:%d successful:%v)", name, st.Tries, st.Successful)
+			return
+		}
+
+		log.Printf("armory-boot: slot %s error, %v", name, err)
+
+		if serr = st.Fallback(); serr != nil {
+			return exhausted(st, dev, off, load)
+		}
+	}
+
+	return
+}
+
+// exhausted persists the boot state once the attempts of both slots are
+// exhausted, loading the restricted entry in place of either slot.
+func exhausted(st *slot.State, dev *device, off int64, load func(string) (*config.Config, error)) (conf *config.Config, err error) {
+	log.Printf("armory-boot: %v", slot.ErrExhausted)
+
+	if err = st.Save(dev, off); err != nil {
+		return nil, fmt.Errorf("boot state error, %v", err)
+	}
+
+	if conf, err = load(config.RestrictedEntry); errors.Is(err, config.ErrTamper) {
+		return nil, slot.ErrExhausted
+	}
+
+	return
+}
@@ -0,0 +1,161 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/usbarmory/armory-boot/config"
+
+	"github.com/usbarmory/tamago/soc/nxp/imx6ul"
+	"github.com/usbarmory/tamago/soc/nxp/snvs"
+)
+
+// Debug configuration fuses of OCOTP_CFG5
+// (37.5 OCOTP Memory Map/Register Definition, IMX6ULLRM).
+const (
+	CFG5_SJC_DISABLE = 20
+	CFG5_JTAG_SMODE  = 22
+
+	JTAG_SMODE_NO_DEBUG = 0b11
+)
+
+// SNVS tamper detectors control register
+// (p3180, 46.7 SNVS Memory Map/Register Definition, IMX6ULLRM).
+const (
+	SNVS_LPTDCR  = imx6ul.SNVS_HP_BASE + 0x48
+	LPTDCR_VT_EN = 6
+	LPTDCR_TT_EN = 5
+	LPTDCR_CT_EN = 4
+)
+
+// violations returns the latched tamper and security violations, as well as
+// debug features left enabled by fuses on closed parts.
+//
+// Power glitch records are not considered, as these are set at each SNVS
+// power up.
+func violations() (v []string, err error) {
+	if !imx6ul.Native {
+		return
+	}
+
+	state, ssm, err := habState()
+
+	if err != nil {
+		return
+	}
+
+	switch ssm {
+	case snvs.SSM_STATE_SOFT_FAIL, snvs.SSM_STATE_HARD_FAIL:
+		v = append(v, "SSM "+ssmStates[ssm])
+	}
+
+	sp := imx6ul.SNVS.Monitor()
+
+	if sp.Clock {
+		v = append(v, "clock tamper")
+	}
+
+	if sp.Temperature {
+		v = append(v, "temperature tamper")
+	}
+
+	if sp.Voltage {
+		v = append(v, "voltage tamper")
+	}
+
+	if state != habClosed {
+		return
+	}
+
+	cfg5, err := imx6ul.OCOTP.Read(secConfigBank, secConfigWord)
+
+	if err != nil {
+		return
+	}
+
+	if (cfg5>>CFG5_SJC_DISABLE)&1 == 0 && (cfg5>>CFG5_JTAG_SMODE)&0b11 != JTAG_SMODE_NO_DEBUG {
+		v = append(v, "JTAG not disabled by fuses")
+	}
+
+	return
+}
+
+// tampered returns whether any violation is detected (see violations()),
+// errors are treated as violations.
+func tampered() bool {
+	v, err := violations()
+
+	if err != nil {
+		log.Printf("armory-boot: could not read security state, %v", err)
+		return true
+	}
+
+	for _, s := range v {
+		log.Printf("armory-boot: security violation, %s", s)
+	}
+
+	return len(v) > 0
+}
+
+// monitors returns the enabled SNVS tamper monitors.
+func monitors() snvs.SecurityPolicy {
+	lptdcr := read(SNVS_LPTDCR)
+
+	return snvs.SecurityPolicy{
+		Clock:       (lptdcr>>LPTDCR_CT_EN)&1 == 1,
+		Temperature: (lptdcr>>LPTDCR_TT_EN)&1 == 1,
+		Voltage:     (lptdcr>>LPTDCR_VT_EN)&1 == 1,
+	}
+}
+
+// enableMonitors enables the SNVS tamper monitors required by the
+// configuration, as they are otherwise disabled and their violations never
+// recorded.
+//
+// The monitors are retained while the SNVS low power domain is powered,
+// therefore the policy is only applied when any required monitor is disabled,
+// as doing so clears the tamper records. Monitors already enabled are never
+// disabled.
+func enableMonitors(conf *config.Config) (err error) {
+	if len(conf.Monitors) == 0 || !imx6ul.Native {
+		return
+	}
+
+	if !authenticated() {
+		return errors.New("tamper monitors require configuration authentication")
+	}
+
+	enabled := monitors()
+	sp := enabled
+
+	for _, m := range conf.Monitors {
+		switch m {
+		case config.MonitorClock:
+			sp.Clock = true
+		case config.MonitorTemperature:
+			sp.Temperature = true
+		case config.MonitorVoltage:
+			sp.Voltage = true
+		}
+	}
+
+	if sp == enabled {
+		return
+	}
+
+	log.Printf("armory-boot: enabling tamper monitors %v", conf.Monitors)
+
+	imx6ul.SNVS.SetPolicy(sp)
+
+	if enabled = monitors(); enabled != sp {
+		return fmt.Errorf("could not enable tamper monitors (clock:%v temperature:%v voltage:%v)", enabled.Clock, enabled.Temperature, enabled.Voltage)
+	}
+
+	return
+}
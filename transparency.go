@@ -0,0 +1,137 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/usbarmory/armory-boot/config"
+	"github.com/usbarmory/armory-boot/disk"
+	"github.com/usbarmory/armory-boot/rollback"
+	"github.com/usbarmory/armory-boot/transparency"
+
+	"github.com/usbarmory/tamago/soc/nxp/usdhc"
+)
+
+// logged represents a file whose transparency log inclusion is verified.
+type logged struct {
+	path string
+	buf  []byte
+}
+
+func loggedFiles(conf *config.Config) (files []logged) {
+	files = []logged{{config.DefaultConfigPath, conf.JSON}}
+
+	// kernel images are pinned by the configuration digests, unless
+	// detached signatures are used
+	if !conf.Detached {
+		return
+	}
+
+	if conf.ELF {
+		return append(files, logged{conf.UnikernelImage.Path, conf.Kernel()})
+	}
+
+	files = append(files, logged{conf.KernelImage.Path, conf.Kernel()})
+	files = append(files, logged{conf.DeviceTreeBlobImage.Path, conf.DeviceTreeBlob()})
+
+	if conf.InitialRamDiskImage != nil {
+		files = append(files, logged{conf.InitialRamDiskImage.Path, conf.InitialRamDisk()})
+	}
+
+	for i, overlay := range conf.DeviceTreeOverlays() {
+		files = append(files, logged{conf.DeviceTreeOverlayImages[i].Path, overlay})
+	}
+
+	return
+}
+
+func transparencyPolicy() (policy *transparency.Policy, err error) {
+	policy = &transparency.Policy{}
+
+	if policy.Log, err = transparency.NewVerifier(LogKey); err != nil {
+		return nil, fmt.Errorf("invalid log key, %v", err)
+	}
+
+	if policy.Witnesses, err = transparency.NewVerifiers(WitnessKeys); err != nil {
+		return nil, fmt.Errorf("invalid witness key, %v", err)
+	}
+
+	policy.Quorum = len(policy.Witnesses)
+
+	if len(WitnessQuorum) > 0 {
+		if policy.Quorum, err = strconv.Atoi(WitnessQuorum); err != nil {
+			return nil, fmt.Errorf("invalid witness quorum, %v", err)
+		}
+	}
+
+	if policy.Quorum < 0 || policy.Quorum > len(policy.Witnesses) {
+		return nil, fmt.Errorf("invalid witness quorum %d", policy.Quorum)
+	}
+
+	return
+}
+
+// verifyTransparency verifies the transparency log inclusion of the
+// configuration file and, when detached signatures are used, of each kernel
+// image, raising the minimum checkpoint tree size, held in the rollback state,
+// to the largest verified one, along with its trusted time to the latest time
+// attested by the witness quorum.
+func verifyTransparency(conf *config.Config, card *usdhc.USDHC, part *disk.Partition, rs *rollback.State) (err error) {
+	var t time.Time
+
+	if len(LogKey) == 0 {
+		return
+	}
+
+	policy, err := transparencyPolicy()
+
+	if err != nil {
+		return
+	}
+
+	policy.MinSize = rs.TreeSize
+	size := rs.TreeSize
+
+	for _, f := range loggedFiles(conf) {
+		proof, err := part.ReadAll(f.path + transparency.ProofSuffix)
+
+		if err != nil {
+			return fmt.Errorf("invalid %s proof path, %v", f.path, err)
+		}
+
+		c, err := policy.Verify(proof, f.buf)
+
+		if err != nil {
+			return fmt.Errorf("invalid %s proof, %v", f.path, err)
+		}
+
+		log.Printf("armory-boot: %s logged in %s (tree size %d)", f.path, c.Origin, c.Size)
+
+		size = max(size, c.Size)
+
+		if c.Time.After(t) {
+			t = c.Time
+		}
+	}
+
+	if size > rs.TreeSize {
+		rs.TreeSize = size
+
+		if err = saveRollbackState(card, rs); err != nil {
+			return fmt.Errorf("rollback state error, %v", err)
+		}
+	}
+
+	if err = raiseTime(card, rs, t); err != nil {
+		return fmt.Errorf("rollback state error, %v", err)
+	}
+
+	return
+}
@@ -0,0 +1,71 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package transparency
+
+import (
+	"crypto/sha256"
+	"errors"
+)
+
+// HashSize is the Merkle tree hash size.
+const HashSize = sha256.Size
+
+// LeafHash returns the RFC 9162 Merkle tree hash of a leaf entry.
+func LeafHash(data []byte) (h [HashSize]byte) {
+	return sha256.Sum256(append([]byte{0x00}, data...))
+}
+
+func nodeHash(left []byte, right []byte) (h [HashSize]byte) {
+	buf := make([]byte, 0, 1+2*HashSize)
+	buf = append(buf, 0x01)
+	buf = append(buf, left...)
+	buf = append(buf, right...)
+
+	return sha256.Sum256(buf)
+}
+
+// VerifyInclusion verifies an RFC 9162 (section 2.1.3.2) inclusion proof for
+// the leaf at the argument index, in a tree of the argument size and root
+// hash.
+func VerifyInclusion(index uint64, size uint64, leaf [HashSize]byte, proof [][HashSize]byte, root [HashSize]byte) (err error) {
+	if index >= size {
+		return errors.New("invalid leaf index")
+	}
+
+	fn := index
+	sn := size - 1
+	r := leaf
+
+	for _, p := range proof {
+		if sn == 0 {
+			return errors.New("invalid proof size")
+		}
+
+		if fn&1 == 1 || fn == sn {
+			r = nodeHash(p[:], r[:])
+
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = nodeHash(r[:], p[:])
+		}
+
+		fn >>= 1
+		sn >>= 1
+	}
+
+	if sn != 0 {
+		return errors.New("invalid proof size")
+	}
+
+	if r != root {
+		return errors.New("root hash mismatch")
+	}
+
+	return
+}
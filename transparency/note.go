@@ -0,0 +1,211 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// Package transparency implements offline verification of transparency log
+// inclusion proofs, for the armory-boot boot-transparency support.
+//
+// Proofs are encoded in the c2sp.org/tlog-proof format, consisting of a Merkle
+// tree inclusion proof (RFC 9162) and the checkpoint (c2sp.org/tlog-checkpoint)
+// it refers to. Checkpoints are signed notes (c2sp.org/signed-note) which must
+// be signed by the trusted log key and, optionally, cosigned
+// (c2sp.org/tlog-cosignature) by a quorum of trusted witnesses.
+//
+// Rollback to checkpoints older than the last verified one is prevented by
+// setting the minimum acceptable tree size (see Policy.MinSize).
+package transparency
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Signature algorithms
+const (
+	// AlgEd25519 identifies Ed25519 signed note signatures, used by logs.
+	AlgEd25519 = 0x01
+	// AlgCosignature identifies c2sp.org/tlog-cosignature Ed25519
+	// signatures, used by witnesses.
+	AlgCosignature = 0x04
+)
+
+const (
+	sigPrefix         = "— "
+	cosignaturePrefix = "cosignature/v1\ntime "
+	maxSignatures     = 100
+)
+
+// Verifier represents a signed note verifier key.
+type Verifier struct {
+	// Name is the key name.
+	Name string
+
+	hash uint32
+	alg  byte
+	key  ed25519.PublicKey
+}
+
+// Signature represents a signed note signature.
+type Signature struct {
+	// Name is the key name.
+	Name string
+	// Hash is the key hash.
+	Hash uint32
+	// Timestamp is the cosignature time, in seconds since the Unix epoch.
+	Timestamp uint64
+
+	sig []byte
+}
+
+func validName(name string) bool {
+	return len(name) > 0 && !strings.ContainsFunc(name, func(r rune) bool {
+		return r == '+' || unicode.IsSpace(r)
+	})
+}
+
+func keyHash(name string, key []byte) uint32 {
+	h := sha256.New()
+	h.Write([]byte(name))
+	h.Write([]byte("\n"))
+	h.Write(key)
+
+	return binary.BigEndian.Uint32(h.Sum(nil))
+}
+
+// NewVerifier parses a verifier key, in the `<name>+<hash>+<key>` encoding
+// used by signed notes (e.g. `example.com/log+01234567+AWd...`), only Ed25519
+// and Ed25519 cosignature keys are supported.
+func NewVerifier(vkey string) (v *Verifier, err error) {
+	name, vkey, _ := strings.Cut(vkey, "+")
+	h, b64, found := strings.Cut(vkey, "+")
+
+	if !validName(name) || len(h) != 8 || !found {
+		return nil, errors.New("invalid verifier key format")
+	}
+
+	hash, err := hex.DecodeString(h)
+
+	if err != nil {
+		return nil, errors.New("invalid verifier key hash")
+	}
+
+	key, err := base64.StdEncoding.DecodeString(b64)
+
+	if err != nil || len(key) != 1+ed25519.PublicKeySize {
+		return nil, errors.New("invalid verifier key")
+	}
+
+	v = &Verifier{
+		Name: name,
+		hash: binary.BigEndian.Uint32(hash),
+		alg:  key[0],
+		key:  ed25519.PublicKey(key[1:]),
+	}
+
+	if v.alg != AlgEd25519 && v.alg != AlgCosignature {
+		return nil, fmt.Errorf("unsupported verifier key algorithm %#x", v.alg)
+	}
+
+	if keyHash(name, key) != v.hash {
+		return nil, errors.New("verifier key hash mismatch")
+	}
+
+	return
+}
+
+// NewVerifiers parses verifier keys separated by commas or whitespace.
+func NewVerifiers(s string) (verifiers []*Verifier, err error) {
+	for _, vkey := range strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	}) {
+		v, err := NewVerifier(vkey)
+
+		if err != nil {
+			return nil, err
+		}
+
+		verifiers = append(verifiers, v)
+	}
+
+	return
+}
+
+// Verify authenticates a signed note signature over the argument note text.
+func (v *Verifier) Verify(text []byte, s *Signature) bool {
+	if s.Name != v.Name || s.Hash != v.hash {
+		return false
+	}
+
+	switch v.alg {
+	case AlgEd25519:
+		return ed25519.Verify(v.key, text, s.sig)
+	case AlgCosignature:
+		msg := []byte(cosignaturePrefix + strconv.FormatUint(s.Timestamp, 10) + "\n")
+		return ed25519.Verify(v.key, append(msg, text...), s.sig)
+	}
+
+	return false
+}
+
+// ParseNote splits a signed note in its text, which includes its final
+// newline, and signatures. Signatures of unknown size are ignored.
+func ParseNote(buf []byte) (text []byte, sigs []*Signature, err error) {
+	i := bytes.LastIndex(buf, []byte("\n\n"))
+
+	if i < 0 || !bytes.HasSuffix(buf, []byte("\n")) {
+		return nil, nil, errors.New("malformed note")
+	}
+
+	text = buf[:i+1]
+
+	for _, line := range strings.Split(string(buf[i+2:len(buf)-1]), "\n") {
+		if !strings.HasPrefix(line, sigPrefix) {
+			return nil, nil, errors.New("malformed note signature")
+		}
+
+		name, b64, found := strings.Cut(strings.TrimPrefix(line, sigPrefix), " ")
+
+		if !found || !validName(name) {
+			return nil, nil, errors.New("malformed note signature")
+		}
+
+		sig, err := base64.StdEncoding.DecodeString(b64)
+
+		if err != nil || len(sig) < 4 {
+			return nil, nil, errors.New("malformed note signature")
+		}
+
+		s := &Signature{
+			Name: name,
+			Hash: binary.BigEndian.Uint32(sig),
+		}
+
+		switch len(sig) {
+		case 4 + ed25519.SignatureSize:
+			s.sig = sig[4:]
+		case 4 + 8 + ed25519.SignatureSize:
+			s.Timestamp = binary.BigEndian.Uint64(sig[4:])
+			s.sig = sig[12:]
+		default:
+			continue
+		}
+
+		if len(sigs) == maxSignatures {
+			return nil, nil, errors.New("too many note signatures")
+		}
+
+		sigs = append(sigs, s)
+	}
+
+	return
+}
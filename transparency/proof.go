@@ -0,0 +1,224 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package transparency
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProofSuffix is the file name suffix of transparency proofs, which are
+// expected alongside the file they refer to.
+const ProofSuffix = ".proof"
+
+const proofHeader = "c2sp.org/tlog-proof@v1"
+
+// Checkpoint represents a transparency log checkpoint.
+type Checkpoint struct {
+	// Origin is the log origin.
+	Origin string
+	// Size is the tree size.
+	Size uint64
+	// Hash is the tree root hash.
+	Hash [HashSize]byte
+	// Time is the latest cosignature time attested by a witness quorum,
+	// it is zero when no quorum is required.
+	Time time.Time
+}
+
+// Proof represents a transparency log inclusion proof.
+type Proof struct {
+	// Index is the leaf index.
+	Index uint64
+	// Hashes holds the inclusion proof hashes.
+	Hashes [][HashSize]byte
+	// Checkpoint is the signed checkpoint the proof refers to.
+	Checkpoint []byte
+}
+
+// Policy represents a transparency log verification policy.
+type Policy struct {
+	// Log is the trusted log key.
+	Log *Verifier
+	// Witnesses holds the trusted witness keys.
+	Witnesses []*Verifier
+	// Quorum is the number of trusted witnesses which must cosign the
+	// checkpoint.
+	Quorum int
+	// MinSize is the minimum acceptable checkpoint tree size.
+	MinSize uint64
+}
+
+func decodeHash(s string) (h [HashSize]byte, err error) {
+	buf, err := base64.StdEncoding.DecodeString(s)
+
+	if err != nil || len(buf) != HashSize {
+		return h, errors.New("invalid hash")
+	}
+
+	copy(h[:], buf)
+
+	return
+}
+
+// ParseCheckpoint parses the text of a checkpoint signed note (see
+// ParseNote()), checkpoint extension lines are ignored.
+func ParseCheckpoint(text []byte) (c *Checkpoint, err error) {
+	lines := strings.Split(string(text), "\n")
+
+	if len(lines) < 4 || len(lines[0]) == 0 {
+		return nil, errors.New("malformed checkpoint")
+	}
+
+	c = &Checkpoint{
+		Origin: lines[0],
+	}
+
+	if c.Size, err = strconv.ParseUint(lines[1], 10, 64); err != nil || (len(lines[1]) > 1 && lines[1][0] == '0') {
+		return nil, errors.New("invalid checkpoint tree size")
+	}
+
+	if c.Hash, err = decodeHash(lines[2]); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint root, %v", err)
+	}
+
+	return
+}
+
+// ParseProof parses a c2sp.org/tlog-proof encoded inclusion proof.
+func ParseProof(buf []byte) (p *Proof, err error) {
+	header, buf, found := bytes.Cut(buf, []byte("\n\n"))
+
+	if !found {
+		return nil, errors.New("malformed proof")
+	}
+
+	lines := strings.Split(string(header), "\n")
+
+	if lines[0] != proofHeader {
+		return nil, errors.New("unsupported proof format")
+	}
+
+	lines = lines[1:]
+
+	if len(lines) > 0 && strings.HasPrefix(lines[0], "extra ") {
+		lines = lines[1:]
+	}
+
+	if len(lines) == 0 || !strings.HasPrefix(lines[0], "index ") {
+		return nil, errors.New("missing proof index")
+	}
+
+	p = &Proof{
+		Checkpoint: buf,
+	}
+
+	if p.Index, err = strconv.ParseUint(strings.TrimPrefix(lines[0], "index "), 10, 64); err != nil {
+		return nil, errors.New("invalid proof index")
+	}
+
+	for _, line := range lines[1:] {
+		h, err := decodeHash(line)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid proof, %v", err)
+		}
+
+		p.Hashes = append(p.Hashes, h)
+	}
+
+	return
+}
+
+// VerifyCheckpoint authenticates a signed checkpoint with the log key and the
+// witness quorum.
+func (p *Policy) VerifyCheckpoint(buf []byte) (c *Checkpoint, err error) {
+	if p.Log == nil || p.Log.alg != AlgEd25519 {
+		return nil, errors.New("invalid log key")
+	}
+
+	text, sigs, err := ParseNote(buf)
+
+	if err != nil {
+		return
+	}
+
+	if c, err = ParseCheckpoint(text); err != nil {
+		return
+	}
+
+	if c.Origin != p.Log.Name {
+		return nil, fmt.Errorf("checkpoint origin mismatch (%s)", c.Origin)
+	}
+
+	var signed bool
+	cosigned := make(map[*Verifier]uint64)
+
+	for _, s := range sigs {
+		if p.Log.Verify(text, s) {
+			signed = true
+		}
+
+		for _, w := range p.Witnesses {
+			if w.Verify(text, s) {
+				cosigned[w] = max(cosigned[w], s.Timestamp)
+			}
+		}
+	}
+
+	if !signed {
+		return nil, errors.New("invalid checkpoint signature")
+	}
+
+	if len(cosigned) < p.Quorum {
+		return nil, fmt.Errorf("insufficient witness cosignatures (%d < %d)", len(cosigned), p.Quorum)
+	}
+
+	if c.Size < p.MinSize {
+		return nil, fmt.Errorf("checkpoint tree size %d below minimum %d", c.Size, p.MinSize)
+	}
+
+	if p.Quorum == 0 {
+		return
+	}
+
+	// latest time preceded by the cosignatures of at least a quorum
+	times := slices.Sorted(maps.Values(cosigned))
+
+	if t := times[len(times)-p.Quorum]; t > 0 {
+		c.Time = time.Unix(int64(t), 0)
+	}
+
+	return
+}
+
+// Verify verifies that the argument entry is included in the transparency
+// log, according to the argument c2sp.org/tlog-proof encoded proof, returning
+// the authenticated checkpoint.
+func (p *Policy) Verify(proof []byte, entry []byte) (c *Checkpoint, err error) {
+	pr, err := ParseProof(proof)
+
+	if err != nil {
+		return
+	}
+
+	if c, err = p.VerifyCheckpoint(pr.Checkpoint); err != nil {
+		return nil, err
+	}
+
+	if err = VerifyInclusion(pr.Index, c.Size, LeafHash(entry), pr.Hashes, c.Hash); err != nil {
+		return nil, fmt.Errorf("invalid inclusion proof, %v", err)
+	}
+
+	return
+}
@@ -0,0 +1,253 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package transparency
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// checkError reports whether err matches the expected error substring, an
+// empty one expecting no error.
+func checkError(t *testing.T, name string, err error, want string) {
+	t.Helper()
+
+	switch {
+	case len(want) == 0 && err != nil:
+		t.Errorf("%s: unexpected error, %v", name, err)
+	case len(want) > 0 && (err == nil || !strings.Contains(err.Error(), want)):
+		t.Errorf("%s: error %v, want %q", name, err, want)
+	}
+}
+
+func TestParseCheckpoint(t *testing.T) {
+	root := base64.StdEncoding.EncodeToString(make([]byte, HashSize))
+
+	for _, tt := range []struct {
+		name string
+		text string
+		err  string
+	}{
+		{"valid", "example.com/log\n12\n" + root + "\n", ""},
+		{"extension lines", "example.com/log\n12\n" + root + "\next\n", ""},
+		{"missing origin", "\n12\n" + root + "\n", "malformed checkpoint"},
+		{"missing root", "example.com/log\n12\n", "malformed checkpoint"},
+		{"invalid size", "example.com/log\n-1\n" + root + "\n", "invalid checkpoint tree size"},
+		{"leading zero size", "example.com/log\n012\n" + root + "\n", "invalid checkpoint tree size"},
+		{"short root", "example.com/log\n12\nAAAA\n", "invalid checkpoint root"},
+	} {
+		c, err := ParseCheckpoint([]byte(tt.text))
+		checkError(t, tt.name, err, tt.err)
+
+		if err == nil && (c.Origin != "example.com/log" || c.Size != 12) {
+			t.Errorf("%s: invalid checkpoint %+v", tt.name, c)
+		}
+	}
+}
+
+func TestParseProof(t *testing.T) {
+	h := base64.StdEncoding.EncodeToString(make([]byte, HashSize))
+
+	for _, tt := range []struct {
+		name string
+		buf  string
+		err  string
+	}{
+		{"valid", proofHeader + "\nindex 3\n" + h + "\n" + h + "\n\ncheckpoint", ""},
+		{"extra data", proofHeader + "\nextra AAAA\nindex 3\n" + h + "\n\ncheckpoint", ""},
+		{"missing checkpoint", proofHeader + "\nindex 3\n" + h, "malformed proof"},
+		{"unsupported format", "c2sp.org/tlog-proof@v2\nindex 3\n\ncheckpoint", "unsupported proof format"},
+		{"missing index", proofHeader + "\n" + h + "\n\ncheckpoint", "missing proof index"},
+		{"invalid index", proofHeader + "\nindex x\n\ncheckpoint", "invalid proof index"},
+		{"invalid hash", proofHeader + "\nindex 3\nAAAA\n\ncheckpoint", "invalid proof, invalid hash"},
+	} {
+		p, err := ParseProof([]byte(tt.buf))
+		checkError(t, tt.name, err, tt.err)
+
+		if err == nil && (p.Index != 3 || string(p.Checkpoint) != "checkpoint") {
+			t.Errorf("%s: invalid proof %+v", tt.name, p)
+		}
+	}
+}
+
+func TestNewVerifier(t *testing.T) {
+	pub, _, _ := ed25519.GenerateKey(rand.Reader)
+
+	key := base64.StdEncoding.EncodeToString(append([]byte{AlgEd25519}, pub...))
+
+	vkey := func(name string, alg byte) string {
+		key := append([]byte{alg}, pub...)
+		return fmt.Sprintf("%s+%08x+%s", name, keyHash(name, key), base64.StdEncoding.EncodeToString(key))
+	}
+
+	for _, tt := range []struct {
+		name string
+		vkey string
+		err  string
+	}{
+		{"log key", vkey("example.com/log", AlgEd25519), ""},
+		{"witness key", vkey("example.com/witness", AlgCosignature), ""},
+		{"missing hash", "example.com/log", "invalid verifier key format"},
+		{"invalid name", vkey("example com", AlgEd25519), "invalid verifier key format"},
+		{"invalid hash", "example.com/log+zzzzzzzz+" + key, "invalid verifier key hash"},
+		{"short key", "example.com/log+01234567+AQ==", "invalid verifier key"},
+		{"unsupported algorithm", vkey("example.com/log", 0x02), "unsupported verifier key algorithm 0x2"},
+		{"hash mismatch", "example.com/witness+" + vkey("example.com/log", AlgEd25519)[16:], "verifier key hash mismatch"},
+	} {
+		_, err := NewVerifier(tt.vkey)
+		checkError(t, tt.name, err, tt.err)
+	}
+}
+
+func TestVerifyInclusion(t *testing.T) {
+	l0 := LeafHash([]byte("0"))
+	l1 := LeafHash([]byte("1"))
+	l2 := LeafHash([]byte("2"))
+	n01 := nodeHash(l0[:], l1[:])
+	root := nodeHash(n01[:], l2[:])
+
+	for _, tt := range []struct {
+		name  string
+		index uint64
+		leaf  [HashSize]byte
+		proof [][HashSize]byte
+		err   string
+	}{
+		{"first leaf", 0, l0, [][HashSize]byte{l1, l2}, ""},
+		{"last leaf", 2, l2, [][HashSize]byte{n01}, ""},
+		{"wrong leaf", 0, l1, [][HashSize]byte{l1, l2}, "root hash mismatch"},
+		{"short proof", 0, l0, [][HashSize]byte{l1}, "invalid proof size"},
+		{"long proof", 2, l2, [][HashSize]byte{n01, l0}, "invalid proof size"},
+		{"index out of range", 3, l2, [][HashSize]byte{n01}, "invalid leaf index"},
+	} {
+		err := VerifyInclusion(tt.index, 3, tt.leaf, tt.proof, root)
+		checkError(t, tt.name, err, tt.err)
+	}
+}
+
+// testSigner represents a signed note signer, for either the log or a witness.
+type testSigner struct {
+	name string
+	alg  byte
+	hash uint32
+	key  ed25519.PrivateKey
+	v    *Verifier
+}
+
+func newSigner(t *testing.T, name string, alg byte) *testSigner {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	key := append([]byte{alg}, pub...)
+	s := &testSigner{name: name, alg: alg, hash: keyHash(name, key), key: priv}
+
+	v, err := NewVerifier(fmt.Sprintf("%s+%08x+%s", name, s.hash, base64.StdEncoding.EncodeToString(key)))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.v = v
+
+	return s
+}
+
+// sign returns a signed note signature line over the argument text, with the
+// argument cosignature time for witnesses.
+func (s *testSigner) sign(text []byte, ts uint64) string {
+	sig := binary.BigEndian.AppendUint32(nil, s.hash)
+
+	if s.alg == AlgCosignature {
+		sig = binary.BigEndian.AppendUint64(sig, ts)
+		msg := append([]byte(cosignaturePrefix+strconv.FormatUint(ts, 10)+"\n"), text...)
+		sig = append(sig, ed25519.Sign(s.key, msg)...)
+	} else {
+		sig = append(sig, ed25519.Sign(s.key, text)...)
+	}
+
+	return sigPrefix + s.name + " " + base64.StdEncoding.EncodeToString(sig) + "\n"
+}
+
+func TestVerifyCheckpoint(t *testing.T) {
+	log := newSigner(t, "example.com/log", AlgEd25519)
+	other := newSigner(t, "example.com/log", AlgEd25519)
+	w1 := newSigner(t, "witness-1", AlgCosignature)
+	w2 := newSigner(t, "witness-2", AlgCosignature)
+	w3 := newSigner(t, "witness-3", AlgCosignature)
+
+	root := base64.StdEncoding.EncodeToString(make([]byte, HashSize))
+	text := []byte("example.com/log\n10\n" + root + "\n")
+
+	for _, tt := range []struct {
+		name    string
+		sigs    string
+		quorum  int
+		minSize uint64
+		time    int64
+		err     string
+	}{
+		{
+			name: "log signature",
+			sigs: log.sign(text, 0),
+		},
+		{
+			name:   "witness quorum",
+			sigs:   log.sign(text, 0) + w1.sign(text, 100) + w2.sign(text, 300) + w3.sign(text, 200),
+			quorum: 2,
+			time:   200,
+		},
+		{
+			name:   "full witness quorum",
+			sigs:   log.sign(text, 0) + w1.sign(text, 100) + w2.sign(text, 300) + w3.sign(text, 200),
+			quorum: 3,
+			time:   100,
+		},
+		{
+			name:   "duplicate cosignature",
+			sigs:   log.sign(text, 0) + w1.sign(text, 100) + w1.sign(text, 500),
+			quorum: 2,
+			err:    "insufficient witness cosignatures (1 < 2)",
+		},
+		{
+			name: "untrusted log key",
+			sigs: other.sign(text, 0),
+			err:  "invalid checkpoint signature",
+		},
+		{
+			name:    "tree size rollback",
+			sigs:    log.sign(text, 0),
+			minSize: 11,
+			err:     "checkpoint tree size 10 below minimum 11",
+		},
+	} {
+		p := &Policy{
+			Log:       log.v,
+			Witnesses: []*Verifier{w1.v, w2.v, w3.v},
+			Quorum:    tt.quorum,
+			MinSize:   tt.minSize,
+		}
+
+		c, err := p.VerifyCheckpoint(append(append(bytes.Clone(text), '\n'), tt.sigs...))
+		checkError(t, tt.name, err, tt.err)
+
+		if err != nil {
+			continue
+		}
+
+		if c.Size != 10 || c.Origin != log.name {
+			t.Errorf("%s: invalid checkpoint %+v", tt.name, c)
+		}
+
+		if tt.time == 0 && !c.Time.IsZero() || tt.time != 0 && c.Time.Unix() != tt.time {
+			t.Errorf("%s: time %v, want %d", tt.name, c.Time, tt.time)
+		}
+	}
+}
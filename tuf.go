@@ -0,0 +1,59 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/usbarmory/armory-boot/disk"
+	"github.com/usbarmory/armory-boot/rollback"
+	"github.com/usbarmory/armory-boot/tuf"
+
+	"github.com/usbarmory/tamago/soc/nxp/usdhc"
+)
+
+// loadTargets verifies the TUF repository metadata of the boot partition,
+// starting from the trusted root metadata set at compile time.
+//
+// Metadata versions lower than the ones held in the rollback state are
+// refused, and the state is raised to the verified ones. Metadata expiry is
+// enforced against the SNVS RTC or, when not valid, the rollback state trusted
+// time.
+func loadTargets(card *usdhc.USDHC, part *disk.Partition, rs *rollback.State) (repo *tuf.Repository, err error) {
+	root, err := base64.StdEncoding.DecodeString(TUFRoot)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid trusted root encoding, %v", err)
+	}
+
+	t, valid := now(rs.Time)
+
+	if !valid {
+		t = rs.Time
+
+		if t.IsZero() {
+			log.Printf("armory-boot: invalid RTC and no trusted time, skipping TUF metadata expiry verification")
+		} else {
+			log.Printf("armory-boot: invalid RTC, verifying TUF metadata expiry against trusted time %s", t.UTC().Format(time.RFC3339))
+		}
+	}
+
+	if repo, err = tuf.LoadVersions(part, tuf.DefaultPath, root, rs.TUF, t); err != nil {
+		return
+	}
+
+	log.Printf("armory-boot: TUF root version %d, targets version %d", repo.Root.Version, repo.Targets.Version)
+
+	if v := repo.Versions(); v != rs.TUF {
+		rs.TUF = v
+		err = saveRollbackState(card, rs)
+	}
+
+	return
+}
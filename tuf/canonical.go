@@ -0,0 +1,111 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package tuf
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// canonicalJSON re-encodes a JSON value in the OLPC canonical JSON format
+// used for TUF metadata signatures, where object keys are sorted, whitespace
+// is omitted, strings only escape quotes and backslashes and only integer
+// numbers are allowed.
+func canonicalJSON(buf []byte) (out []byte, err error) {
+	var v any
+
+	d := json.NewDecoder(bytes.NewReader(buf))
+	d.UseNumber()
+
+	if err = d.Decode(&v); err != nil {
+		return
+	}
+
+	if d.More() {
+		return nil, errors.New("trailing data")
+	}
+
+	b := new(bytes.Buffer)
+
+	if err = encodeCanonical(b, v); err != nil {
+		return
+	}
+
+	return b.Bytes(), nil
+}
+
+func encodeString(b *bytes.Buffer, s string) {
+	b.WriteByte('"')
+	b.WriteString(strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s))
+	b.WriteByte('"')
+}
+
+func encodeCanonical(b *bytes.Buffer, v any) (err error) {
+	switch v := v.(type) {
+	case nil:
+		b.WriteString("null")
+	case bool:
+		if v {
+			b.WriteString("true")
+		} else {
+			b.WriteString("false")
+		}
+	case json.Number:
+		if _, err = v.Int64(); err != nil {
+			return fmt.Errorf("non-integer number %s", v)
+		}
+
+		b.WriteString(v.String())
+	case string:
+		encodeString(b, v)
+	case []any:
+		b.WriteByte('[')
+
+		for i, e := range v {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+
+			if err = encodeCanonical(b, e); err != nil {
+				return
+			}
+		}
+
+		b.WriteByte(']')
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+
+		for k := range v {
+			keys = append(keys, k)
+		}
+
+		slices.Sort(keys)
+		b.WriteByte('{')
+
+		for i, k := range keys {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+
+			encodeString(b, k)
+			b.WriteByte(':')
+
+			if err = encodeCanonical(b, v[k]); err != nil {
+				return
+			}
+		}
+
+		b.WriteByte('}')
+	default:
+		return fmt.Errorf("unsupported type %T", v)
+	}
+
+	return
+}
@@ -0,0 +1,317 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// Package tuf implements offline verification of The Update Framework (TUF)
+// repository metadata, for the armory-boot TUF support.
+//
+// The root, timestamp, snapshot and targets metadata are verified, as per the
+// TUF specification client workflow (https://theupdateframework.github.io/specification/latest/#detailed-client-workflow),
+// starting from a trusted root and following its rotations, enforcing role
+// signature thresholds, versions, expiry and metadata hashes.
+//
+// Delegated targets roles are not supported.
+package tuf
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Role names
+const (
+	RoleRoot      = "root"
+	RoleTimestamp = "timestamp"
+	RoleSnapshot  = "snapshot"
+	RoleTargets   = "targets"
+)
+
+// Key types and signature schemes
+const (
+	KeyEd25519   = "ed25519"
+	KeyECDSA     = "ecdsa"
+	KeyECDSAP256 = "ecdsa-sha2-nistp256"
+	KeyRSA       = "rsa"
+	SchemePSS    = "rsassa-pss-sha256"
+)
+
+// specVersion is the supported TUF specification major version.
+const specVersion = "1"
+
+// Signature represents a TUF metadata signature.
+type Signature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+// envelope represents signed TUF metadata.
+type envelope struct {
+	Signatures []Signature     `json:"signatures"`
+	Signed     json.RawMessage `json:"signed"`
+}
+
+// Common represents the fields common to all TUF metadata.
+type Common struct {
+	Type        string    `json:"_type"`
+	SpecVersion string    `json:"spec_version"`
+	Version     int64     `json:"version"`
+	Expires     time.Time `json:"expires"`
+}
+
+// Key represents a TUF public key.
+type Key struct {
+	Type   string `json:"keytype"`
+	Scheme string `json:"scheme"`
+	Value  struct {
+		Public string `json:"public"`
+	} `json:"keyval"`
+}
+
+// Role represents the keys and signature threshold of a top-level role.
+type Role struct {
+	KeyIDs    []string `json:"keyids"`
+	Threshold int      `json:"threshold"`
+}
+
+// Root represents TUF root metadata.
+type Root struct {
+	Common
+
+	ConsistentSnapshot bool             `json:"consistent_snapshot"`
+	Keys               map[string]*Key  `json:"keys"`
+	Roles              map[string]*Role `json:"roles"`
+}
+
+// MetaFile represents a metadata file listed in timestamp or snapshot
+// metadata.
+type MetaFile struct {
+	Version int64             `json:"version"`
+	Length  int64             `json:"length,omitempty"`
+	Hashes  map[string]string `json:"hashes,omitempty"`
+}
+
+// Timestamp represents TUF timestamp metadata.
+type Timestamp struct {
+	Common
+
+	Meta map[string]*MetaFile `json:"meta"`
+}
+
+// Snapshot represents TUF snapshot metadata.
+type Snapshot struct {
+	Common
+
+	Meta map[string]*MetaFile `json:"meta"`
+}
+
+// TargetFile represents a target file listed in targets metadata.
+type TargetFile struct {
+	Length int64             `json:"length"`
+	Hashes map[string]string `json:"hashes"`
+}
+
+// Targets represents TUF targets metadata.
+type Targets struct {
+	Common
+
+	Targets map[string]*TargetFile `json:"targets"`
+}
+
+// metadata represents the signed portion of TUF metadata.
+type metadata interface {
+	// validate rejects null entries of the metadata maps.
+	validate() error
+}
+
+// checkEntries returns an error when any of the argument map entries is null.
+func checkEntries[T any](field string, m map[string]*T) (err error) {
+	for name, v := range m {
+		if v == nil {
+			return fmt.Errorf("null %s entry %q", field, name)
+		}
+	}
+
+	return
+}
+
+func (r *Root) validate() (err error) {
+	if err = checkEntries("keys", r.Keys); err != nil {
+		return
+	}
+
+	return checkEntries("roles", r.Roles)
+}
+
+func (t *Timestamp) validate() error {
+	return checkEntries("meta", t.Meta)
+}
+
+func (s *Snapshot) validate() error {
+	return checkEntries("meta", s.Meta)
+}
+
+func (t *Targets) validate() error {
+	return checkEntries("targets", t.Targets)
+}
+
+// publicKey returns the parsed public key.
+func (k *Key) publicKey() (pub crypto.PublicKey, err error) {
+	switch {
+	case k.Type == KeyEd25519 && k.Scheme == KeyEd25519:
+		buf, err := hex.DecodeString(k.Value.Public)
+
+		if err != nil || len(buf) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid ed25519 key")
+		}
+
+		return ed25519.PublicKey(buf), nil
+	case (k.Type == KeyECDSA || k.Type == KeyECDSAP256) && k.Scheme == KeyECDSAP256:
+		if buf, err := hex.DecodeString(k.Value.Public); err == nil {
+			x, y := elliptic.Unmarshal(elliptic.P256(), buf)
+
+			if x == nil {
+				return nil, errors.New("invalid ecdsa key")
+			}
+
+			return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+		}
+
+		pub, err = parsePEM(k.Value.Public)
+
+		if p, ok := pub.(*ecdsa.PublicKey); err != nil || !ok || p.Curve != elliptic.P256() {
+			return nil, errors.New("invalid ecdsa key")
+		}
+
+		return
+	case k.Type == KeyRSA && k.Scheme == SchemePSS:
+		pub, err = parsePEM(k.Value.Public)
+
+		if _, ok := pub.(*rsa.PublicKey); err != nil || !ok {
+			return nil, errors.New("invalid rsa key")
+		}
+
+		return
+	}
+
+	return nil, fmt.Errorf("unsupported key type %s (%s)", k.Type, k.Scheme)
+}
+
+func parsePEM(s string) (pub crypto.PublicKey, err error) {
+	block, _ := pem.Decode([]byte(s))
+
+	if block == nil {
+		return nil, errors.New("invalid PEM key")
+	}
+
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// verify authenticates a signature over the argument message.
+func (k *Key) verify(msg []byte, sig []byte) bool {
+	pub, err := k.publicKey()
+
+	if err != nil {
+		return false
+	}
+
+	switch pub := pub.(type) {
+	case ed25519.PublicKey:
+		return ed25519.Verify(pub, msg, sig)
+	case *ecdsa.PublicKey:
+		h := sha256.Sum256(msg)
+		return ecdsa.VerifyASN1(pub, h[:], sig)
+	case *rsa.PublicKey:
+		h := sha256.Sum256(msg)
+		return rsa.VerifyPSS(pub, crypto.SHA256, h[:], sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto}) == nil
+	}
+
+	return false
+}
+
+// parse decodes signed metadata, returning its envelope and decoding the
+// signed portion in the argument value.
+func parse(buf []byte, role string, v metadata) (env *envelope, err error) {
+	env = &envelope{}
+
+	if err = json.Unmarshal(buf, env); err != nil {
+		return nil, fmt.Errorf("invalid %s metadata, %v", role, err)
+	}
+
+	c := &Common{}
+
+	if err = json.Unmarshal(env.Signed, c); err != nil {
+		return nil, fmt.Errorf("invalid %s metadata, %v", role, err)
+	}
+
+	if !strings.EqualFold(c.Type, role) {
+		return nil, fmt.Errorf("invalid %s metadata type %q", role, c.Type)
+	}
+
+	if major, _, _ := strings.Cut(c.SpecVersion, "."); major != specVersion {
+		return nil, fmt.Errorf("unsupported %s metadata specification version %q", role, c.SpecVersion)
+	}
+
+	if err = json.Unmarshal(env.Signed, v); err != nil {
+		return nil, fmt.Errorf("invalid %s metadata, %v", role, err)
+	}
+
+	if err = v.validate(); err != nil {
+		return nil, fmt.Errorf("invalid %s metadata, %v", role, err)
+	}
+
+	return
+}
+
+// verifyRole authenticates signed metadata against the argument role keys
+// and threshold, each key is counted once regardless of its key identifiers.
+func (r *Root) verifyRole(name string, env *envelope) (err error) {
+	role, ok := r.Roles[name]
+
+	if !ok || role.Threshold < 1 {
+		return fmt.Errorf("invalid %s role", name)
+	}
+
+	msg, err := canonicalJSON(env.Signed)
+
+	if err != nil {
+		return fmt.Errorf("invalid %s metadata, %v", name, err)
+	}
+
+	valid := make(map[string]bool)
+
+	for _, s := range env.Signatures {
+		key, ok := r.Keys[s.KeyID]
+
+		if !ok || !slices.Contains(role.KeyIDs, s.KeyID) {
+			continue
+		}
+
+		sig, err := hex.DecodeString(s.Sig)
+
+		if err != nil || !key.verify(msg, sig) {
+			continue
+		}
+
+		valid[key.Type+":"+key.Value.Public] = true
+	}
+
+	if len(valid) < role.Threshold {
+		return fmt.Errorf("insufficient %s signatures (%d < %d)", name, len(valid), role.Threshold)
+	}
+
+	return
+}
@@ -0,0 +1,374 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package tuf
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultPath is the default directory holding TUF metadata files.
+const DefaultPath = "/boot/tuf"
+
+// maxRootRotations is the maximum number of root rotations followed.
+const maxRootRotations = 1024
+
+// Partition represents the file system holding TUF metadata and target files
+// (e.g. *disk.Partition).
+type Partition interface {
+	// ReadAll returns the contents of the file at the argument absolute
+	// path, fs.ErrNotExist is returned for missing files.
+	ReadAll(path string) ([]byte, error)
+}
+
+// Repository represents verified TUF repository metadata.
+type Repository struct {
+	// Root is the trusted root metadata, after rotations.
+	Root *Root
+	// Timestamp is the verified timestamp metadata.
+	Timestamp *Timestamp
+	// Snapshot is the verified snapshot metadata.
+	Snapshot *Snapshot
+	// Targets is the verified top-level targets metadata.
+	Targets *Targets
+
+	part    Partition
+	dir     string
+	now     time.Time
+	trusted Versions
+}
+
+// Versions represents the versions of the top-level metadata, trusted
+// versions must be persisted across updates as metadata with lower versions
+// is rejected (see LoadVersions()).
+type Versions struct {
+	Root      int64
+	Timestamp int64
+	Snapshot  int64
+	Targets   int64
+}
+
+// ParseRoot parses trusted root metadata, which is verified only against its
+// own root role keys.
+func ParseRoot(buf []byte) (root *Root, err error) {
+	root = &Root{}
+	env, err := parse(buf, RoleRoot, root)
+
+	if err != nil {
+		return
+	}
+
+	if err = root.verifyRole(RoleRoot, env); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// Load verifies the TUF metadata held in the argument partition directory,
+// starting from the argument trusted root metadata. Root rotations are
+// followed through `<version>.root.json` files.
+//
+// The argument time is used to reject expired metadata, a zero value disables
+// expiry checks.
+func Load(part Partition, dir string, root []byte, now time.Time) (repo *Repository, err error) {
+	return LoadVersions(part, dir, root, Versions{}, now)
+}
+
+// LoadVersions verifies the TUF metadata like Load(), rejecting metadata
+// whose version is lower than the argument trusted one. This prevents
+// rollback attacks, as well as the removal of rotated root metadata, once the
+// trusted versions are raised to the verified ones (see Versions()).
+func LoadVersions(part Partition, dir string, root []byte, trusted Versions, now time.Time) (repo *Repository, err error) {
+	repo = &Repository{
+		part:    part,
+		dir:     dir,
+		now:     now,
+		trusted: trusted,
+	}
+
+	if repo.Root, err = ParseRoot(root); err != nil {
+		return nil, fmt.Errorf("invalid trusted root, %v", err)
+	}
+
+	if err = repo.updateRoot(); err != nil {
+		return nil, err
+	}
+
+	if err = repo.updateTimestamp(); err != nil {
+		return nil, err
+	}
+
+	if err = repo.updateSnapshot(); err != nil {
+		return nil, err
+	}
+
+	if err = repo.updateTargets(); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// Versions returns the verified metadata versions.
+func (repo *Repository) Versions() Versions {
+	return Versions{
+		Root:      repo.Root.Version,
+		Timestamp: repo.Timestamp.Version,
+		Snapshot:  repo.Snapshot.Version,
+		Targets:   repo.Targets.Version,
+	}
+}
+
+func checkVersion(role string, version int64, trusted int64) (err error) {
+	if version < trusted {
+		return fmt.Errorf("%s version %d is lower than trusted (%d)", role, version, trusted)
+	}
+
+	return
+}
+
+func (repo *Repository) checkExpiry(role string, c *Common) (err error) {
+	if !repo.now.IsZero() && repo.now.After(c.Expires) {
+		return fmt.Errorf("%s metadata expired on %s", role, c.Expires.UTC().Format(time.RFC3339))
+	}
+
+	return
+}
+
+// read returns the contents of a metadata file, verifying its length and
+// hashes when listed.
+func (repo *Repository) read(name string, meta *MetaFile) (buf []byte, err error) {
+	if buf, err = repo.part.ReadAll(path.Join(repo.dir, name)); err != nil {
+		return
+	}
+
+	if meta == nil {
+		return
+	}
+
+	if meta.Length > 0 && int64(len(buf)) != meta.Length {
+		return nil, fmt.Errorf("%s length mismatch", name)
+	}
+
+	if len(meta.Hashes) > 0 {
+		if err = verifyHashes(buf, meta.Hashes); err != nil {
+			return nil, fmt.Errorf("%s %v", name, err)
+		}
+	}
+
+	return
+}
+
+// updateRoot follows root rotations, each new root version must be signed by
+// the threshold of both the trusted and the new root keys.
+func (repo *Repository) updateRoot() (err error) {
+	for range maxRootRotations {
+		version := repo.Root.Version + 1
+		buf, err := repo.read(strconv.FormatInt(version, 10)+".root.json", nil)
+
+		if errors.Is(err, fs.ErrNotExist) {
+			break
+		} else if err != nil {
+			return fmt.Errorf("invalid root metadata, %v", err)
+		}
+
+		root := &Root{}
+		env, err := parse(buf, RoleRoot, root)
+
+		if err != nil {
+			return err
+		}
+
+		if err = repo.Root.verifyRole(RoleRoot, env); err != nil {
+			return fmt.Errorf("invalid root version %d, %v", version, err)
+		}
+
+		if err = root.verifyRole(RoleRoot, env); err != nil {
+			return fmt.Errorf("invalid root version %d, %v", version, err)
+		}
+
+		if root.Version != version {
+			return fmt.Errorf("root version mismatch (%d != %d)", root.Version, version)
+		}
+
+		repo.Root = root
+	}
+
+	// a missing rotation cannot be told apart from the latest root
+	if err = checkVersion(RoleRoot, repo.Root.Version, repo.trusted.Root); err != nil {
+		return
+	}
+
+	return repo.checkExpiry(RoleRoot, &repo.Root.Common)
+}
+
+func (repo *Repository) updateTimestamp() (err error) {
+	buf, err := repo.read(RoleTimestamp+".json", nil)
+
+	if err != nil {
+		return fmt.Errorf("invalid timestamp metadata, %v", err)
+	}
+
+	repo.Timestamp = &Timestamp{}
+	env, err := parse(buf, RoleTimestamp, repo.Timestamp)
+
+	if err != nil {
+		return
+	}
+
+	if err = repo.Root.verifyRole(RoleTimestamp, env); err != nil {
+		return
+	}
+
+	if err = checkVersion(RoleTimestamp, repo.Timestamp.Version, repo.trusted.Timestamp); err != nil {
+		return
+	}
+
+	if _, ok := repo.Timestamp.Meta[RoleSnapshot+".json"]; !ok {
+		return errors.New("invalid timestamp metadata, missing snapshot")
+	}
+
+	return repo.checkExpiry(RoleTimestamp, &repo.Timestamp.Common)
+}
+
+// metaName returns the metadata file name, prefixed with its version for
+// repositories using consistent snapshots.
+func (repo *Repository) metaName(role string, meta *MetaFile) string {
+	if repo.Root.ConsistentSnapshot {
+		return strconv.FormatInt(meta.Version, 10) + "." + role + ".json"
+	}
+
+	return role + ".json"
+}
+
+func (repo *Repository) updateSnapshot() (err error) {
+	meta := repo.Timestamp.Meta[RoleSnapshot+".json"]
+	buf, err := repo.read(repo.metaName(RoleSnapshot, meta), meta)
+
+	if err != nil {
+		return fmt.Errorf("invalid snapshot metadata, %v", err)
+	}
+
+	repo.Snapshot = &Snapshot{}
+	env, err := parse(buf, RoleSnapshot, repo.Snapshot)
+
+	if err != nil {
+		return
+	}
+
+	if err = repo.Root.verifyRole(RoleSnapshot, env); err != nil {
+		return
+	}
+
+	if repo.Snapshot.Version != meta.Version {
+		return fmt.Errorf("snapshot version mismatch (%d != %d)", repo.Snapshot.Version, meta.Version)
+	}
+
+	if err = checkVersion(RoleSnapshot, repo.Snapshot.Version, repo.trusted.Snapshot); err != nil {
+		return
+	}
+
+	if _, ok := repo.Snapshot.Meta[RoleTargets+".json"]; !ok {
+		return errors.New("invalid snapshot metadata, missing targets")
+	}
+
+	return repo.checkExpiry(RoleSnapshot, &repo.Snapshot.Common)
+}
+
+func (repo *Repository) updateTargets() (err error) {
+	meta := repo.Snapshot.Meta[RoleTargets+".json"]
+	buf, err := repo.read(repo.metaName(RoleTargets, meta), meta)
+
+	if err != nil {
+		return fmt.Errorf("invalid targets metadata, %v", err)
+	}
+
+	repo.Targets = &Targets{}
+	env, err := parse(buf, RoleTargets, repo.Targets)
+
+	if err != nil {
+		return
+	}
+
+	if err = repo.Root.verifyRole(RoleTargets, env); err != nil {
+		return
+	}
+
+	if repo.Targets.Version != meta.Version {
+		return fmt.Errorf("targets version mismatch (%d != %d)", repo.Targets.Version, meta.Version)
+	}
+
+	if err = checkVersion(RoleTargets, repo.Targets.Version, repo.trusted.Targets); err != nil {
+		return
+	}
+
+	return repo.checkExpiry(RoleTargets, &repo.Targets.Common)
+}
+
+// Verify authenticates a target file, identified by its absolute path within
+// the partition (e.g. `/boot/zImage` for the `boot/zImage` target), against
+// the targets metadata length and hashes.
+func (repo *Repository) Verify(p string, buf []byte) (err error) {
+	name := strings.TrimPrefix(p, "/")
+	target, ok := repo.Targets.Targets[name]
+
+	if !ok {
+		return fmt.Errorf("untrusted target %s", name)
+	}
+
+	if int64(len(buf)) != target.Length {
+		return fmt.Errorf("target %s length mismatch", name)
+	}
+
+	if err = verifyHashes(buf, target.Hashes); err != nil {
+		return fmt.Errorf("target %s %v", name, err)
+	}
+
+	return
+}
+
+// verifyHashes verifies the argument input against all supported hashes
+// (sha256, sha512), at least one of which must be present.
+func verifyHashes(buf []byte, hashes map[string]string) (err error) {
+	var n int
+
+	for alg, h := range hashes {
+		var sum []byte
+
+		switch alg {
+		case "sha256":
+			s := sha256.Sum256(buf)
+			sum = s[:]
+		case "sha512":
+			s := sha512.Sum512(buf)
+			sum = s[:]
+		default:
+			continue
+		}
+
+		if hash, err := hex.DecodeString(h); err != nil || !bytes.Equal(hash, sum) {
+			return fmt.Errorf("%s hash mismatch", alg)
+		}
+
+		n++
+	}
+
+	if n == 0 {
+		return errors.New("no supported hash")
+	}
+
+	return
+}
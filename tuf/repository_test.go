@@ -0,0 +1,355 @@
+// Copyright (c) The armory-boot authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package tuf
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/fs"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memPart implements Partition over in-memory files.
+type memPart map[string][]byte
+
+func (p memPart) ReadAll(name string) ([]byte, error) {
+	if buf, ok := p[name]; ok {
+		return buf, nil
+	}
+
+	return nil, fs.ErrNotExist
+}
+
+var testTarget = []byte("{}")
+
+// repository represents test repository metadata, signed on load with the
+// same key for all roles unless overridden.
+type repository struct {
+	key   ed25519.PrivateKey
+	other ed25519.PrivateKey
+
+	root      *Root
+	rotation  *Root
+	timestamp *Timestamp
+	snapshot  *Snapshot
+	targets   *Targets
+
+	// signers overrides the signing key of a role
+	signers map[string]ed25519.PrivateKey
+	// files overrides the contents of metadata files
+	files map[string][]byte
+}
+
+func common(role string, version int64, expires time.Time) Common {
+	return Common{
+		Type:        role,
+		SpecVersion: "1.0.31",
+		Version:     version,
+		Expires:     expires,
+	}
+}
+
+func newRepository() (r *repository) {
+	r = &repository{
+		signers: make(map[string]ed25519.PrivateKey),
+		files:   make(map[string][]byte),
+	}
+
+	_, r.key, _ = ed25519.GenerateKey(rand.Reader)
+	_, r.other, _ = ed25519.GenerateKey(rand.Reader)
+
+	expires := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	key := &Key{Type: KeyEd25519, Scheme: KeyEd25519}
+	key.Value.Public = hex.EncodeToString(r.key.Public().(ed25519.PublicKey))
+
+	r.root = &Root{
+		Common: common(RoleRoot, 1, expires),
+		Keys:   map[string]*Key{"k": key},
+		Roles:  make(map[string]*Role),
+	}
+
+	for _, role := range []string{RoleRoot, RoleTimestamp, RoleSnapshot, RoleTargets} {
+		r.root.Roles[role] = &Role{KeyIDs: []string{"k"}, Threshold: 1}
+	}
+
+	sum := sha256.Sum256(testTarget)
+
+	r.targets = &Targets{
+		Common: common(RoleTargets, 3, expires),
+		Targets: map[string]*TargetFile{
+			"boot/armory-boot.conf": {
+				Length: int64(len(testTarget)),
+				Hashes: map[string]string{"sha256": hex.EncodeToString(sum[:])},
+			},
+		},
+	}
+
+	r.snapshot = &Snapshot{
+		Common: common(RoleSnapshot, 5, expires),
+		Meta:   map[string]*MetaFile{RoleTargets + ".json": {Version: 3}},
+	}
+
+	r.timestamp = &Timestamp{
+		Common: common(RoleTimestamp, 9, expires),
+		Meta:   map[string]*MetaFile{RoleSnapshot + ".json": {Version: 5}},
+	}
+
+	return
+}
+
+func (r *repository) sign(t *testing.T, role string, v any) []byte {
+	buf, err := json.Marshal(v)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := canonicalJSON(buf)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := r.key
+
+	if k, ok := r.signers[role]; ok {
+		key = k
+	}
+
+	env := &envelope{
+		Signatures: []Signature{{KeyID: "k", Sig: hex.EncodeToString(ed25519.Sign(key, msg))}},
+		Signed:     msg,
+	}
+
+	if buf, err = json.Marshal(env); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf
+}
+
+// load signs the repository metadata and verifies it.
+func (r *repository) load(t *testing.T, trusted Versions, now time.Time) (*Repository, error) {
+	part := memPart{
+		path.Join(DefaultPath, RoleTimestamp+".json"): r.sign(t, RoleTimestamp, r.timestamp),
+		path.Join(DefaultPath, RoleSnapshot+".json"):  r.sign(t, RoleSnapshot, r.snapshot),
+		path.Join(DefaultPath, RoleTargets+".json"):   r.sign(t, RoleTargets, r.targets),
+	}
+
+	if r.rotation != nil {
+		part[path.Join(DefaultPath, "2.root.json")] = r.sign(t, RoleRoot, r.rotation)
+	}
+
+	for name, buf := range r.files {
+		part[path.Join(DefaultPath, name)] = buf
+	}
+
+	return LoadVersions(part, DefaultPath, r.sign(t, RoleRoot, r.root), trusted, now)
+}
+
+func TestLoad(t *testing.T) {
+	now := time.Now()
+
+	for _, tt := range []struct {
+		name    string
+		setup   func(r *repository)
+		trusted Versions
+		// noExpiry disables expiry checks
+		noExpiry bool
+		err      string
+	}{
+		{
+			name: "valid",
+		},
+		{
+			name:    "trusted versions",
+			trusted: Versions{Root: 1, Timestamp: 9, Snapshot: 5, Targets: 3},
+		},
+		{
+			name: "root rotation",
+			setup: func(r *repository) {
+				rotation := *r.root
+				rotation.Version = 2
+				r.rotation = &rotation
+			},
+			trusted: Versions{Root: 2},
+		},
+		{
+			name:    "removed root rotation",
+			trusted: Versions{Root: 2},
+			err:     "root version 1 is lower than trusted (2)",
+		},
+		{
+			name: "root rotation version mismatch",
+			setup: func(r *repository) {
+				rotation := *r.root
+				rotation.Version = 3
+				r.rotation = &rotation
+			},
+			err: "root version mismatch (3 != 2)",
+		},
+		{
+			name:    "timestamp rollback",
+			trusted: Versions{Timestamp: 10},
+			err:     "timestamp version 9 is lower than trusted (10)",
+		},
+		{
+			name:    "snapshot rollback",
+			trusted: Versions{Snapshot: 6},
+			err:     "snapshot version 5 is lower than trusted (6)",
+		},
+		{
+			name:    "targets rollback",
+			trusted: Versions{Targets: 4},
+			err:     "targets version 3 is lower than trusted (4)",
+		},
+		{
+			name: "expired timestamp",
+			setup: func(r *repository) {
+				r.timestamp.Expires = now.Add(-time.Hour).UTC().Truncate(time.Second)
+			},
+			err: "timestamp metadata expired",
+		},
+		{
+			name: "expiry disabled",
+			setup: func(r *repository) {
+				r.timestamp.Expires = now.Add(-time.Hour).UTC().Truncate(time.Second)
+			},
+			noExpiry: true,
+		},
+		{
+			name: "untrusted timestamp key",
+			setup: func(r *repository) {
+				r.signers[RoleTimestamp] = r.other
+			},
+			err: "insufficient timestamp signatures (0 < 1)",
+		},
+		{
+			name: "snapshot version mismatch",
+			setup: func(r *repository) {
+				r.timestamp.Meta[RoleSnapshot+".json"].Version = 4
+			},
+			err: "snapshot version mismatch (5 != 4)",
+		},
+		{
+			name: "snapshot hash mismatch",
+			setup: func(r *repository) {
+				r.timestamp.Meta[RoleSnapshot+".json"].Hashes = map[string]string{"sha256": strings.Repeat("00", 32)}
+			},
+			err: "sha256 hash mismatch",
+		},
+		{
+			name: "missing targets",
+			setup: func(r *repository) {
+				delete(r.snapshot.Meta, RoleTargets+".json")
+			},
+			err: "invalid snapshot metadata, missing targets",
+		},
+		{
+			name: "null snapshot",
+			setup: func(r *repository) {
+				r.timestamp.Meta[RoleSnapshot+".json"] = nil
+			},
+			err: `invalid timestamp metadata, null meta entry "snapshot.json"`,
+		},
+		{
+			name: "null targets",
+			setup: func(r *repository) {
+				r.snapshot.Meta[RoleTargets+".json"] = nil
+			},
+			err: `invalid snapshot metadata, null meta entry "targets.json"`,
+		},
+		{
+			name: "null target",
+			setup: func(r *repository) {
+				r.targets.Targets["boot/zImage"] = nil
+			},
+			err: `invalid targets metadata, null targets entry "boot/zImage"`,
+		},
+		{
+			name: "null role",
+			setup: func(r *repository) {
+				r.root.Roles["mirrors"] = nil
+			},
+			err: `invalid root metadata, null roles entry "mirrors"`,
+		},
+		{
+			name: "invalid type",
+			setup: func(r *repository) {
+				r.targets.Type = RoleSnapshot
+			},
+			err: "invalid targets metadata type",
+		},
+		{
+			name: "missing timestamp",
+			setup: func(r *repository) {
+				r.files[RoleTimestamp+".json"] = []byte("")
+			},
+			err: "invalid timestamp metadata",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRepository()
+
+			if tt.setup != nil {
+				tt.setup(r)
+			}
+
+			at := now
+
+			if tt.noExpiry {
+				at = time.Time{}
+			}
+
+			repo, err := r.load(t, tt.trusted, at)
+
+			switch {
+			case len(tt.err) == 0 && err != nil:
+				t.Fatalf("unexpected error, %v", err)
+			case len(tt.err) > 0 && err == nil:
+				t.Fatalf("missing error, want %q", tt.err)
+			case len(tt.err) > 0 && !strings.Contains(err.Error(), tt.err):
+				t.Fatalf("error %q, want %q", err, tt.err)
+			case err == nil && repo.Versions().Timestamp != 9:
+				t.Fatalf("invalid versions %+v", repo.Versions())
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	repo, err := newRepository().load(t, Versions{}, time.Now())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		path string
+		buf  []byte
+		err  string
+	}{
+		{"/boot/armory-boot.conf", testTarget, ""},
+		{"/boot/zImage", testTarget, "untrusted target boot/zImage"},
+		{"/boot/armory-boot.conf", []byte("{ }"), "length mismatch"},
+		{"/boot/armory-boot.conf", []byte("[]"), "sha256 hash mismatch"},
+	} {
+		err := repo.Verify(tt.path, tt.buf)
+
+		switch {
+		case len(tt.err) == 0 && err != nil:
+			t.Errorf("%s: unexpected error, %v", tt.path, err)
+		case len(tt.err) > 0 && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s: error %v, want %q", tt.path, err, tt.err)
+		}
+	}
+}